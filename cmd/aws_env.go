@@ -0,0 +1,55 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/DimmKirr/atun/internal/config"
+	"github.com/DimmKirr/atun/internal/ssh"
+	"github.com/spf13/cobra"
+)
+
+// awsEnvCmd represents the aws-env command
+var awsEnvCmd = &cobra.Command{
+	Use:   "aws-env",
+	Short: "Print export lines with AWS credentials",
+	Long: `Print export lines with credentials of the atun AWS session. MFA and SSO sessions are refreshed as needed.
+
+Example:
+  eval "$(atun aws-env --profile dev)"`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		credentials, err := getProfileCredentials(cmd)
+		if err != nil {
+			return err
+		}
+
+		// Values are quoted, so eval "$(atun aws-env)" works whatever characters they have
+		export := func(name string, value string) {
+			fmt.Printf("export %s=%s\n", name, ssh.ShellQuote(value))
+		}
+
+		export("AWS_ACCESS_KEY_ID", credentials.AccessKeyID)
+		export("AWS_SECRET_ACCESS_KEY", credentials.SecretAccessKey)
+		if credentials.SessionToken != "" {
+			export("AWS_SESSION_TOKEN", credentials.SessionToken)
+		}
+		if !credentials.Expiration.IsZero() {
+			export("AWS_CREDENTIAL_EXPIRATION", credentials.Expiration.UTC().Format(time.RFC3339))
+		}
+		export("AWS_REGION", config.App.Config.AWSRegion)
+
+		// Credentials are exported explicitly, the profile would take precedence in some tools
+		fmt.Println("unset AWS_PROFILE")
+
+		return nil
+	},
+}
+
+func init() {
+	awsEnvCmd.Flags().String("profile", "", "AWS profile to get credentials of. Defaults to --aws-profile")
+}
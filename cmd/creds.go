@@ -0,0 +1,78 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/DimmKirr/atun/internal/aws"
+	"github.com/DimmKirr/atun/internal/config"
+	"github.com/DimmKirr/atun/internal/constraints"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+)
+
+// credsCmd represents the creds command
+var credsCmd = &cobra.Command{
+	Use:   "creds",
+	Short: "Print AWS credentials for credential_process",
+	Long: `Print credentials of the atun AWS session in the credential_process format.
+MFA and SSO sessions are refreshed as needed, so aws CLI, terraform and SDK apps share the session of atun.
+
+Example (~/.aws/config):
+  [profile dev-atun]
+  credential_process = atun creds --profile dev`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		credentials, err := getProfileCredentials(cmd)
+		if err != nil {
+			return err
+		}
+
+		// https://docs.aws.amazon.com/sdkref/latest/guide/feature-process-credentials.html
+		output := map[string]interface{}{
+			"Version":         1,
+			"AccessKeyId":     credentials.AccessKeyID,
+			"SecretAccessKey": credentials.SecretAccessKey,
+		}
+		if credentials.SessionToken != "" {
+			output["SessionToken"] = credentials.SessionToken
+		}
+		if !credentials.Expiration.IsZero() {
+			output["Expiration"] = credentials.Expiration.UTC().Format(time.RFC3339)
+		}
+
+		return json.NewEncoder(os.Stdout).Encode(output)
+	},
+}
+
+// getProfileCredentials authenticates with the profile from --profile (or --aws-profile) and returns its credentials.
+// Everything but the credentials goes to stderr, so the output can be consumed by other tools.
+func getProfileCredentials(cmd *cobra.Command) (aws.Credentials, error) {
+	pterm.SetDefaultOutput(os.Stderr)
+	pterm.DefaultLogger.Writer = os.Stderr
+
+	if profile, _ := cmd.Flags().GetString("profile"); profile != "" {
+		config.App.Config.AWSProfile = profile
+	}
+
+	if err := constraints.CheckConstraints(constraints.WithAWSProfile()); err != nil {
+		return aws.Credentials{}, err
+	}
+
+	credentials, err := aws.GetCredentials(config.App)
+	if err != nil {
+		return aws.Credentials{}, fmt.Errorf("error getting AWS credentials: %w", err)
+	}
+
+	return credentials, nil
+}
+
+func init() {
+	credsCmd.Flags().String("profile", "", "AWS profile to get credentials of. Defaults to --aws-profile")
+}
@@ -11,11 +11,12 @@ import (
 	"github.com/DimmKirr/atun/internal/config"
 	"github.com/DimmKirr/atun/internal/constraints"
 	"github.com/DimmKirr/atun/internal/logger"
+	"github.com/DimmKirr/atun/internal/router"
 	"github.com/DimmKirr/atun/internal/ssh"
-	"github.com/DimmKirr/atun/internal/tunnel"
 	"github.com/DimmKirr/atun/internal/ux"
 	"github.com/pterm/pterm"
 	"github.com/spf13/cobra"
+	"slices"
 	"time"
 )
 
@@ -38,11 +39,18 @@ var downCmd = &cobra.Command{
 		//	return err
 		//}
 
-		if err := constraints.CheckConstraints(
-			constraints.WithAWSProfile(),
+		routerProvider, err := router.New(config.App.Config.RouterType)
+		if err != nil {
+			return err
+		}
+
+		constraintOptions := []constraints.Option{constraints.WithENV()}
+		if routerProvider.RequiresAWS() {
 			//constraints.WithAWSRegion(), // Can be derived on the session level
-			constraints.WithENV(),
-		); err != nil {
+			constraintOptions = append(constraintOptions, constraints.WithAWSProfile())
+		}
+
+		if err := constraints.CheckConstraints(constraintOptions...); err != nil {
 			return err
 		}
 
@@ -60,42 +68,61 @@ var downCmd = &cobra.Command{
 			}
 			spinnerGetRouterHostFromExistingSession.Success("Tunnel config doesn't exist locally")
 
-			mfaInputRequired := aws.MFAInputRequired(config.App)
+			if routerProvider.RequiresAWS() {
+				mfaInputRequired := aws.MFAInputRequired(config.App)
 
-			if mfaInputRequired {
-				pterm.Printfln(" %s Authenticating with AWS", pterm.LightBlue("▶︎"))
-				aws.InitAWSClients(config.App)
-			} else {
-				spinnerAWSAuth := ux.NewProgressSpinner("Authenticating with AWS")
-				aws.InitAWSClients(config.App)
-				spinnerAWSAuth.Success(fmt.Sprintf("Authenticated with AWS account %s", aws.GetAccountId()))
+				if mfaInputRequired {
+					pterm.Printfln(" %s Authenticating with AWS", pterm.LightBlue("▶︎"))
+					aws.InitAWSClients(config.App)
+				} else {
+					spinnerAWSAuth := ux.NewProgressSpinner("Authenticating with AWS")
+					aws.InitAWSClients(config.App)
+					spinnerAWSAuth.Success(fmt.Sprintf("Authenticated with AWS account %s", aws.GetAccountId()))
+				}
 			}
 
 			spinnerRouterDetection := ux.NewProgressSpinner("Detecting Atun routers in AWS")
 
-			config.App.Config.RouterHostID, err = tunnel.GetRouterHostIDFromTags()
-			if err != nil {
+			routerHostIDs, err := routerProvider.Discover()
+			if err != nil || len(routerHostIDs) == 0 {
 				spinnerRouterDetection.Warning("No router hosts found with atun.io tags.")
 
 				spinnerRouterDetection.UpdateText("Discovering router host...")
-				config.App.Config.RouterHostID, err = tunnel.GetRouterHostIDFromTags()
+				routerHostIDs, err = routerProvider.Discover()
 				if err != nil {
 					spinnerRouterDetection.Fail("Error discovering router host", "error", err)
 				}
+				if len(routerHostIDs) == 0 {
+					return fmt.Errorf("no %s routers found for env %s", routerProvider.Type(), config.App.Config.Env)
+				}
 
-				spinnerRouterDetection.Success("Routers found", "Discovered router host", config.App.Config.RouterHostID)
+				spinnerRouterDetection.Success("Routers found", "Discovered router hosts", routerHostIDs)
 				// TODO: suggest creating a router host.
 				// Use survey to ask if the user wants to create a router host
 				// If yes, run the create command
 				// If no, return
 
 			}
-			spinnerRouterDetection.Success(fmt.Sprintf("Router found: %s", config.App.Config.RouterHostID))
+
+			if len(routerHostIDs) > 1 && slices.Contains(routerHostIDs, routerHostID) {
+				// No need to ask which router to disconnect from when there is a session with one of them
+				config.App.Config.RouterHostID = routerHostID
+				spinnerRouterDetection.Success(fmt.Sprintf("Router found: %s", config.App.Config.RouterHostID))
+			} else if len(routerHostIDs) > 1 {
+				spinnerRouterDetection.Success(fmt.Sprintf("Routers found: %d", len(routerHostIDs)))
+				config.App.Config.RouterHostID, err = router.Select(routerProvider, routerHostIDs)
+				if err != nil {
+					return err
+				}
+			} else {
+				config.App.Config.RouterHostID = routerHostIDs[0]
+				spinnerRouterDetection.Success(fmt.Sprintf("Router found: %s", config.App.Config.RouterHostID))
+			}
 		} else {
 			config.App.Config.RouterHostID = routerHostID
 		}
 		spinnerGetRouterHostConfig := ux.NewProgressSpinner("Getting router endpoints config")
-		routerHostConfig, err := tunnel.GetRouterHostConfig(config.App.Config.RouterHostID)
+		routerHostConfig, err := routerProvider.Endpoints(config.App.Config.RouterHostID)
 		if err != nil {
 			spinnerGetRouterHostConfig.Fail("Error getting router endpoints config", "err", err)
 		}
@@ -106,7 +133,7 @@ var downCmd = &cobra.Command{
 		config.App.Config.RouterHostUser = routerHostConfig.Config.RouterHostUser
 
 		spinnerGetSSHTunnelStatus := ux.NewProgressSpinner("Getting SSH tunnel status")
-		tunnelActive, endpoints, err := ssh.GetSSHTunnelStatus(config.App)
+		tunnelActive, endpoints, err := routerProvider.Status(config.App)
 		if err != nil {
 			spinnerGetSSHTunnelStatus.Fail("Failed to get tunnel status", "error", err)
 		}
@@ -117,7 +144,7 @@ var downCmd = &cobra.Command{
 			spinnerDeactivateTunnel.UpdateText("Tunnel is active", "tunnelActive", tunnelActive, "routerHostID", config.App.Config.RouterHostID)
 
 			spinnerDeactivateTunnel.UpdateText("Deactivating tunnel")
-			tunnelActive, err = tunnel.DeactivateTunnel(config.App)
+			tunnelActive, err = routerProvider.Disconnect(config.App)
 			if err != nil {
 				spinnerDeactivateTunnel.Fail("Failed to deactivate tunnel", "error", err)
 			}
@@ -126,8 +153,12 @@ var downCmd = &cobra.Command{
 
 		// Check tunnel for the second time
 		spinnerGetSSHTunnelStatusFinal := ux.NewProgressSpinner("Checking tunnel status")
-		tunnelActive, endpoints, err = ssh.GetSSHTunnelStatus(config.App)
+		tunnelActive, endpoints, err = routerProvider.Status(config.App)
 		if !tunnelActive {
+			spinnerGetSSHTunnelStatusFinal.UpdateText("Removing session key from router")
+			if err := router.RevokeSessionKey(routerProvider, config.App); err != nil {
+				logger.Warn("Can't remove session key from router", "router", config.App.Config.RouterHostID, "error", err)
+			}
 			spinnerGetSSHTunnelStatusFinal.Success("Tunnel inactive")
 		}
 
@@ -6,12 +6,18 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"os/signal"
 	"path/filepath"
+	"strings"
+	"syscall"
 
+	"github.com/DimmKirr/atun/internal/aws"
 	"github.com/DimmKirr/atun/internal/config"
 	"github.com/DimmKirr/atun/internal/constraints"
 	"github.com/DimmKirr/atun/internal/logger"
+	"github.com/DimmKirr/atun/internal/router"
 	"github.com/pterm/pterm"
 	"github.com/spf13/viper"
 
@@ -36,7 +42,18 @@ var rootCmd = &cobra.Command{
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
-	err := rootCmd.Execute()
+	// Ctrl-C cancels the command context, so pending AWS calls return and the command cleans up.
+	// A second Ctrl-C kills atun as usual
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	aws.SetContext(ctx)
+
+	err := rootCmd.ExecuteContext(ctx)
 	if err != nil {
 		os.Exit(1)
 	}
@@ -58,11 +75,36 @@ func init() {
 		pterm.Info.Println("Not binding binding aws-region flag (none provided)")
 	}
 
+	rootCmd.PersistentFlags().String("mfa-code", "", "MFA code for AWS profiles of IAM users with MFA (instead of typing it in)")
+	if err := viper.BindPFlag("AWS_MFA_CODE", rootCmd.PersistentFlags().Lookup("mfa-code")); err != nil {
+		pterm.Info.Println("Not binding binding mfa-code flag (none provided)")
+	}
+
+	rootCmd.PersistentFlags().Bool("all-regions", false, "Search for routers in all enabled AWS regions (instead of aws_regions from atun.toml or --aws-region)")
+	if err := viper.BindPFlag("ALL_REGIONS", rootCmd.PersistentFlags().Lookup("all-regions")); err != nil {
+		pterm.Info.Println("Not binding binding all-regions flag (none provided)")
+	}
+
 	rootCmd.PersistentFlags().String("env", "", "Specify environment (dev/prod/...)")
 	if err := viper.BindPFlag("ENV", rootCmd.PersistentFlags().Lookup("env")); err != nil {
 		pterm.Info.Println("Not binding binding env flag (none provided)")
 	}
 
+	rootCmd.PersistentFlags().String("router-type", "", fmt.Sprintf("Specify router type (%s)", strings.Join(router.Types(), "/")))
+	if err := viper.BindPFlag("ROUTER_TYPE", rootCmd.PersistentFlags().Lookup("router-type")); err != nil {
+		pterm.Info.Println("Not binding binding router-type flag (none provided)")
+	}
+
+	rootCmd.PersistentFlags().String("kube-context", "", "Specify kubeconfig context for k8s routers (defaults to the current context)")
+	if err := viper.BindPFlag("KUBE_CONTEXT", rootCmd.PersistentFlags().Lookup("kube-context")); err != nil {
+		pterm.Info.Println("Not binding binding kube-context flag (none provided)")
+	}
+
+	rootCmd.PersistentFlags().String("kube-namespace", "", "Specify namespace to discover k8s routers in (defaults to all namespaces)")
+	if err := viper.BindPFlag("KUBE_NAMESPACE", rootCmd.PersistentFlags().Lookup("kube-namespace")); err != nil {
+		pterm.Info.Println("Not binding binding kube-namespace flag (none provided)")
+	}
+
 	//if err := viper.BindPFlags(rootCmd.Flags()); err != nil {
 	//	pterm.Error.Println("Error while binding flags")
 	//}
@@ -74,6 +116,8 @@ func init() {
 		statusCmd,
 		versionCmd,
 		routerCmd,
+		credsCmd,
+		awsEnvCmd,
 	)
 
 	//cobra.OnInitialize(config.LoadConfig)
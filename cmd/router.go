@@ -13,8 +13,9 @@ that facilitate secure connections to your infrastructure.
 	
 Available router types:
 - EC2: Amazon EC2 router hosts
-- Kubernetes (planned): Kubernetes pods acting as jump hosts
-- ECS (planned): Amazon ECS containers for connecting to services`,
+- Kubernetes: Kubernetes pods or deployments acting as jump hosts
+- ECS: Amazon ECS tasks (ECS Exec) for connecting to services
+- SSH: Plain SSH bastions without SSM, connected to directly`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		// By default, don't do anything
 		return nil
@@ -28,5 +29,11 @@ func init() {
 	routerCmd.AddCommand(routerDeleteCmd)
 	routerCmd.AddCommand(routerInstallCmd)
 	routerCmd.AddCommand(routerUninstallCmd)
+	routerCmd.AddCommand(routerExecCmd)
+	routerCmd.AddCommand(routerTrustCACmd)
+	routerCmd.AddCommand(routerKeysCmd)
+	routerCmd.AddCommand(routerMigrateCmd)
+	routerCmd.AddCommand(routerValidateCmd)
+	routerCmd.AddCommand(routerK8sProxyCmd)
 
 }
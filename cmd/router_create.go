@@ -11,8 +11,8 @@ import (
 	"github.com/DimmKirr/atun/internal/aws"
 	"github.com/DimmKirr/atun/internal/config"
 	"github.com/DimmKirr/atun/internal/constraints"
-	"github.com/DimmKirr/atun/internal/infra"
 	"github.com/DimmKirr/atun/internal/logger"
+	"github.com/DimmKirr/atun/internal/router"
 	"github.com/DimmKirr/atun/internal/tunnel"
 	"github.com/DimmKirr/atun/internal/ux"
 	"github.com/pterm/pterm"
@@ -20,6 +20,7 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
 )
 
 // createCmd represents the add command
@@ -30,7 +31,21 @@ var routerCreateCmd = &cobra.Command{
 	This is useful when there is no IaC in place and there is a need to connect to a resource private.
 	State is saved locally and it's advised to delete it after the task is finished.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		var err error
+		// --type overrides the configured router type (e.g. to create a Fargate router in an account without EC2)
+		if routerType, _ := cmd.Flags().GetString("type"); routerType != "" {
+			config.App.Config.RouterType = routerType
+		}
+
+		routerProvider, err := router.New(config.App.Config.RouterType)
+		if err != nil {
+			return err
+		}
+
+		// Subnet, key pair and endpoints config below are only needed by routers provisioned in AWS
+		if !routerProvider.RequiresAWS() {
+			config.App.Config.RouterHostID, err = routerProvider.Create(config.App)
+			return err
+		}
 
 		mfaInputRequired := aws.MFAInputRequired(config.App)
 		if mfaInputRequired {
@@ -149,32 +164,16 @@ var routerCreateCmd = &cobra.Command{
 		}
 
 		// Create and start a fork of the default spinner.
-		createRouterInstanceSpinner := ux.NewProgressSpinner("Creating Ad-Hoc EC2 Router Instance...")
+		createRouterInstanceSpinner := ux.NewProgressSpinner(fmt.Sprintf("Creating Ad-Hoc %s Router...", routerProvider.Type()))
 
-		// Apply the configuration using CDKTF
-		err = infra.ApplyCDKTF(config.App.Config)
+		// Provision the router (CDKTF for EC2) and wait until it's ready
+		config.App.Config.RouterHostID, err = routerProvider.Create(config.App)
 		if err != nil {
-			createRouterInstanceSpinner.Fail("Error running CDKTF", err)
-			logger.Error("Error running CDKTF", "err", err)
+			createRouterInstanceSpinner.Fail("Error creating router", err)
+			logger.Error("Error creating router", "err", err)
 			return err
 		}
-		createRouterInstanceSpinner.Success("CDKTF stack applied successfully")
-
-		// Get RouterHostID
-		config.App.Config.RouterHostID, err = tunnel.GetRouterHostIDFromTags()
-		if err != nil {
-			logger.Fatal("Error discovering router host", "error", err)
-		}
-
-		instanceIsReadySpinner := ux.NewProgressSpinner(fmt.Sprintf("Waiting for the instance %s to be running...", config.App.Config.RouterHostID))
-
-		// Wait until the instance is ready to accept SSM connections
-		err = aws.WaitForInstanceReady(config.App.Config.RouterHostID)
-		if err != nil {
-			instanceIsReadySpinner.Fail(fmt.Sprintf("Instance %s is still not ready", config.App.Config.RouterHostID), err)
-
-		}
-		instanceIsReadySpinner.Success(fmt.Sprintf("Router Endpoint %s is ready. Run `atun up`.", config.App.Config.RouterHostID))
+		createRouterInstanceSpinner.Success(fmt.Sprintf("Router Endpoint %s is ready. Run `atun up`.", config.App.Config.RouterHostID))
 
 		return nil
 	},
@@ -236,29 +235,32 @@ func buildHostConfig(app *config.Atun) error {
 		return err
 	}
 
-	// Get list of key pairs in the account
-	keyPairs, err := aws.GetAvailableKeyPairs()
-	if err != nil {
-		log.Fatalf("Error getting available key pairs: %v", err)
-		return err
-	}
+	// Key pairs are only used by EC2 routers
+	if app.Config.RouterType == "" || app.Config.RouterType == "ec2" {
+		// Get list of key pairs in the account
+		keyPairs, err := aws.GetAvailableKeyPairs()
+		if err != nil {
+			log.Fatalf("Error getting available key pairs: %v", err)
+			return err
+		}
 
-	// Ask user to pick a key pair
-	selectedAWSKeyPair, err := ux.GetInteractiveSelection("Select AWS Key Pair", func() []string {
-		var options []string
-		for _, keyPair := range keyPairs {
-			options = append(options, *keyPair.KeyName)
+		// Ask user to pick a key pair
+		selectedAWSKeyPair, err := ux.GetInteractiveSelection("Select AWS Key Pair", func() []string {
+			var options []string
+			for _, keyPair := range keyPairs {
+				options = append(options, *keyPair.KeyName)
+			}
+			return options
+		}())
+		if err != nil {
+			log.Fatalf("Error getting AWS Key Pair: %v", err)
+			return err
+		}
+		app.Config.AWSKeyPair = selectedAWSKeyPair
+		if err != nil {
+			log.Fatalf("Error getting AWS Key Pair: %v", err)
+			return err
 		}
-		return options
-	}())
-	if err != nil {
-		log.Fatalf("Error getting AWS Key Pair: %v", err)
-		return err
-	}
-	app.Config.AWSKeyPair = selectedAWSKeyPair
-	if err != nil {
-		log.Fatalf("Error getting AWS Key Pair: %v", err)
-		return err
 	}
 
 	// Get VPC ID from Subnet ID if it's not populated
@@ -300,19 +302,17 @@ func buildHostConfig(app *config.Atun) error {
 		host := config.Endpoint{}
 
 		// Determine defaults based on the current iteration and `app.Config.Hosts`
-		var defaultHost, defaultProtocol, defaultRemotePort, defaultLocalPort string
+		var defaultHost, defaultRemotePort, defaultLocalPort string
 
 		if i < len(appHosts) {
 			// Suggest defaults from `app.Config.Hosts` if available
 			existingHost := appHosts[i]
 			defaultHost = existingHost.Name
-			defaultProtocol = existingHost.Proto
 			defaultRemotePort = strconv.Itoa(existingHost.Remote)
 			defaultLocalPort = strconv.Itoa(existingHost.Local)
 		} else {
 			// No more elements in `app.Config.Hosts`, fall back to no defaults
 			defaultHost = ""
-			defaultProtocol = "ssm"
 			defaultRemotePort = ""
 			defaultLocalPort = "0"
 		}
@@ -337,15 +337,8 @@ func buildHostConfig(app *config.Atun) error {
 		//	},
 		//}, &host.Proto, survey.WithValidator(survey.Required))
 
-		host.Proto, err = ux.GetInteractiveSelection("Select Endpoint Protocol", []string{"ssm", "k8s", "ssh"}, defaultProtocol)
-		if err != nil {
-			logger.Fatal("Error getting Endpoint Protocol", err)
-
-			return err
-		}
-		if host.Proto == "ssh" || host.Proto == "k8s" {
-			logger.Fatal("Sorry, only SSM is supported for now, but it's on the roadmap. Back to the matrix 💊")
-		}
+		// Endpoints are forwarded over the SSH connection to the router whatever its transport (atun.io/transport), so there is no protocol to pick per endpoint
+		host.Proto = "ssm"
 
 		rp, err := aws.InferPortByHost(host.Name)
 		if err != nil {
@@ -419,4 +412,5 @@ func init() {
 	routerCreateCmd.PersistentFlags().String("router-vpc-id", "", "VPC ID of the router host to be created")
 	routerCreateCmd.PersistentFlags().String("router-subnet-id", "", "Subnet ID of the router host to be created")
 	routerCreateCmd.PersistentFlags().String("aws-key-pair", "", "AWS Key Pair Name to use for the router host")
+	routerCreateCmd.Flags().String("type", "", fmt.Sprintf("Router type (%s). Defaults to --router-type", strings.Join(router.Types(), ", ")))
 }
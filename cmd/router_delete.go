@@ -9,11 +9,12 @@ import (
 	"fmt"
 	"github.com/DimmKirr/atun/internal/aws"
 	"github.com/DimmKirr/atun/internal/config"
-	"github.com/DimmKirr/atun/internal/infra"
 	"github.com/DimmKirr/atun/internal/logger"
+	"github.com/DimmKirr/atun/internal/router"
 	"github.com/DimmKirr/atun/internal/ux"
 	"github.com/pterm/pterm"
 	"github.com/spf13/cobra"
+	"strings"
 )
 
 // routerDeleteCmd represents the del command
@@ -24,21 +25,32 @@ var routerDeleteCmd = &cobra.Command{
 	RunE: func(cmd *cobra.Command, args []string) error {
 		// TODO: Add check for --force flag
 
+		if routerType, _ := cmd.Flags().GetString("type"); routerType != "" {
+			config.App.Config.RouterType = routerType
+		}
+
+		routerProvider, err := router.New(config.App.Config.RouterType)
+		if err != nil {
+			return err
+		}
+
 		// TODO: Add survey to check if the user is sure to destroy the stack
-		ux.Println("Deleting Ad-Hoc EC2 Router Instance...")
-
-		mfaInputRequired := aws.MFAInputRequired(config.App)
-		if mfaInputRequired {
-			pterm.Printfln(" %s Authenticating with AWS", pterm.LightBlue("▶︎"))
-			aws.InitAWSClients(config.App)
-		} else {
-			spinnerAWSAuth := ux.NewProgressSpinner("Authenticating with AWS")
-			aws.InitAWSClients(config.App)
-			spinnerAWSAuth.Success(fmt.Sprintf("Authenticated with AWS account %s", aws.GetAccountId()))
+		ux.Println(fmt.Sprintf("Deleting Ad-Hoc %s Router...", routerProvider.Type()))
+
+		if routerProvider.RequiresAWS() {
+			mfaInputRequired := aws.MFAInputRequired(config.App)
+			if mfaInputRequired {
+				pterm.Printfln(" %s Authenticating with AWS", pterm.LightBlue("▶︎"))
+				aws.InitAWSClients(config.App)
+			} else {
+				spinnerAWSAuth := ux.NewProgressSpinner("Authenticating with AWS")
+				aws.InitAWSClients(config.App)
+				spinnerAWSAuth.Success(fmt.Sprintf("Authenticated with AWS account %s", aws.GetAccountId()))
+			}
 		}
 
 		spinnerDestroyCDK := ux.NewProgressSpinner("Destroying CDK of a Router Ad-Hoc Instance")
-		err := infra.DestroyCDKTF(config.App.Config)
+		err = routerProvider.Delete(config.App)
 		if err != nil {
 			spinnerDestroyCDK.Fail("Failed to destroy CDK of a Router Ad-Hoc Instance")
 
@@ -51,4 +63,5 @@ var routerDeleteCmd = &cobra.Command{
 }
 
 func init() {
+	routerDeleteCmd.Flags().String("type", "", fmt.Sprintf("Router type (%s). Defaults to --router-type", strings.Join(router.Types(), ", ")))
 }
@@ -0,0 +1,87 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package cmd
+
+import (
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/DimmKirr/atun/internal/aws"
+	"github.com/DimmKirr/atun/internal/logger"
+	"github.com/DimmKirr/atun/internal/ssh"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+)
+
+// routerExecCmd represents the router exec command
+var routerExecCmd = &cobra.Command{
+	Use:   "exec [flags] -- <command>",
+	Short: "Run a command on routers with SSM Run Command",
+	Long: `Run a shell command on the router with SSM Run Command (no SSH or tunnel needed).
+Stdout and stderr of the command go to stdout and stderr, and atun exits with the exit code of the command.
+The output is printed once the command finishes. Set ssm_output_log_group in atun.toml to stream it while the command runs.
+A single argument is run as a shell command line (pipes and redirects work). Several arguments are quoted, so they reach the command as-is.
+
+Example usage:
+  atun router exec -- uptime                    # Run on the router (picked like atun up does)
+  atun router exec --router i-1234abcd -- df -h # Run on a specific router
+  atun router exec --all -- systemctl restart amazon-ssm-agent  # Run on all routers of the env
+  atun router exec -- 'journalctl -u sshd | tail -n 50'         # Run a command line with a pipe`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Only the output of the command goes to stdout, so it can be piped
+		pterm.SetDefaultOutput(os.Stderr)
+		pterm.DefaultLogger.Writer = os.Stderr
+
+		routerIDs, err := ec2RouterTargets(cmd)
+		if err != nil {
+			return err
+		}
+
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		command := execCommandLine(args)
+
+		logger.Debug("Running command on routers", "routers", routerIDs, "command", command)
+		results, err := aws.RunCommand(routerIDs, command, aws.CommandOptions{
+			Comment: "atun router exec",
+			Timeout: timeout,
+			Stdout:  os.Stdout,
+			Stderr:  os.Stderr,
+		})
+
+		// The remote exit code is passed through. With several routers the first non-zero one wins
+		for _, result := range results {
+			var commandErr *aws.CommandError
+			if errors.As(result.Err, &commandErr) && result.ExitCode > 0 {
+				logger.Debug("Command failed", "router", result.InstanceID, "exitCode", result.ExitCode)
+				os.Exit(result.ExitCode)
+			}
+		}
+
+		return err
+	},
+}
+
+// execCommandLine builds the shell command line run on routers. A single argument is a command line already,
+// several arguments are quoted one by one, so spaces and quotes in them survive the remote shell
+func execCommandLine(args []string) string {
+	if len(args) == 1 {
+		return args[0]
+	}
+
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = ssh.ShellQuote(arg)
+	}
+
+	return strings.Join(quoted, " ")
+}
+
+func init() {
+	addRouterTargetFlags(routerExecCmd.Flags(), "run the command on")
+	routerExecCmd.Flags().Duration("timeout", 0, "Timeout of the command (defaults to ssm_command_timeout, 2m)")
+}
@@ -6,7 +6,6 @@
 package cmd
 
 import (
-	"encoding/json"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
@@ -16,6 +15,7 @@ import (
 	"github.com/DimmKirr/atun/internal/config"
 	"github.com/DimmKirr/atun/internal/constraints"
 	"github.com/DimmKirr/atun/internal/logger"
+	"github.com/DimmKirr/atun/internal/schema"
 	"github.com/DimmKirr/atun/internal/ux"
 )
 
@@ -82,13 +82,16 @@ Example:
 		// TODO: abstract EC2 stuff into aws package
 		// Verify instance exists
 		installSpinner.UpdateText(fmt.Sprintf("Verifying instance %s exists...", routerID))
+		ctx, cancel := awsLib.CallContext()
+		defer cancel()
+
 		ec2Client, err := awsLib.NewEC2Client(*config.App.Session.Config)
 		if err != nil {
 			installSpinner.Fail(fmt.Sprintf("Failed to create EC2 client: %v", err))
 			return err
 		}
 
-		_, err = ec2Client.DescribeInstances(&ec2.DescribeInstancesInput{
+		_, err = ec2Client.DescribeInstancesWithContext(ctx, &ec2.DescribeInstancesInput{
 			InstanceIds: []*string{aws.String(routerID)},
 		})
 		if err != nil {
@@ -113,29 +116,22 @@ Example:
 			Value: aws.String(config.App.Config.Env),
 		})
 
-		// Process each host and add it to the tags
-		for _, host := range config.App.Config.Hosts {
-			key := fmt.Sprintf("atun.io/host/%s", host.Name)
-			hostConfig := map[string]interface{}{
-				"proto":  host.Proto,
-				"local":  host.Local,
-				"remote": host.Remote,
-			}
-
-			configJSON, err := json.Marshal(hostConfig)
-			if err != nil {
-				installSpinner.Fail(fmt.Sprintf("Failed to marshal endpoints config: %v", err))
-				return fmt.Errorf("failed to marshal endpoints config: %w", err)
-			}
+		// Ports of the same host go to one tag
+		hostTags, err := schema.HostTags(config.App.Config.Hosts)
+		if err != nil {
+			installSpinner.Fail(fmt.Sprintf("Failed to build endpoint tags: %v", err))
+			return fmt.Errorf("failed to build endpoint tags: %w", err)
+		}
 
+		for key, value := range hostTags {
 			tags = append(tags, &ec2.Tag{
 				Key:   aws.String(key),
-				Value: aws.String(string(configJSON)),
+				Value: aws.String(value),
 			})
 		}
 
 		// Apply tags to the instance
-		_, err = ec2Client.CreateTags(&ec2.CreateTagsInput{
+		_, err = ec2Client.CreateTagsWithContext(ctx, &ec2.CreateTagsInput{
 			Resources: []*string{aws.String(routerID)},
 			Tags:      tags,
 		})
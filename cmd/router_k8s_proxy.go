@@ -0,0 +1,37 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/DimmKirr/atun/internal/k8s"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+)
+
+// routerK8sProxyCmd is the SSH ProxyCommand of k8s routers. It isn't meant to be run by hand
+var routerK8sProxyCmd = &cobra.Command{
+	Use:    "k8s-proxy <router id> <port>",
+	Short:  "Connect stdin and stdout to a port of a k8s router through kubectl port-forward",
+	Hidden: true,
+	Args:   cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Stdout carries the SSH connection, nothing else may be written there
+		pterm.SetDefaultOutput(os.Stderr)
+		pterm.DefaultLogger.Writer = os.Stderr
+		cmd.SilenceUsage = true
+
+		port, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid port %s", args[1])
+		}
+
+		return k8s.Proxy(args[0], port, os.Stdin, os.Stdout)
+	},
+}
@@ -0,0 +1,203 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/DimmKirr/atun/internal/aws"
+	"github.com/DimmKirr/atun/internal/config"
+	"github.com/DimmKirr/atun/internal/logger"
+	"github.com/DimmKirr/atun/internal/ssh"
+	"github.com/DimmKirr/atun/internal/ux"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+)
+
+// routerKeysCmd represents the router keys command
+var routerKeysCmd = &cobra.Command{
+	Use:   "keys",
+	Short: "Manage SSH keys authorized on routers",
+	Long: `List and remove SSH keys in authorized_keys of the router user. Keys are read and removed with SSM Run Command.
+
+Example usage:
+  atun router keys ls --all                        # List session keys of atun on all routers
+  atun router keys revoke --owner alice-laptop --all # Remove keys of sessions from alice-laptop on all routers
+  atun router keys prune --all                     # Remove session keys older than ssh_session_key_ttl`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return cmd.Help()
+	},
+}
+
+// routerKeysListCmd represents the router keys ls command
+var routerKeysListCmd = &cobra.Command{
+	Use:     "ls",
+	Aliases: []string{"list"},
+	Short:   "List SSH keys added by atun",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		routerIDs, err := ec2RouterTargets(cmd)
+		if err != nil {
+			return err
+		}
+
+		allKeys, _ := cmd.Flags().GetBool("all-keys")
+
+		keys := map[string][]ssh.AuthorizedKey{}
+		var errs []error
+		for _, routerID := range routerIDs {
+			_, routerKeys, err := routerAuthorizedKeys(routerID)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+
+			for _, key := range routerKeys {
+				if key.Session || allKeys {
+					keys[routerID] = append(keys[routerID], key)
+				}
+			}
+		}
+
+		ux.RenderAuthorizedKeysTable(keys)
+
+		return errors.Join(errs...)
+	},
+}
+
+// routerKeysRevokeCmd represents the router keys revoke command
+var routerKeysRevokeCmd = &cobra.Command{
+	Use:   "revoke [fingerprint...]",
+	Short: "Remove SSH keys by fingerprint or owner",
+	Long: `Remove keys from authorized_keys on routers. Keys are picked by fingerprint (as shown by atun router keys ls)
+or, for session keys of atun, by owner (<env>@<hostname> of the machine the session ran on).
+
+Example usage:
+  atun router keys revoke SHA256:Zm9vYmFy... --all
+  atun router keys revoke --owner alice-laptop --all`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		owner, _ := cmd.Flags().GetString("owner")
+		if len(args) == 0 && owner == "" {
+			return fmt.Errorf("specify fingerprints of keys to revoke or --owner")
+		}
+
+		return removeRouterKeys(cmd, func(key ssh.AuthorizedKey) bool {
+			for _, fingerprint := range args {
+				if key.Fingerprint == fingerprint {
+					return true
+				}
+			}
+
+			return key.OwnedBy(owner)
+		})
+	},
+}
+
+// routerKeysPruneCmd represents the router keys prune command
+var routerKeysPruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove stale session keys of atun",
+	Long: `Remove session keys of atun that are older than --older-than (ssh_session_key_ttl, 24h by default),
+e.g. keys of sessions that were never brought down. Keys without the atun-session marker are never touched.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		olderThan, _ := cmd.Flags().GetDuration("older-than")
+		if olderThan == 0 {
+			olderThan = config.App.Config.SSHSessionKeyTTL
+		}
+
+		return removeRouterKeys(cmd, func(key ssh.AuthorizedKey) bool {
+			return key.Session && key.Age() > olderThan
+		})
+	},
+}
+
+// routerAuthorizedKeys reads and parses authorized_keys of the SSH user of the router
+func routerAuthorizedKeys(routerID string) (string, []ssh.AuthorizedKey, error) {
+	spinnerReadKeys := ux.NewProgressSpinner(fmt.Sprintf("Reading authorized keys on %s", routerID))
+
+	user, err := aws.GetInstanceUsername(routerID)
+	if err != nil {
+		spinnerReadKeys.Fail(fmt.Sprintf("Failed to detect the SSH user of %s", routerID))
+		return "", nil, err
+	}
+
+	authorizedKeys, err := aws.GetAuthorizedKeys(routerID, user)
+	if err != nil {
+		spinnerReadKeys.Fail(fmt.Sprintf("Failed to read authorized keys on %s", routerID))
+		return "", nil, err
+	}
+
+	keys := ssh.ParseAuthorizedKeys(authorizedKeys)
+	spinnerReadKeys.Success(fmt.Sprintf("Read %d authorized key(s) of %s on %s", len(keys), user, routerID))
+
+	return user, keys, nil
+}
+
+// removeRouterKeys removes keys the match function picks from authorized_keys on target routers
+func removeRouterKeys(cmd *cobra.Command, match func(key ssh.AuthorizedKey) bool) error {
+	routerIDs, err := ec2RouterTargets(cmd)
+	if err != nil {
+		return err
+	}
+
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+	var errs []error
+	for _, routerID := range routerIDs {
+		user, keys, err := routerAuthorizedKeys(routerID)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		var revoke []string
+		for _, key := range keys {
+			if !match(key) {
+				continue
+			}
+
+			logger.Debug("Key matched", "router", routerID, "fingerprint", key.Fingerprint, "comment", key.Comment)
+			pterm.Printfln("   %s %s %s", pterm.Gray(routerID), key.Fingerprint, pterm.Gray(key.Comment))
+			revoke = append(revoke, key.Line)
+		}
+
+		if len(revoke) == 0 {
+			pterm.Info.Printfln("No matching keys on %s", routerID)
+			continue
+		}
+
+		if dryRun {
+			pterm.Info.Printfln("Would remove %d key(s) from %s", len(revoke), routerID)
+			continue
+		}
+
+		spinnerRevoke := ux.NewProgressSpinner(fmt.Sprintf("Removing %d key(s) from %s", len(revoke), routerID))
+		if err := aws.RemoveSSHPublicKeys(routerID, revoke, user); err != nil {
+			spinnerRevoke.Fail(fmt.Sprintf("Failed to remove keys from %s", routerID))
+			errs = append(errs, err)
+			continue
+		}
+		spinnerRevoke.Success(fmt.Sprintf("Removed %d key(s) from %s", len(revoke), routerID))
+	}
+
+	return errors.Join(errs...)
+}
+
+func init() {
+	addRouterTargetFlags(routerKeysCmd.PersistentFlags(), "manage keys on")
+
+	routerKeysListCmd.Flags().Bool("all-keys", false, "List all keys in authorized_keys, not only session keys of atun")
+
+	routerKeysRevokeCmd.Flags().String("owner", "", "Revoke session keys of this owner (<env>@<hostname>, or just <hostname> for all envs)")
+	routerKeysRevokeCmd.Flags().Bool("dry-run", false, "Only print keys that would be removed")
+
+	routerKeysPruneCmd.Flags().Duration("older-than", 0, "Remove session keys older than this (defaults to ssh_session_key_ttl, 24h)")
+	routerKeysPruneCmd.Flags().Bool("dry-run", false, "Only print keys that would be removed")
+
+	routerKeysCmd.AddCommand(routerKeysListCmd)
+	routerKeysCmd.AddCommand(routerKeysRevokeCmd)
+	routerKeysCmd.AddCommand(routerKeysPruneCmd)
+}
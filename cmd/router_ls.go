@@ -4,14 +4,13 @@ import (
 	"fmt"
 	"github.com/DimmKirr/atun/internal/constraints"
 	"github.com/pterm/pterm"
-	"time"
 
 	"github.com/spf13/cobra"
 
 	"github.com/DimmKirr/atun/internal/aws"
 	"github.com/DimmKirr/atun/internal/config"
 	"github.com/DimmKirr/atun/internal/logger"
-	"github.com/DimmKirr/atun/internal/tunnel"
+	"github.com/DimmKirr/atun/internal/router"
 	"github.com/DimmKirr/atun/internal/ux"
 )
 
@@ -30,12 +29,17 @@ Example:
 
 // listRouters displays a list of available routers
 func listRouters(cmd *cobra.Command, args []string) error {
-	var err error
-	if err = constraints.CheckConstraints(
-		constraints.WithSSMPlugin(),
-		constraints.WithAWSProfile(),
-		constraints.WithENV(),
-	); err != nil {
+	routerProvider, err := router.New(config.App.Config.RouterType)
+	if err != nil {
+		return err
+	}
+
+	constraintOptions := []constraints.Option{constraints.WithENV()}
+	if routerProvider.RequiresAWS() {
+		constraintOptions = append(constraintOptions, constraints.WithSSMPlugin(), constraints.WithAWSProfile())
+	}
+
+	if err = constraints.CheckConstraints(constraintOptions...); err != nil {
 		return err
 	}
 
@@ -44,32 +48,28 @@ func listRouters(cmd *cobra.Command, args []string) error {
 
 	// Initialize AWS clients
 
-	mfaInputRequired := aws.MFAInputRequired(config.App)
-	if mfaInputRequired {
-		pterm.Printfln(" %s Authenticating with AWS", pterm.LightBlue("▶︎"))
-		aws.InitAWSClients(config.App)
-	} else {
-		spinnerAWSAuth := ux.NewProgressSpinner("Authenticating with AWS")
-		aws.InitAWSClients(config.App)
-		spinnerAWSAuth.Success(fmt.Sprintf("Authenticated with AWS account %s", aws.GetAccountId()))
+	if routerProvider.RequiresAWS() {
+		mfaInputRequired := aws.MFAInputRequired(config.App)
+		if mfaInputRequired {
+			pterm.Printfln(" %s Authenticating with AWS", pterm.LightBlue("▶︎"))
+			aws.InitAWSClients(config.App)
+		} else {
+			spinnerAWSAuth := ux.NewProgressSpinner("Authenticating with AWS")
+			aws.InitAWSClients(config.App)
+			spinnerAWSAuth.Success(fmt.Sprintf("Authenticated with AWS account %s", aws.GetAccountId()))
+		}
 	}
 
 	// Get routers (routers) with atun.io tags
 	spinnerRouterDetection := ux.NewProgressSpinner("Detecting Atun routers in AWS")
-	config.App.Config.RouterHostID, err = tunnel.GetRouterHostIDFromTags()
+	// Stopped or pending routers are listed too, the STATE column tells them apart
+	routerIDs, err := router.List(routerProvider)
 
-	if err != nil {
-		spinnerRouterDetection.Fail("No EC2 router instances found with atun.io tags.")
+	if err != nil || len(routerIDs) == 0 {
+		spinnerRouterDetection.Fail(fmt.Sprintf("No %s routers found with atun.io tags.", routerProvider.Type()))
 		return nil
 	}
-
-	// Convert single ID to slice if needed
-	var routerIDs []string
-	if config.App.Config.RouterHostID != "" {
-		routerIDs = []string{config.App.Config.RouterHostID}
-	} else {
-		routerIDs = []string{}
-	}
+	spinnerRouterDetection.Success(fmt.Sprintf("Detected %d router(s)", len(routerIDs)))
 
 	//if len(routerIDs) == 0 {
 	//	spinnerRouterDetection.Success(fmt.Sprintf("No routers found. Create one with `atun router create` or manually add atun.io tags to any EC2 instance"))
@@ -78,14 +78,14 @@ func listRouters(cmd *cobra.Command, args []string) error {
 
 	// Fetch details for each router
 
-	spinnerGetRouters := ux.NewProgressSpinner(fmt.Sprintf("Found %d router(s), fetching details...", len(routerIDs)))
+	spinnerGetRouters := ux.NewProgressSpinner("Fetching router details...")
 
 	routers := []config.RouterInfo{}
 
 	// Process each instance
 	for _, id := range routerIDs {
 		spinnerGetRouters.UpdateText(fmt.Sprintf("Processing %s", id))
-		instance, err := getEC2InstanceDetails(id)
+		instance, err := routerProvider.Describe(id)
 		if err != nil {
 			logger.Warn(fmt.Sprintf("Could not get details for router %s", id), "error", err)
 			continue
@@ -93,30 +93,13 @@ func listRouters(cmd *cobra.Command, args []string) error {
 
 		routers = append(routers, instance)
 	}
-	spinnerGetRouters.Success(fmt.Sprintf("Found %d router(s)", len(routerIDs)))
+	spinnerGetRouters.Success(fmt.Sprintf("Fetched details of %d router(s)", len(routers)))
 	// Display routers in a table
 	ux.RenderRouterTable(routers)
 
 	return nil
 }
 
-// getEC2InstanceDetails retrieves EC2 instance details
-// This is a helper function that uses available AWS functions in your codebase
-func getEC2InstanceDetails(instanceID string) (config.RouterInfo, error) {
-	// Use your existing AWS functions to get instance details
-	// This might be something like aws.DescribeInstance
-
-	// For now, create a placeholder instance with basic information
-	router := config.RouterInfo{
-		ID:        instanceID,
-		Type:      "ec2",
-		State:     "running",
-		CreatedAt: time.Now(),
-	}
-
-	return router, nil
-}
-
 func init() {
 
 }
@@ -0,0 +1,96 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/DimmKirr/atun/internal/aws"
+	"github.com/DimmKirr/atun/internal/schema"
+	"github.com/DimmKirr/atun/internal/ux"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+)
+
+// routerMigrateCmd represents the router migrate command
+var routerMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Rewrite atun.io tags of routers from schema v1 to v2",
+	Long: `Rewrite atun.io/host/* tags of routers from schema v1 to v2 in place and set atun.io/version to 2.
+Endpoints keep their ports and protocol. Routers that are on v2 already are left alone.
+
+Example usage:
+  atun router migrate --dry-run --all       # Show new tags of all routers of the env
+  atun router migrate --router i-1234abcd   # Migrate a specific router`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		routerIDs, err := ec2RouterTargets(cmd)
+		if err != nil {
+			return err
+		}
+
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+		var errs []error
+		for _, routerID := range routerIDs {
+			if err := migrateRouter(routerID, dryRun); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", routerID, err))
+			}
+		}
+
+		return errors.Join(errs...)
+	},
+}
+
+// migrateRouter rewrites v1 tags of the router to v2. Nothing is written if any host tag can't be read
+func migrateRouter(routerID string, dryRun bool) error {
+	spinnerMigrate := ux.NewProgressSpinner(fmt.Sprintf("Migrating tags of %s", routerID))
+
+	tags, err := aws.GetInstanceTags(routerID)
+	if err != nil {
+		spinnerMigrate.Fail(fmt.Sprintf("Failed to read tags of %s", routerID))
+		return err
+	}
+
+	if version := tags["atun.io/version"]; version == schema.Version {
+		spinnerMigrate.Success(fmt.Sprintf("Router %s is on schema v%s already", routerID, version))
+		return nil
+	}
+
+	migrated, err := schema.Migrate(tags)
+	if err != nil {
+		spinnerMigrate.Fail(fmt.Sprintf("Can't migrate tags of %s", routerID))
+		return err
+	}
+
+	if dryRun {
+		spinnerMigrate.Success(fmt.Sprintf("Tags of %s after migration:", routerID))
+		keys := make([]string, 0, len(migrated))
+		for key := range migrated {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			pterm.Printfln("   %s = %s", key, migrated[key])
+		}
+		return nil
+	}
+
+	if err := aws.SetInstanceTags(routerID, migrated); err != nil {
+		spinnerMigrate.Fail(fmt.Sprintf("Failed to write tags of %s", routerID))
+		return err
+	}
+	spinnerMigrate.Success(fmt.Sprintf("Migrated %d tag(s) of %s to schema v%s", len(migrated), routerID, schema.Version))
+
+	return nil
+}
+
+func init() {
+	addRouterTargetFlags(routerMigrateCmd.Flags(), "migrate")
+	routerMigrateCmd.Flags().Bool("dry-run", false, "Only print the new tags")
+}
@@ -2,14 +2,14 @@ package cmd
 
 import (
 	"fmt"
-	"github.com/DimmKirr/atun/internal/constraints"
 	"github.com/spf13/cobra"
 	"os/signal"
+	"strings"
 	"syscall"
 
 	"github.com/DimmKirr/atun/internal/aws"
 	"github.com/DimmKirr/atun/internal/config"
-	"github.com/DimmKirr/atun/internal/tunnel"
+	"github.com/DimmKirr/atun/internal/router"
 	"github.com/DimmKirr/atun/internal/ux"
 )
 
@@ -40,64 +40,68 @@ Example usage:
 		// 	routerID = selectedRouterID
 		// }
 
-		// Initialize AWS clients
-		sshSpinner.UpdateText("Authenticating with AWS...")
-		aws.InitAWSClients(config.App)
-
 		// Get the connection type and target ID
 		routerType, _ := cmd.Flags().GetString("type")
 		targetID = cmd.Flag("target").Value.String()
 
-		// Default to EC2/SSM if not specified
+		// Default to the configured router type if not specified
 		if routerType == "" {
-			routerType = "ec2"
+			routerType = config.App.Config.RouterType
 		}
 
-		// Handle different connection types
-		switch routerType {
-		case "ec2":
-			return consoleToEC2Router(sshSpinner, targetID)
-		// Future connection types
-		case "k8s":
-			sshSpinner.Fail("Kubernetes connections not yet implemented")
-			return fmt.Errorf("kubernetes connections are planned for a future release")
-		case "ecs":
-			sshSpinner.Fail("ECS connections not yet implemented")
-			return fmt.Errorf("ecs connections are planned for a future release")
-		default:
+		routerProvider, err := router.New(routerType)
+		if err != nil {
 			sshSpinner.Fail(fmt.Sprintf("Unknown router type: %s", routerType))
-			return fmt.Errorf("router type '%s' not supported", routerType)
+			return err
+		}
+
+		// Initialize AWS clients
+		if routerProvider.RequiresAWS() {
+			sshSpinner.UpdateText("Authenticating with AWS...")
+			aws.InitAWSClients(config.App)
 		}
+
+		return consoleToRouter(sshSpinner, routerProvider, targetID)
 	},
 }
 
-// consoleToEC2Router manages SSM connections to EC2 router instances
-func consoleToEC2Router(sshSpinner *ux.ProgressSpinner, targetID string) error {
+// consoleToRouter opens an interactive shell on the router
+func consoleToRouter(sshSpinner *ux.ProgressSpinner, routerProvider router.Router, targetID string) error {
 	var err error
 
-	if err := constraints.CheckConstraints(
-		constraints.WithAWSProfile(),
-		constraints.WithAWSCLI(),
-		constraints.WithSSMPlugin(),
-	); err != nil {
-		return err
-	}
-
-	// If target not provided, get the first running instance
+	// If target not provided, get the first running router
 	if targetID == "" {
 		sshSpinner.UpdateText("Discovering router...")
-		config.App.Config.RouterHostID, err = tunnel.GetRouterHostIDFromTags()
+		routerIDs, err := routerProvider.Discover()
+		if err == nil && len(routerIDs) == 0 {
+			err = fmt.Errorf("no %s routers found for env %s", routerProvider.Type(), config.App.Config.Env)
+		}
 		if err != nil {
 			sshSpinner.Fail("No routers found with atun.io tags")
 			return fmt.Errorf("no routers found: %w", err)
 		}
+
+		if len(routerIDs) > 1 {
+			// The picker can't share the terminal with a running spinner
+			sshSpinner.Success(fmt.Sprintf("Routers found: %d", len(routerIDs)))
+			config.App.Config.RouterHostID, err = router.Select(routerProvider, routerIDs)
+			if err != nil {
+				return err
+			}
+			sshSpinner = ux.NewProgressSpinner("Connecting to router")
+		} else {
+			config.App.Config.RouterHostID = routerIDs[0]
+		}
 	} else {
 		config.App.Config.RouterHostID = targetID
 	}
 
 	sshSpinner.UpdateText(fmt.Sprintf("Connecting to %s...", config.App.Config.RouterHostID))
 
-	err = aws.ConnectToSSMConsole(config.App.Config.RouterHostID)
+	// Ignore interrupt signals during shell session (Ctrl+C), they belong to the remote shell
+	signal.Ignore(syscall.SIGINT)
+
+	err = routerProvider.Shell(config.App.Config.RouterHostID)
 	if err != nil {
 		sshSpinner.Fail("Failed to connect to router", "routerID", config.App.Config.RouterHostID, "error", err)
 		return fmt.Errorf("failed to connect to router: %w", err)
@@ -107,9 +111,6 @@ func consoleToEC2Router(sshSpinner *ux.ProgressSpinner, targetID string) error {
 }
 
 func init() {
-	// Ignore interrupt signals during shell session (Ctrl+C)
-	signal.Ignore(syscall.SIGINT)
-
 	routerShellCmd.Flags().String("target", "", "Target router identifier (instance ID for EC2)")
-	routerShellCmd.Flags().String("type", "", "Router type (ec2, k8s, ecs)")
+	routerShellCmd.Flags().String("type", "", fmt.Sprintf("Router type (%s). Defaults to --router-type", strings.Join(router.Types(), ", ")))
 }
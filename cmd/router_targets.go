@@ -0,0 +1,89 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/DimmKirr/atun/internal/aws"
+	"github.com/DimmKirr/atun/internal/config"
+	"github.com/DimmKirr/atun/internal/constraints"
+	"github.com/DimmKirr/atun/internal/router"
+	"github.com/DimmKirr/atun/internal/ux"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// authenticateAWS initializes AWS clients. The MFA code is typed in without a spinner, it would hide the prompt
+func authenticateAWS() {
+	if aws.MFAInputRequired(config.App) {
+		pterm.Printfln(" %s Authenticating with AWS", pterm.LightBlue("▶︎"))
+		aws.InitAWSClients(config.App)
+		return
+	}
+
+	spinnerAWSAuth := ux.NewProgressSpinner("Authenticating with AWS")
+	aws.InitAWSClients(config.App)
+	spinnerAWSAuth.Success(fmt.Sprintf("Authenticated with AWS account %s", aws.GetAccountId()))
+}
+
+// ec2RouterTargets prepares a command that runs on EC2 routers over AWS APIs: checks constraints, authenticates with AWS
+// and returns routers picked with the --router and --all flags (see execTargets)
+func ec2RouterTargets(cmd *cobra.Command) ([]string, error) {
+	routerProvider, err := router.New(config.App.Config.RouterType)
+	if err != nil {
+		return nil, err
+	}
+
+	if routerProvider.Type() != "ec2" {
+		return nil, fmt.Errorf("%s is not supported for %s routers", cmd.CommandPath(), routerProvider.Type())
+	}
+
+	if err := constraints.CheckConstraints(constraints.WithENV(), constraints.WithAWSProfile()); err != nil {
+		return nil, err
+	}
+
+	authenticateAWS()
+
+	return execTargets(cmd, routerProvider)
+}
+
+// addRouterTargetFlags adds the --router and --all flags read by execTargets. action completes their help, e.g. "migrate" or "run the command on"
+func addRouterTargetFlags(flags *pflag.FlagSet, action string) {
+	flags.StringP("router", "r", "", fmt.Sprintf("Router instance id to %s. If not specified the discovered router is used", action))
+	flags.Bool("all", false, fmt.Sprintf("%s all routers of the env", strings.ToUpper(action[:1])+action[1:]))
+}
+
+// execTargets returns routers the command runs on: --router, all routers of the env with --all, or the selected one
+func execTargets(cmd *cobra.Command, routerProvider router.Router) ([]string, error) {
+	if routerID, _ := cmd.Flags().GetString("router"); routerID != "" {
+		return []string{routerID}, nil
+	}
+
+	spinnerRouterDetection := ux.NewProgressSpinner(fmt.Sprintf("Detecting %s routers", routerProvider.Type()))
+	routerIDs, err := routerProvider.Discover()
+	if err == nil && len(routerIDs) == 0 {
+		err = fmt.Errorf("no %s routers found for env %s", routerProvider.Type(), config.App.Config.Env)
+	}
+	if err != nil {
+		spinnerRouterDetection.Fail("No routers found with atun.io tags")
+		return nil, err
+	}
+	spinnerRouterDetection.Success(fmt.Sprintf("Routers found: %d", len(routerIDs)))
+
+	if all, _ := cmd.Flags().GetBool("all"); all {
+		return routerIDs, nil
+	}
+
+	routerID, err := router.Select(routerProvider, routerIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{routerID}, nil
+}
@@ -0,0 +1,58 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/DimmKirr/atun/internal/aws"
+	"github.com/DimmKirr/atun/internal/config"
+	"github.com/DimmKirr/atun/internal/router"
+	"github.com/DimmKirr/atun/internal/ux"
+	"github.com/spf13/cobra"
+)
+
+// routerTrustCACmd represents the router trust-ca command
+var routerTrustCACmd = &cobra.Command{
+	Use:   "trust-ca",
+	Short: "Make routers accept certificates signed by the SSH user CA",
+	Long: `Install the public key of the SSH user CA (ssh_ca_key) on routers and set it as TrustedUserCAKeys of sshd.
+Routers created with ssh_auth = "ca" trust the CA already, this is for routers created before.
+
+Example usage:
+  atun router trust-ca                     # Trust the CA on the router (picked like atun up does)
+  atun router trust-ca --router i-1234abcd # Trust the CA on a specific router
+  atun router trust-ca --all               # Trust the CA on all routers of the env`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		routerIDs, err := ec2RouterTargets(cmd)
+		if err != nil {
+			return err
+		}
+
+		caPublicKey, err := router.CAPublicKey(config.App)
+		if err != nil {
+			return err
+		}
+
+		var errs []error
+		for _, routerID := range routerIDs {
+			spinnerTrustCA := ux.NewProgressSpinner(fmt.Sprintf("Installing SSH CA on %s", routerID))
+			if err := aws.TrustSSHCA(routerID, caPublicKey); err != nil {
+				spinnerTrustCA.Fail(fmt.Sprintf("Failed to install SSH CA on %s", routerID))
+				errs = append(errs, err)
+				continue
+			}
+			spinnerTrustCA.Success(fmt.Sprintf("Router %s trusts the SSH CA", routerID))
+		}
+
+		return errors.Join(errs...)
+	},
+}
+
+func init() {
+	addRouterTargetFlags(routerTrustCACmd.Flags(), "install the CA on")
+}
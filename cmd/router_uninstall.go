@@ -55,13 +55,16 @@ Example:
 
 		// Verify instance exists and get current tags
 		uninstallSpinner.UpdateText(fmt.Sprintf("Verifying instance %s exists and getting tags...", routerID))
+		ctx, cancel := awsLib.CallContext()
+		defer cancel()
+
 		ec2Client, err := awsLib.NewEC2Client(*config.App.Session.Config)
 		if err != nil {
 			uninstallSpinner.Fail(fmt.Sprintf("Failed to create EC2 client: %v", err))
 			return err
 		}
 
-		result, err := ec2Client.DescribeInstances(&ec2.DescribeInstancesInput{
+		result, err := ec2Client.DescribeInstancesWithContext(ctx, &ec2.DescribeInstancesInput{
 			InstanceIds: []*string{aws.String(routerID)},
 		})
 		if err != nil {
@@ -97,7 +100,7 @@ Example:
 
 		// Remove the tags
 		uninstallSpinner.UpdateText(fmt.Sprintf("Removing %d Atun tags from instance %s...", len(atunTags), routerID))
-		_, err = ec2Client.DeleteTags(&ec2.DeleteTagsInput{
+		_, err = ec2Client.DeleteTagsWithContext(ctx, &ec2.DeleteTagsInput{
 			Resources: []*string{aws.String(routerID)},
 			Tags:      atunTags,
 		})
@@ -0,0 +1,66 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/DimmKirr/atun/internal/aws"
+	"github.com/DimmKirr/atun/internal/schema"
+	"github.com/DimmKirr/atun/internal/ux"
+	"github.com/spf13/cobra"
+)
+
+// routerValidateCmd represents the router validate command
+var routerValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check atun.io tags of routers against the tag schema",
+	Long: `Check atun.io/* tags of routers against the tag schema (schemas/schema.json) and print each problem with the tag key and a fix.
+Endpoints with invalid host tags are skipped by atun up. The command exits with an error if any problem is found, so it can be used in CI.
+
+Example usage:
+  atun router validate                       # Validate the discovered router
+  atun router validate --router i-1234abcd   # Validate a specific router
+  atun router validate --all                 # Validate all routers of the env`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		routerIDs, err := ec2RouterTargets(cmd)
+		if err != nil {
+			return err
+		}
+
+		var errs []error
+		violations := map[string][]schema.Violation{}
+		for _, routerID := range routerIDs {
+			spinnerValidate := ux.NewProgressSpinner(fmt.Sprintf("Validating tags of %s", routerID))
+
+			tags, err := aws.GetInstanceTags(routerID)
+			if err != nil {
+				spinnerValidate.Fail(fmt.Sprintf("Failed to read tags of %s", routerID))
+				errs = append(errs, fmt.Errorf("%s: %w", routerID, err))
+				continue
+			}
+
+			routerViolations := schema.Validate(tags)
+			if len(routerViolations) == 0 {
+				spinnerValidate.Success(fmt.Sprintf("Tags of %s are valid", routerID))
+				continue
+			}
+
+			spinnerValidate.Warning(fmt.Sprintf("Found %d problem(s) in tags of %s", len(routerViolations), routerID))
+			violations[routerID] = routerViolations
+			errs = append(errs, fmt.Errorf("%s: %d problem(s) in tags", routerID, len(routerViolations)))
+		}
+
+		ux.RenderViolationsTable(violations)
+
+		return errors.Join(errs...)
+	},
+}
+
+func init() {
+	addRouterTargetFlags(routerValidateCmd.Flags(), "validate")
+}
@@ -10,8 +10,7 @@ import (
 	"github.com/DimmKirr/atun/internal/aws"
 	"github.com/DimmKirr/atun/internal/config"
 	"github.com/DimmKirr/atun/internal/logger"
-	"github.com/DimmKirr/atun/internal/ssh"
-	"github.com/DimmKirr/atun/internal/tunnel"
+	"github.com/DimmKirr/atun/internal/router"
 	"github.com/DimmKirr/atun/internal/ux"
 	"github.com/pterm/pterm"
 	"github.com/spf13/cobra"
@@ -37,6 +36,11 @@ var statusCmd = &cobra.Command{
 			return fmt.Errorf("can't get detailed flag: %w", err)
 		}
 
+		routerProvider, err := router.New(config.App.Config.RouterType)
+		if err != nil {
+			return err
+		}
+
 		ux.Println("Checking Tunnel Status")
 
 		// Get the router host ID from the command line
@@ -44,20 +48,29 @@ var statusCmd = &cobra.Command{
 
 		// If router host is not provided, get the first running instance based on the discovery tag (atun.io/version)
 		if routerHostID == "" {
-			mfaInputRequired := aws.MFAInputRequired(config.App)
-
-			if mfaInputRequired {
-				pterm.Printfln(" %s Authenticating with AWS", pterm.LightBlue("▶︎"))
-				aws.InitAWSClients(config.App)
-			} else {
-				spinnerAWSAuth := ux.NewProgressSpinner("Authenticating with AWS")
-				aws.InitAWSClients(config.App)
-				spinnerAWSAuth.Success(fmt.Sprintf("Authenticated with AWS account %s", aws.GetAccountId()))
+			if routerProvider.RequiresAWS() {
+				mfaInputRequired := aws.MFAInputRequired(config.App)
+
+				if mfaInputRequired {
+					pterm.Printfln(" %s Authenticating with AWS", pterm.LightBlue("▶︎"))
+					aws.InitAWSClients(config.App)
+				} else {
+					spinnerAWSAuth := ux.NewProgressSpinner("Authenticating with AWS")
+					aws.InitAWSClients(config.App)
+					spinnerAWSAuth.Success(fmt.Sprintf("Authenticated with AWS account %s", aws.GetAccountId()))
+				}
 			}
 			spinnerRouterDetection := ux.NewProgressSpinner("Detecting Atun routers in AWS")
-			config.App.Config.RouterHostID, err = tunnel.GetRouterHostIDFromTags()
+			routerHostIDs, err := routerProvider.Discover()
+			if err == nil && len(routerHostIDs) == 0 {
+				err = fmt.Errorf("no %s routers found for env %s", routerProvider.Type(), config.App.Config.Env)
+			}
 			if err != nil {
-				spinnerRouterDetection.Fail(fmt.Sprintf("No routers found. No --router flag has not been specified and no EC2 instances with atun.io tags found in %s region of AWS account %s.", config.App.Config.AWSRegion, aws.GetAccountId()))
+				if routerProvider.RequiresAWS() {
+					spinnerRouterDetection.Fail(fmt.Sprintf("No routers found. No --router flag has not been specified and no %s routers with atun.io tags found in %s region of AWS account %s.", routerProvider.Type(), config.App.Config.AWSRegion, aws.GetAccountId()))
+				} else {
+					spinnerRouterDetection.Fail(fmt.Sprintf("No routers found. No --router flag has not been specified and no %s routers with atun.io annotations found.", routerProvider.Type()))
+				}
 				if detailedStatus {
 					ux.RenderDetailedStatus()
 				}
@@ -65,13 +78,23 @@ var statusCmd = &cobra.Command{
 				return nil
 
 			}
-			spinnerRouterDetection.Success(fmt.Sprintf("Router found: %s", config.App.Config.RouterHostID))
+
+			if len(routerHostIDs) > 1 {
+				spinnerRouterDetection.Success(fmt.Sprintf("Routers found: %d", len(routerHostIDs)))
+				config.App.Config.RouterHostID, err = router.Select(routerProvider, routerHostIDs)
+				if err != nil {
+					return err
+				}
+			} else {
+				config.App.Config.RouterHostID = routerHostIDs[0]
+				spinnerRouterDetection.Success(fmt.Sprintf("Router found: %s", config.App.Config.RouterHostID))
+			}
 		} else {
 			config.App.Config.RouterHostID = routerHostID
 		}
 
 		spinnerGetRouterHostConfig := ux.NewProgressSpinner("Getting router endpoints config")
-		routerHostConfig, err := tunnel.GetRouterHostConfig(config.App.Config.RouterHostID)
+		routerHostConfig, err := routerProvider.Endpoints(config.App.Config.RouterHostID)
 		if err != nil {
 			spinnerGetRouterHostConfig.Fail("Error getting router endpoints config", "err", err)
 		}
@@ -82,7 +105,7 @@ var statusCmd = &cobra.Command{
 		config.App.Config.RouterHostUser = routerHostConfig.Config.RouterHostUser
 
 		spinnerGetSSHTunnelStatus := ux.NewProgressSpinner("Getting SSH tunnel status")
-		tunnelActive, endpoints, err := ssh.GetSSHTunnelStatus(config.App)
+		tunnelActive, endpoints, err := routerProvider.Status(config.App)
 		if err != nil {
 			spinnerGetSSHTunnelStatus.Fail("Failed to get tunnel status", "error", err)
 		}
@@ -99,10 +122,6 @@ var statusCmd = &cobra.Command{
 			logger.Error("Failed to render env table", "error", err)
 		}
 
-		config.App.Config.RouterHostID, err = tunnel.GetRouterHostIDFromTags()
-		if err != nil {
-			logger.Error("Router not found. You might want to create it.", "error", err)
-		}
 		if detailedStatus {
 			ux.RenderDetailedStatus()
 		}
@@ -11,12 +11,14 @@ import (
 	"github.com/DimmKirr/atun/internal/config"
 	"github.com/DimmKirr/atun/internal/constraints"
 	"github.com/DimmKirr/atun/internal/logger"
+	"github.com/DimmKirr/atun/internal/router"
 	"github.com/DimmKirr/atun/internal/ssh"
 	"github.com/DimmKirr/atun/internal/tunnel"
 	"github.com/DimmKirr/atun/internal/ux"
 	"github.com/pterm/pterm"
 	"github.com/spf13/cobra"
 	"os"
+	"time"
 )
 
 // upCmd represents the up command
@@ -32,11 +34,30 @@ var upCmd = &cobra.Command{
 		var err error
 		var routerHost string
 
-		if err := constraints.CheckConstraints(
-			constraints.WithSSMPlugin(),
-			constraints.WithAWSProfile(),
-			constraints.WithENV(),
-		); err != nil {
+		routerProvider, err := router.New(config.App.Config.RouterType)
+		if err != nil {
+			return err
+		}
+
+		// Watch mode polls EC2 instance tags, check its flags before anything is connected
+		watch, _ := cmd.Flags().GetBool("watch")
+		watchInterval, _ := cmd.Flags().GetDuration("watch-interval")
+		if watch {
+			if routerProvider.Type() != "ec2" {
+				return fmt.Errorf("--watch is not supported for %s routers yet", routerProvider.Type())
+			}
+			if watchInterval <= 0 {
+				return fmt.Errorf("--watch-interval must be positive, got %s", watchInterval)
+			}
+		}
+
+		constraintOptions := []constraints.Option{constraints.WithENV()}
+		// Session Manager plugin is checked per router once its transport is known (atun.io/transport tag or --transport)
+		if routerProvider.RequiresAWS() {
+			constraintOptions = append(constraintOptions, constraints.WithAWSProfile())
+		}
+
+		if err := constraints.CheckConstraints(constraintOptions...); err != nil {
 			return err
 		}
 
@@ -46,26 +67,29 @@ var upCmd = &cobra.Command{
 
 		ux.Println("Activating SSM Tunnel")
 
-		mfaInputRequired := aws.MFAInputRequired(config.App)
-		if mfaInputRequired {
-			pterm.Printfln(" %s Authenticating with AWS", pterm.LightBlue("▶︎"))
-			aws.InitAWSClients(config.App)
-		} else {
-			spinnerAWSAuth := ux.NewProgressSpinner("Authenticating with AWS")
-			aws.InitAWSClients(config.App)
-			spinnerAWSAuth.Success(fmt.Sprintf("Authenticated with AWS account %s", aws.GetAccountId()))
+		if routerProvider.RequiresAWS() {
+			mfaInputRequired := aws.MFAInputRequired(config.App)
+			if mfaInputRequired {
+				pterm.Printfln(" %s Authenticating with AWS", pterm.LightBlue("▶︎"))
+				aws.InitAWSClients(config.App)
+			} else {
+				spinnerAWSAuth := ux.NewProgressSpinner("Authenticating with AWS")
+				aws.InitAWSClients(config.App)
+				spinnerAWSAuth.Success(fmt.Sprintf("Authenticated with AWS account %s", aws.GetAccountId()))
+			}
 		}
 
 		// Get the router host ID from the command line
 		routerHost = cmd.Flag("router").Value.String()
 
-		// If router host is not provided, get the first running instance based on the discovery tag (atun.io/version)
+		// If router host is not provided, discover all running routers based on the discovery tag (atun.io/version), best ranked first
+		var routerHostIDs []string
 		if routerHost == "" {
 			spinnerRouterDetection := ux.NewProgressSpinner("Detecting Atun routers in AWS")
 
-			config.App.Config.RouterHostID, err = tunnel.GetRouterHostIDFromTags()
+			routerHostIDs, err = routerProvider.Discover()
 			if err != nil {
-				spinnerRouterDetection.Warning("No EC2 router instances found with atun.io tags.")
+				spinnerRouterDetection.Warning(fmt.Sprintf("No %s routers found with atun.io tags.", routerProvider.Type()))
 
 				// Get default from the flags
 				createHost, _ := cmd.Flags().GetBool("create")
@@ -96,7 +120,7 @@ var upCmd = &cobra.Command{
 				}
 				spinnerRouterDetection.UpdateText("Discovering router host...")
 
-				config.App.Config.RouterHostID, err = tunnel.GetRouterHostIDFromTags()
+				routerHostIDs, err = routerProvider.Discover()
 				if err != nil {
 					logger.Debug("Error discovering router host", "error", err)
 					spinnerRouterDetection.Fail("Error discovering router host")
@@ -107,90 +131,91 @@ var upCmd = &cobra.Command{
 				// Use survey to ask if the user wants to create a router host
 				// If yes, run the create command
 				// If no, return
-				spinnerRouterDetection.Success("Routers found", "Discovered router host", config.App.Config.RouterHostID)
+				spinnerRouterDetection.Success("Routers found", "Discovered router hosts", routerHostIDs)
 			}
-			spinnerRouterDetection.Success(fmt.Sprintf("Router found: %s", config.App.Config.RouterHostID))
-		} else {
-			config.App.Config.RouterHostID = routerHost
-		}
 
-		// TODO: refactor as a better functional
-		// Read atun:config from the instance as `config`
-		routerHostConfig, err := tunnel.GetRouterHostConfig(config.App.Config.RouterHostID)
-		if err != nil {
-			logger.Fatal("Error getting router endpoints config", "err", err)
-		}
+			if len(routerHostIDs) > 1 {
+				spinnerRouterDetection.Success(fmt.Sprintf("Routers found: %d", len(routerHostIDs)))
 
-		config.App.Version = routerHostConfig.Version
-		config.App.Config.Hosts = routerHostConfig.Config.Hosts
-		config.App.Config.RouterHostUser = routerHostConfig.Config.RouterHostUser
-
-		for _, host := range config.App.Config.Hosts {
-			// Review the hosts
-			logger.Debug("Endpoint", "name", host.Name, "proto", host.Proto, "remote", host.Remote, "local", host.Local)
+				// Let the user pick the router (or use the remembered one). The others are still used for failover
+				selectedRouterID, err := router.Select(routerProvider, routerHostIDs)
+				if err != nil {
+					return err
+				}
+				routerHostIDs = router.Preferred(routerHostIDs, selectedRouterID)
+			} else if len(routerHostIDs) == 1 {
+				spinnerRouterDetection.Success(fmt.Sprintf("Router found: %s", routerHostIDs[0]))
+			}
+		} else {
+			routerHostIDs = []string{routerHost}
 		}
 
-		sshConfigSpinner := ux.NewProgressSpinner("Generating SSH Config")
-
-		// Generate SSH config file
-		config.App.Config.SSHConfigFile, err = ssh.GenerateSSHConfigFile(config.App)
-		if err != nil {
-			sshConfigSpinner.Fail("Error generating SSH config file", "SSHConfigFile", config.App.Config.SSHConfigFile, "error", err)
+		// --transport takes precedence over the atun.io/transport router tag
+		if transport, _ := cmd.Flags().GetString("transport"); transport != "" {
+			config.App.Config.RouterTransport = transport
 		}
 
-		sshConfigSpinner.Success("SSH Config generated", "path", config.App.Config.SSHConfigFile)
+		// Host keys of a router that changed (e.g. it was rebuilt) are only pinned anew when asked to
+		config.App.Config.SSHRepinHostKeys, _ = cmd.Flags().GetBool("repin-host-keys")
 
+		// Picking an agent key or typing in a passphrase can't happen under a spinner
+		if err := ssh.UnlockKey(config.App); err != nil {
+			return err
+		}
 		logger.Debug("Private key path", "path", config.App.Config.SSHKeyPath)
 
-		//err := o.checkOsVersion()
-		//if err != nil {
-		//	return err
-		//}
-
-		// Try to start a tunnel before writing the SSH key (to save on time spent on SSM)
-
+		// Connect to the best router and fail over to the next ones if it doesn't accept the tunnel
 		activateTunnelSpinner := ux.NewProgressSpinner("Activating Tunnel")
-		tunnelActive, connections, err := tunnel.ActivateTunnel(config.App)
+		tunnelActive, connections, err := router.ConnectWithFailover(routerProvider, config.App, routerHostIDs)
 		if err != nil {
-			activateTunnelSpinner.UpdateText("SSH key doesn't seem to be present on the router host")
-
-			// Read private key from HOME/id_rsa.pub
-			publicKey, err := ssh.GetPublicKey(config.App.Config.SSHKeyPath)
-			if err != nil {
-				logger.Error("Error getting public key", "error", err)
-			}
-			logger.Debug("Public key", "key", publicKey)
-
-			activateTunnelSpinner.UpdateText("Ensuring local SSH key is authorized on router...", "SSHPublicKeyPath", config.App.Config.SSHKeyPath, "RouterHostID", config.App.Config.RouterHostID)
-
-			// Send the public key to the router instance
-			err = aws.EnsureSSHPublicKeyPresent(config.App.Config.RouterHostID, publicKey, config.App.Config.RouterHostUser)
-			if err != nil {
-				activateTunnelSpinner.Fail("Failed to add local SSH Public key to the instance", "SSHPublicKey", publicKey, "RouterHostID", config.App.Config.RouterHostID, "error", err)
-				os.Exit(1)
-			}
-
-			activateTunnelSpinner.UpdateText(fmt.Sprintf("Public key added to router host ~/.ssh/authorized_keys on %s", config.App.Config.RouterHostID))
-			activateTunnelSpinner.UpdateText("SSH key authorized")
-
-			// Retry starting the tunnel after the key is added
-			tunnelActive, connections, err = tunnel.ActivateTunnel(config.App)
-			if err != nil {
-				activateTunnelSpinner.Fail(fmt.Sprintf("Error activating tunnel: %s", err))
-				os.Exit(1)
-			}
+			activateTunnelSpinner.Fail(fmt.Sprintf("Error activating tunnel: %s", err))
+			os.Exit(1)
 		}
 
 		activateAttemptTunnelSpinner := ux.NewProgressSpinner("Activating Tunnel")
 		activateAttemptTunnelSpinner.Success("Tunnel is active")
 
 		// Clear the screen
-		ux.ClearLines(5)
+		ux.ClearLines(4)
 
 		activateAttemptTunnelSpinner.Status("Tunnel", tunnelActive, connections)
+
+		// Endpoints with invalid tags are not forwarded, make sure it doesn't go unnoticed
+		for _, skipped := range config.App.Config.SkippedHosts {
+			pterm.Warning.Printfln("Endpoint %s is skipped: %s", skipped.Key, skipped.Error)
+		}
+		if len(config.App.Config.SkippedHosts) > 0 && routerProvider.Type() == "ec2" {
+			pterm.Warning.Printfln("Run atun router validate --router %s to see how to fix the tags", config.App.Config.RouterHostID)
+		}
 		// TODO: Check if Instance has forwarding working (check ipv4.forwarding sysctl)
 		//ux.Println("Tunnel is active")
 
+		if watch {
+			// Keep the tunnel endpoints in sync with router tags until interrupted (ctrl+c cancels the command context)
+			ctx := cmd.Context()
+
+			// Reconnect (failing over if needed) when the tunnel goes down, e.g. the router is replaced or rebooted
+			reconnect := func() error {
+				// Clean up leftovers of the dead tunnel (e.g. an SSM session that's still running)
+				if _, err := routerProvider.Disconnect(config.App); err != nil {
+					logger.Debug("Error cleaning up the dead tunnel", "router", config.App.Config.RouterHostID, "error", err)
+				}
+
+				candidates := []string{routerHost}
+				if routerHost == "" {
+					var err error
+					if candidates, err = routerProvider.Discover(); err != nil {
+						return err
+					}
+				}
+
+				_, _, err := router.ConnectWithFailover(routerProvider, config.App, candidates)
+				return err
+			}
+
+			return tunnel.WatchRouterHosts(ctx, config.App, watchInterval, reconnect)
+		}
+
 		return nil
 	},
 }
@@ -199,5 +224,9 @@ func init() {
 	logger.Debug("Initializing up command")
 	upCmd.PersistentFlags().StringP("router", "r", "", "Router instance id to use. If not specified the first running instance with the atun.io tags is used")
 	upCmd.PersistentFlags().BoolP("create", "c", false, "Create ad-hoc router (if it doesn't exist). Will be managed by built-in CDKTf")
+	upCmd.PersistentFlags().String("transport", "", "Transport of EC2 routers: ssm or eice (EC2 Instance Connect Endpoint). Overrides the atun.io/transport router tag")
+	upCmd.PersistentFlags().Bool("repin-host-keys", false, "Pin host keys of the router anew if they changed on it, e.g. after it was rebuilt (ec2 routers)")
+	upCmd.PersistentFlags().BoolP("watch", "w", false, "Keep running, sync forwarded endpoints with router atun.io/host/* tags and reconnect if the tunnel goes down (ec2 routers)")
+	upCmd.PersistentFlags().Duration("watch-interval", 30*time.Second, "How often router tags are polled in --watch mode")
 	logger.Debug("Up command initialized")
 }
@@ -0,0 +1,174 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package aws
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/DimmKirr/atun/internal/config"
+	"github.com/DimmKirr/atun/internal/logger"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// profileSession is the session of the AWS profile. Sessions of other accounts are chained from it, even after app.Session is switched to a router's account
+var profileSession *session.Session
+
+// exportedCredentials is set when credentials of an assumed role are exported for aws CLI (ProxyCommand, shell)
+var exportedCredentials bool
+
+// Target is an AWS account routers are searched in
+type Target struct {
+	// Account is the account alias (or ID) shown in output
+	Account string
+	Session *session.Session
+	// Assumed is true for accounts reached by assuming a role from the profile
+	Assumed bool
+}
+
+// discoveryTargets caches targets of the profile session, so their credentials (and with them cached clients) are reused for the whole session
+var discoveryTargets struct {
+	sync.Mutex
+	base    *session.Session
+	targets []Target
+}
+
+// DiscoveryTargets returns the account of the profile and accounts from the accounts list of atun.toml (assumed roles chained from the profile session).
+// Targets are built once per profile session
+func DiscoveryTargets() ([]Target, error) {
+	base := baseSession()
+
+	discoveryTargets.Lock()
+	defer discoveryTargets.Unlock()
+
+	if discoveryTargets.base == base && discoveryTargets.targets != nil {
+		return discoveryTargets.targets, nil
+	}
+
+	targets, err := newDiscoveryTargets(base)
+	if err != nil {
+		return nil, err
+	}
+
+	discoveryTargets.base = base
+	discoveryTargets.targets = targets
+
+	return targets, nil
+}
+
+// newDiscoveryTargets builds targets chained from base, looking up aliases of accounts that don't have one configured
+func newDiscoveryTargets(base *session.Session) ([]Target, error) {
+
+	// Without other accounts there is nothing to tell apart, so the profile account is not looked up
+	if len(config.App.Config.Accounts) == 0 {
+		return []Target{{Session: base}}, nil
+	}
+
+	targets := []Target{{Account: accountAlias(base, ""), Session: base}}
+
+	for _, account := range config.App.Config.Accounts {
+		if account.RoleARN == "" {
+			return nil, fmt.Errorf("role_arn is required for accounts in atun.toml")
+		}
+
+		sessionName := account.SessionName
+		if sessionName == "" {
+			sessionName = "atun"
+		}
+
+		credentials := stscreds.NewCredentials(base, account.RoleARN, func(p *stscreds.AssumeRoleProvider) {
+			p.RoleSessionName = sessionName
+			if account.ExternalID != "" {
+				p.ExternalID = aws.String(account.ExternalID)
+			}
+		})
+
+		sess := base.Copy(&aws.Config{Credentials: credentials})
+		targets = append(targets, Target{Account: accountAlias(sess, account.Alias), Session: sess, Assumed: true})
+	}
+
+	return targets, nil
+}
+
+// baseSession returns the session of the AWS profile
+func baseSession() *session.Session {
+	if profileSession != nil {
+		return profileSession
+	}
+
+	return config.App.Session
+}
+
+// accountAlias returns the configured alias, the IAM account alias or the account ID (in this order of preference)
+func accountAlias(sess *session.Session, configured string) string {
+	if configured != "" {
+		return configured
+	}
+
+	if config.App.Config.DemoMode {
+		return "000000000000"
+	}
+
+	ctx, cancel := CallContext()
+	defer cancel()
+
+	iamClient, err := NewIAMClient(*sess.Config)
+	if err == nil {
+		var aliases *iam.ListAccountAliasesOutput
+		aliases, err = iamClient.ListAccountAliasesWithContext(ctx, &iam.ListAccountAliasesInput{})
+		if err == nil && len(aliases.AccountAliases) > 0 {
+			return aws.StringValue(aliases.AccountAliases[0])
+		}
+	}
+	logger.Debug("Can't get account alias. Using account ID", "error", err)
+
+	stsClient, err := NewSTSClient(*sess.Config)
+	if err != nil {
+		logger.Warn("Can't get account ID", "error", err)
+		return ""
+	}
+
+	identity, err := stsClient.GetCallerIdentityWithContext(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		logger.Warn("Can't get account ID", "error", err)
+		return ""
+	}
+
+	return aws.StringValue(identity.Account)
+}
+
+// exportCredentials makes aws CLI (run by ssh ProxyCommand or shell) use the credentials of sess instead of the profile.
+// Environment credentials take precedence over AWS_PROFILE in aws CLI
+func exportCredentials(sess *session.Session) error {
+	credentials, err := sess.Config.Credentials.Get()
+	if err != nil {
+		return fmt.Errorf("can't get credentials of the router account: %w", err)
+	}
+
+	os.Setenv("AWS_ACCESS_KEY_ID", credentials.AccessKeyID)
+	os.Setenv("AWS_SECRET_ACCESS_KEY", credentials.SecretAccessKey)
+	os.Setenv("AWS_SESSION_TOKEN", credentials.SessionToken)
+	exportedCredentials = true
+
+	return nil
+}
+
+// unexportCredentials reverts exportCredentials, so aws CLI uses the profile again
+func unexportCredentials() {
+	if !exportedCredentials {
+		return
+	}
+
+	os.Unsetenv("AWS_ACCESS_KEY_ID")
+	os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+	os.Unsetenv("AWS_SESSION_TOKEN")
+	exportedCredentials = false
+}
@@ -0,0 +1,74 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package aws
+
+import (
+	"testing"
+
+	"github.com/DimmKirr/atun/internal/config"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+func TestDiscoveryTargetsAreReused(t *testing.T) {
+	previous := config.App
+	t.Cleanup(func() { config.App = previous })
+
+	newSession := func() *session.Session {
+		sess, err := session.NewSession(&aws.Config{Region: aws.String("eu-central-1")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sess
+	}
+
+	// Aliases are configured and demo mode stands in for the profile account, so nothing is looked up in AWS
+	config.App = &config.Atun{
+		Session: newSession(),
+		Config: &config.Config{
+			DemoMode: true,
+			Accounts: []config.AccountTarget{
+				{Alias: "staging", RoleARN: "arn:aws:iam::111111111111:role/atun"},
+				{Alias: "prod", RoleARN: "arn:aws:iam::222222222222:role/atun", ExternalID: "atun"},
+			},
+		},
+	}
+
+	targets, err := DiscoveryTargets()
+	if err != nil {
+		t.Fatalf("DiscoveryTargets() returned an error: %v", err)
+	}
+	again, err := DiscoveryTargets()
+	if err != nil {
+		t.Fatalf("DiscoveryTargets() returned an error: %v", err)
+	}
+
+	if len(targets) != 3 || len(again) != 3 {
+		t.Fatalf("DiscoveryTargets() returned %d and %d targets, want 3", len(targets), len(again))
+	}
+	for i := range targets {
+		if targets[i].Session != again[i].Session || targets[i].Session.Config.Credentials != again[i].Session.Config.Credentials {
+			t.Errorf("target %s: session or credentials aren't reused", targets[i].Account)
+		}
+	}
+
+	// Clients of a target are cached by its credentials
+	client, _ := NewEC2Client(*targets[1].Session.Config)
+	clientAgain, _ := NewEC2Client(*again[1].Session.Config)
+	if client != clientAgain {
+		t.Error("EC2 client of an assumed account isn't reused")
+	}
+
+	// Another profile session gets its own targets
+	config.App.Session = newSession()
+	other, err := DiscoveryTargets()
+	if err != nil {
+		t.Fatalf("DiscoveryTargets() returned an error: %v", err)
+	}
+	if other[1].Session == targets[1].Session {
+		t.Error("targets of the previous profile session are reused")
+	}
+}
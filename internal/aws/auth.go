@@ -8,10 +8,13 @@ package aws
 import (
 	"fmt"
 	"github.com/DimmKirr/atun/internal/config"
+	"github.com/DimmKirr/atun/internal/constraints"
 	"github.com/DimmKirr/atun/internal/logger"
 	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/pterm/pterm"
 	"os"
+	"os/exec"
 	"path"
 	"strings"
 	"time"
@@ -28,9 +31,20 @@ type SessionConfig struct {
 	EndpointUrl              string
 	SharedCredentialsPath    string
 	MFASharedCredentialsPath string
+	// MFACode is used instead of asking for the code (e.g. --mfa-code)
+	MFACode string
+	// MFACommand prints the MFA code to stdout (e.g. a password manager or TOTP CLI)
+	MFACommand string
+	// MFADevice is the serial number (ARN) of the MFA device to use if the user has several
+	MFADevice string
 }
 
 func GetSession(sessionConfig *SessionConfig) (*session.Session, error) {
+	// Log in with IAM Identity Center (SSO) first if the token is missing or expired, so the session below doesn't fail
+	if err := EnsureSSOToken(sessionConfig.Profile); err != nil {
+		return nil, err
+	}
+
 	// Load base session using default AWS SDK logic (SSO compatible)
 	opts := session.Options{
 		SharedConfigState: session.SharedConfigEnable,
@@ -61,7 +75,13 @@ func GetSession(sessionConfig *SessionConfig) (*session.Session, error) {
 
 	logger.Debug("AWS session created", "profile", sessionConfig.Profile, "region", aws.StringValue(opts.Config.Region), "endpoint", sessionConfig.EndpointUrl)
 
-	identity, _ := sts.New(sess).GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	ctx, cancel := CallContext()
+	defer cancel()
+
+	identity, err := sts.New(sess).GetCallerIdentityWithContext(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get caller identity: %w", err)
+	}
 	if !strings.Contains(aws.StringValue(identity.Arn), ":user/") {
 		logger.Debug("MFA not applicable, principal is not a user", "arn", aws.StringValue(identity.Arn))
 		return sess, nil
@@ -69,7 +89,7 @@ func GetSession(sessionConfig *SessionConfig) (*session.Session, error) {
 
 	// Check for MFA devices (optional if you still want to force MFA usage)
 	iamSess := iam.New(sess)
-	devices, err := iamSess.ListMFADevices(&iam.ListMFADevicesInput{})
+	devices, err := iamSess.ListMFADevicesWithContext(ctx, &iam.ListMFADevicesInput{})
 	if err != nil {
 		// Allow localhost endpoints to skip IAM
 		if !strings.Contains(iamSess.Endpoint, "localhost") && !strings.Contains(iamSess.Endpoint, "127.0.0.1") {
@@ -91,7 +111,12 @@ func GetSession(sessionConfig *SessionConfig) (*session.Session, error) {
 	}
 
 	if mfaUpdateRequired {
-		cred, err := getNewToken(sess, devices.MFADevices[0].SerialNumber)
+		serialNumber, err := selectMFADevice(devices.MFADevices, sessionConfig.MFADevice)
+		if err != nil {
+			return nil, err
+		}
+
+		cred, err := getNewToken(sess, serialNumber, sessionConfig)
 		if err != nil {
 			return nil, err
 		}
@@ -133,15 +158,18 @@ func GetTestSession(c *SessionConfig) (*session.Session, error) {
 	return sess, nil
 }
 
-func getNewToken(sess *session.Session, serialNumber *string) (*sts.Credentials, error) {
+func getNewToken(sess *session.Session, serialNumber *string, sessionConfig *SessionConfig) (*sts.Credentials, error) {
 	stsSvc := sts.New(sess)
 
-	mfaCode, err := stscreds.StdinTokenProvider()
+	mfaCode, err := getMFACode(sessionConfig)
 	if err != nil {
 		return nil, err
 	}
 
-	out, err := stsSvc.GetSessionToken(&sts.GetSessionTokenInput{
+	ctx, cancel := CallContext()
+	defer cancel()
+
+	out, err := stsSvc.GetSessionTokenWithContext(ctx, &sts.GetSessionTokenInput{
 		SerialNumber: serialNumber,
 		TokenCode:    &mfaCode,
 	})
@@ -153,6 +181,68 @@ func getNewToken(sess *session.Session, serialNumber *string) (*sts.Credentials,
 	return out.Credentials, nil
 }
 
+// getMFACode returns the MFA code from the config (flag or env var), the output of the MFA command or asks for it on stdin (in this order)
+func getMFACode(sessionConfig *SessionConfig) (string, error) {
+	if sessionConfig.MFACode != "" {
+		return sessionConfig.MFACode, nil
+	}
+
+	if sessionConfig.MFACommand != "" {
+		logger.Debug("Getting MFA code from command", "command", sessionConfig.MFACommand)
+
+		cmd := exec.Command("sh", "-c", sessionConfig.MFACommand)
+		cmd.Stderr = os.Stderr
+		output, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("MFA command failed: %w", err)
+		}
+
+		mfaCode := strings.TrimSpace(string(output))
+		if mfaCode == "" {
+			return "", fmt.Errorf("MFA command returned an empty code")
+		}
+
+		return mfaCode, nil
+	}
+
+	return stscreds.StdinTokenProvider()
+}
+
+// selectMFADevice returns the configured MFA device, asks which one to use if there are several or returns the only one
+func selectMFADevice(devices []*iam.MFADevice, configured string) (*string, error) {
+	if configured != "" {
+		for _, device := range devices {
+			if aws.StringValue(device.SerialNumber) == configured {
+				return device.SerialNumber, nil
+			}
+		}
+
+		return nil, fmt.Errorf("MFA device %s is not assigned to the user", configured)
+	}
+
+	if len(devices) == 1 || !constraints.IsInteractiveTerminal() {
+		if len(devices) > 1 {
+			logger.Warn("Several MFA devices found. Using the first one (set aws_mfa_device to choose)", "device", aws.StringValue(devices[0].SerialNumber))
+		}
+		return devices[0].SerialNumber, nil
+	}
+
+	var options []string
+	for _, device := range devices {
+		options = append(options, aws.StringValue(device.SerialNumber))
+	}
+
+	selected, err := pterm.DefaultInteractiveSelect.
+		WithDefaultText(fmt.Sprintf(" %s  Select MFA device", pterm.LightBlue("?"))).
+		WithOptions(options).
+		Show()
+	if err != nil {
+		return nil, fmt.Errorf("error selecting MFA device: %w", err)
+	}
+
+	return aws.String(selected), nil
+}
+
 func writeCredsToFile(creds *sts.Credentials, f *ini.File, filepath, profile string) error {
 	sect, err := f.NewSection(fmt.Sprintf("%s-mfa", profile))
 	if err != nil {
@@ -230,6 +320,26 @@ func isMFAUpdateRequired(mfaCredFilePath string, profile string) (bool, error) {
 	return updateRequired, nil
 }
 
+// mfaTokenExpiration returns when the MFA session credentials of the profile expire
+func mfaTokenExpiration(mfaCredFilePath string, profile string) (time.Time, error) {
+	mfaCredFile, err := ini.Load(mfaCredFilePath)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	sect, err := mfaCredFile.GetSection(fmt.Sprintf("%s-mfa", profile))
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	exp, err := sect.GetKey("token_expiration")
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Parse("2006-01-02T15:04:05Z07:00", exp.String())
+}
+
 func GetMFASharedCredentialsPath() (string, error) {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
@@ -0,0 +1,115 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package aws
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+)
+
+func TestGetMFACode(t *testing.T) {
+	tests := []struct {
+		name          string
+		sessionConfig SessionConfig
+		want          string
+		wantErr       bool
+	}{
+		{
+			name:          "code (--mfa-code or ATUN_AWS_MFA_CODE)",
+			sessionConfig: SessionConfig{MFACode: "111111"},
+			want:          "111111",
+		},
+		{
+			name:          "code takes precedence over the command",
+			sessionConfig: SessionConfig{MFACode: "111111", MFACommand: "echo 222222"},
+			want:          "111111",
+		},
+		{
+			name:          "command output is trimmed",
+			sessionConfig: SessionConfig{MFACommand: "printf '  222222\\n\\n'"},
+			want:          "222222",
+		},
+		{
+			name:          "command fails",
+			sessionConfig: SessionConfig{MFACommand: "exit 1"},
+			wantErr:       true,
+		},
+		{
+			name:          "command prints nothing",
+			sessionConfig: SessionConfig{MFACommand: "echo"},
+			wantErr:       true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := getMFACode(&tt.sessionConfig)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getMFACode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("getMFACode() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectMFADevice(t *testing.T) {
+	virtual := &iam.MFADevice{SerialNumber: aws.String("arn:aws:iam::111111111111:mfa/jane")}
+	hardware := &iam.MFADevice{SerialNumber: aws.String("GAHT12345678")}
+
+	// Tests don't run in an interactive terminal, so several devices are never asked about
+	tests := []struct {
+		name       string
+		devices    []*iam.MFADevice
+		configured string
+		want       string
+		wantErr    bool
+	}{
+		{
+			name:    "single device",
+			devices: []*iam.MFADevice{virtual},
+			want:    "arn:aws:iam::111111111111:mfa/jane",
+		},
+		{
+			name:    "several devices use the first one",
+			devices: []*iam.MFADevice{hardware, virtual},
+			want:    "GAHT12345678",
+		},
+		{
+			name:       "configured device",
+			devices:    []*iam.MFADevice{hardware, virtual},
+			configured: "arn:aws:iam::111111111111:mfa/jane",
+			want:       "arn:aws:iam::111111111111:mfa/jane",
+		},
+		{
+			name:       "configured device of a single one",
+			devices:    []*iam.MFADevice{virtual},
+			configured: "arn:aws:iam::111111111111:mfa/jane",
+			want:       "arn:aws:iam::111111111111:mfa/jane",
+		},
+		{
+			name:       "configured device isn't assigned",
+			devices:    []*iam.MFADevice{hardware, virtual},
+			configured: "arn:aws:iam::111111111111:mfa/john",
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := selectMFADevice(tt.devices, tt.configured)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("selectMFADevice() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if aws.StringValue(got) != tt.want {
+				t.Errorf("selectMFADevice() = %q, want %q", aws.StringValue(got), tt.want)
+			}
+		})
+	}
+}
@@ -6,14 +6,18 @@
 package aws
 
 import (
+	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"os/exec"
+	"path/filepath"
 
 	"github.com/DimmKirr/atun/internal/config"
 	"github.com/DimmKirr/atun/internal/constraints"
 	"github.com/DimmKirr/atun/internal/logger"
+	"github.com/DimmKirr/atun/internal/ssh"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/elasticache"
 	"github.com/aws/aws-sdk-go/service/opensearchservice"
@@ -21,8 +25,10 @@ import (
 	"github.com/aws/aws-sdk-go/service/ssm"
 	"github.com/aws/aws-sdk-go/service/sts"
 	"github.com/pterm/pterm"
+	ssh2 "golang.org/x/crypto/ssh"
 	"os"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -42,6 +48,9 @@ func InitAWSClients(app *config.Atun) {
 		Profile:                  app.Config.AWSProfile,
 		EndpointUrl:              app.Config.AWSEndpointUrl,
 		MFASharedCredentialsPath: app.Config.AWSMFASharedCredentialsFile,
+		MFACode:                  app.Config.AWSMFACode,
+		MFACommand:               app.Config.AWSMFACommand,
+		MFADevice:                app.Config.AWSMFADevice,
 	})
 
 	if err != nil {
@@ -56,94 +65,46 @@ func InitAWSClients(app *config.Atun) {
 	logger.Debug("AWS Session initialized")
 
 	app.Session = sess
+	profileSession = sess
 }
 
-func NewEC2Client(awsConfig aws.Config) (*ec2.EC2, error) {
-	logger.Debug("Creating EC2 client.",
-		"AWSProfile", config.App.Config.AWSProfile,
-		"awsRegion", config.App.Config.AWSRegion,
-		"endpointURL", aws.StringValue(awsConfig.Endpoint),
-	)
-
-	sess, err := session.NewSessionWithOptions(session.Options{
-		Config:            awsConfig,
-		SharedConfigState: session.SharedConfigEnable,
-	})
-	if err != nil {
-		return nil, err
-	}
-
-	ec2Client := ec2.New(sess)
-
-	return ec2Client, nil
+// ListInstancesWithTag returns a list of EC2 instances with the tags (set to one of the values) in all discovery regions (see DiscoveryRegions)
+func ListInstancesWithTags(tags map[string][]string) ([]*ec2.Instance, error) {
+	return ListInstancesWithTagsInStates(tags, []string{ec2.InstanceStateNameRunning})
 }
 
-func NewRDSClient(awsConfig aws.Config) (*rds.RDS, error) {
-	logger.Debug("Creating RDS client.", "AWSProfile", config.App.Config.AWSProfile, "awsRegion", config.App.Config.AWSRegion, "endpointURL", awsConfig.Endpoint)
-
-	sess, err := session.NewSessionWithOptions(session.Options{
-		Config:            awsConfig,
-		SharedConfigState: session.SharedConfigEnable,
-	})
-	if err != nil {
-		return nil, err
+// ListInstancesWithTagsInStates returns instances in one of the states that have all the given tags with one of the accepted values
+func ListInstancesWithTagsInStates(tags map[string][]string, states []string) ([]*ec2.Instance, error) {
+	if len(tags) == 0 {
+		return nil, fmt.Errorf("no tags provided for filtering")
 	}
 
-	rdsClient := rds.New(sess)
-
-	return rdsClient, nil
-}
+	filters := []*ec2.Filter{
+		{
+			Name:   aws.String("instance-state-name"),
+			Values: aws.StringSlice(states),
+		},
+	}
+	for key, values := range tags {
+		filters = append(filters, &ec2.Filter{
+			Name:   aws.String(fmt.Sprintf("tag:%s", key)),
+			Values: aws.StringSlice(values),
+		})
+	}
 
-func NewSTSClient(awsConfig aws.Config) (*sts.STS, error) {
-	sess, err := session.NewSessionWithOptions(session.Options{
-		Config: awsConfig,
-	})
+	regions, err := DiscoveryRegions()
 	if err != nil {
 		return nil, err
 	}
+	logger.Debug("Searching for instances", "regions", regions)
 
-	stsClient := sts.New(sess)
-
-	logger.Debug("Created STS client", "AWSProfile", config.App.Config.AWSProfile, "AWSRegion", config.App.Config.AWSRegion)
-	return stsClient, nil
-}
-
-// ListInstancesWithTag returns a list of EC2 instances with a specific tag
-func ListInstancesWithTags(tags map[string]string) ([]*ec2.Instance, error) {
-	ec2Client, err := NewEC2Client(*config.App.Session.Config)
+	// Targets are cached per session, so clients of assumed accounts are reused across discovery calls
+	targets, err := DiscoveryTargets()
 	if err != nil {
-		logger.Error("Failed to create EC2 client", "error", err)
 		return nil, err
 	}
 
-	if len(tags) == 0 {
-		return nil, fmt.Errorf("no tags provided for filtering")
-	}
-
-	var filters []*ec2.Filter
-	for key, value := range tags {
-		filters = append(filters,
-			&ec2.Filter{
-				Name:   aws.String(fmt.Sprintf("tag:%s", key)),
-				Values: []*string{aws.String(value)},
-			},
-			&ec2.Filter{
-				Name:   aws.String("instance-state-name"),
-				Values: []*string{aws.String("running")},
-			})
-	}
-
-	input := &ec2.DescribeInstancesInput{
-		Filters: filters,
-	}
-
-	var instances []*ec2.Instance
-	err = ec2Client.DescribeInstancesPages(input, func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
-		for _, reservation := range page.Reservations {
-			instances = append(instances, reservation.Instances...)
-		}
-		return !lastPage
-	})
+	instances, err := listInstances(targets, regions, filters)
 	if err != nil {
 		logger.Error("Failed to describe instances", "error", err)
 		return nil, err
@@ -154,7 +115,10 @@ func ListInstancesWithTags(tags map[string]string) ([]*ec2.Instance, error) {
 }
 
 func GetInstanceTags(instanceID string) (map[string]string, error) {
-	ec2Client, err := NewEC2Client(*config.App.Session.Config)
+	ctx, cancel := CallContext()
+	defer cancel()
+
+	ec2Client, err := NewEC2Client(instanceConfig(instanceID))
 	if err != nil {
 		logger.Error("Failed to create EC2 client", "error", err)
 		return nil, err
@@ -164,7 +128,7 @@ func GetInstanceTags(instanceID string) (map[string]string, error) {
 		InstanceIds: []*string{aws.String(instanceID)},
 	}
 
-	result, err := ec2Client.DescribeInstances(input)
+	result, err := ec2Client.DescribeInstancesWithContext(ctx, input)
 	if err != nil {
 		logger.Error("Failed to describe instances", "error", err)
 		return nil, err
@@ -187,149 +151,182 @@ func GetInstanceTags(instanceID string) (map[string]string, error) {
 	return tags, nil
 }
 
+// SetInstanceTags creates the tags on the instance, overwriting values of existing ones
+func SetInstanceTags(instanceID string, tags map[string]string) error {
+	ctx, cancel := CallContext()
+	defer cancel()
+
+	ec2Client, err := NewEC2Client(instanceConfig(instanceID))
+	if err != nil {
+		return err
+	}
+
+	var ec2Tags []*ec2.Tag
+	for key, value := range tags {
+		ec2Tags = append(ec2Tags, &ec2.Tag{Key: aws.String(key), Value: aws.String(value)})
+	}
+
+	_, err = ec2Client.CreateTagsWithContext(ctx, &ec2.CreateTagsInput{
+		Resources: []*string{aws.String(instanceID)},
+		Tags:      ec2Tags,
+	})
+	if err != nil {
+		return fmt.Errorf("can't tag instance %s: %w", instanceID, err)
+	}
+
+	return nil
+}
+
+// accountIDs caches account IDs by session credentials, as the account is shown by several steps of a command
+var accountIDs sync.Map
+
 func GetAccountId() string {
+	if config.App.Session == nil {
+		return ""
+	}
+
+	if config.App.Config.DemoMode {
+		return "000000000000"
+	}
+
+	if accountID, ok := accountIDs.Load(config.App.Session.Config.Credentials); ok {
+		return accountID.(string)
+	}
+
+	ctx, cancel := CallContext()
+	defer cancel()
+
 	stsClient, err := NewSTSClient(*config.App.Session.Config)
 	if err != nil {
 		logger.Error("Error creating STS client", "error", err)
 		return ""
 	}
 
-	result, err := stsClient.GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	result, err := stsClient.GetCallerIdentityWithContext(ctx, &sts.GetCallerIdentityInput{})
 	if err != nil {
 		logger.Error("Error getting account ID", "error", err)
 		return ""
 	}
-	accountID := *result.Account
-	if config.App.Config.DemoMode {
-		accountID = "000000000000"
-	}
+	accountID := aws.StringValue(result.Account)
+	accountIDs.Store(config.App.Session.Config.Credentials, accountID)
+
 	return accountID
 }
 
-// GetSSMWhoAmI checks if the SSH public key is present in the instance
-func GetSSMWhoAmI(instanceID string, routerHostUser string) (string, error) {
-	command := fmt.Sprintf(
-		`bash -c 'whoami'`,
-	)
-
-	logger.Debug("Sending SSM command", "command", command)
-
-	sendCommandOutput, err := ssm.New(config.App.Session).SendCommand(&ssm.SendCommandInput{
-		InstanceIds:  []*string{&instanceID},
-		DocumentName: aws.String("AWS-RunShellScript"),
-		Comment:      aws.String("Check if SSH public key is present in authorized_keys"),
-		Parameters: map[string][]*string{
-			"commands": {&command},
-		},
+// EnsureSSHPublicKeyPresent adds the public key to authorized_keys of routerHostUser on the instance if it's not there yet.
+// Session keys of atun older than ssh_session_key_ttl are removed from authorized_keys along the way
+func EnsureSSHPublicKeyPresent(instanceID string, publicKey string, routerHostUser string) error {
+	_, err := RunCommand([]string{instanceID}, ssh.AuthorizeKeyScript(userHomeDirectory(routerHostUser), publicKey, config.App.Config.SSHSessionKeyTTL), CommandOptions{
+		Comment: "Add an SSH public key to authorized_keys",
+		Timeout: time.Minute,
 	})
 	if err != nil {
-		return "", fmt.Errorf("can't send command: %w", err)
+		return fmt.Errorf("can't add SSH public key: %w", err)
 	}
 
-	commandID := *sendCommandOutput.Command.CommandId
-	logger.Debug("SSM command sent. Waiting for completion", "commandID", commandID)
+	return nil
+}
 
-	var output *ssm.GetCommandInvocationOutput
-	for i := 0; i < 5; i++ {
-		output, err = ssm.New(config.App.Session).GetCommandInvocation(&ssm.GetCommandInvocationInput{
-			CommandId:  aws.String(commandID),
-			InstanceId: aws.String(instanceID),
-		})
-		if err == nil && *output.Status == ssm.CommandInvocationStatusSuccess && output.ResponseCode != nil {
-			break
-		}
-		time.Sleep(2 * time.Second)
-	}
+// RemoveSSHPublicKey removes the public key from authorized_keys of routerHostUser on the instance
+func RemoveSSHPublicKey(instanceID string, publicKey string, routerHostUser string) error {
+	return RemoveSSHPublicKeys(instanceID, []string{publicKey}, routerHostUser)
+}
+
+// GetAuthorizedKeys returns the contents of authorized_keys of routerHostUser on the instance, empty if there is no such file
+func GetAuthorizedKeys(instanceID string, routerHostUser string) (string, error) {
+	authorizedKeys := fmt.Sprintf("%s/.ssh/authorized_keys", userHomeDirectory(routerHostUser))
+
+	results, err := RunCommand([]string{instanceID}, fmt.Sprintf("if [ -f %[1]s ]; then cat %[1]s; fi", authorizedKeys), CommandOptions{
+		Comment: "Read authorized_keys",
+		Timeout: time.Minute,
+	})
 	if err != nil {
-		return "", fmt.Errorf("can't get command invocation: %w", err)
+		return "", fmt.Errorf("can't read authorized_keys: %w", err)
 	}
 
-	if strings.Contains(config.App.Config.AWSEndpointUrl, "localhost") || strings.Contains(config.App.Config.AWSEndpointUrl, "127.0.0.1") {
-		if *output.Status != "Success" {
-			return "", fmt.Errorf("command failed %s: %s", *output.StandardOutputContent, *output.StandardErrorContent)
-		}
-		return "localstack", nil
-	}
+	return results[0].Stdout, nil
+}
 
-	if *output.ResponseCode != 0 && *output.Status != "Success" {
-		return "", fmt.Errorf("command failed with exit code %d: %s", *output.ResponseCode, *output.StandardErrorContent)
-	}
+// RemoveSSHPublicKeys removes the public keys from authorized_keys of routerHostUser on the instance
+func RemoveSSHPublicKeys(instanceID string, publicKeys []string, routerHostUser string) error {
+	authorizedKeys := fmt.Sprintf("%s/.ssh/authorized_keys", userHomeDirectory(routerHostUser))
 
-	whoamiResponse := *output.StandardOutputContent
+	_, err := RunCommand([]string{instanceID}, ssh.RevokeKeyCommand(authorizedKeys, publicKeys...), CommandOptions{
+		Comment: "Remove SSH public keys from authorized_keys",
+		Timeout: time.Minute,
+	})
+	if err != nil {
+		return fmt.Errorf("can't remove SSH public key: %w", err)
+	}
 
-	return whoamiResponse, nil
+	return nil
 }
 
-func EnsureSSHPublicKeyPresent(instanceID string, publicKey string, routerHostUser string) error {
-	// This command is executed in the router host and it checks if our public publicKey is present. If it's not it uploads it to the authorized_keys file.
-	// If the routerHostUser is "root" then set routerHostUserDirectory to /root otherwise set it to /home/routerHostUser
-	routerHostUserDirectory := fmt.Sprintf("/home/%s", routerHostUser)
-	if routerHostUser == "root" {
-		routerHostUserDirectory = "/root"
-	}
-
-	command := fmt.Sprintf(
-		`bash -c 'mkdir -p %s/.ssh && grep --qR "%s" %s/.ssh/authorized_keys || echo "%s" >> %s/.ssh/authorized_keys'`,
-		routerHostUserDirectory,
-		strings.TrimSpace(publicKey),
-		routerHostUserDirectory,
-		strings.TrimSpace(publicKey),
-		routerHostUserDirectory,
-	)
+// TrustSSHCA makes sshd on the instance accept user certificates signed by the CA
+func TrustSSHCA(instanceID string, caPublicKey string) error {
+	_, err := RunCommand([]string{instanceID}, ssh.TrustCAScript(caPublicKey), CommandOptions{
+		Comment: "Trust the atun SSH user CA",
+		Timeout: time.Minute,
+	})
+	if err != nil {
+		return fmt.Errorf("can't install SSH CA: %w", err)
+	}
 
-	logger.Debug("Sending command", "command", command)
+	return nil
+}
 
-	sendCommandOutput, err := ssm.New(config.App.Session).SendCommand(&ssm.SendCommandInput{
-		InstanceIds:  []*string{&instanceID},
-		DocumentName: aws.String("AWS-RunShellScript"),
-		Comment:      aws.String("Add an SSH public publicKey to authorized_keys"),
-		Parameters: map[string][]*string{
-			"commands": {&command},
-		},
+// GetHostKeys returns SSH host keys of the instance read over SSM
+func GetHostKeys(instanceID string) ([]ssh2.PublicKey, error) {
+	results, err := RunCommand([]string{instanceID}, ssh.HostKeysScript, CommandOptions{
+		Comment: "Read SSH host keys",
+		Timeout: time.Minute,
 	})
 	if err != nil {
-		return fmt.Errorf("can't send SSH public publicKey: %w", err)
+		return nil, fmt.Errorf("can't read SSH host keys: %w", err)
 	}
 
-	commandID := *sendCommandOutput.Command.CommandId
-	logger.Debug("SSM command sent. Waiting for completion", "commandID", commandID)
+	return ssh.ParseHostKeys(results[0].Stdout), nil
+}
 
-	var output *ssm.GetCommandInvocationOutput
-	for i := 0; i < 5; i++ {
-		output, err = ssm.New(config.App.Session).GetCommandInvocation(&ssm.GetCommandInvocationInput{
-			CommandId:  aws.String(commandID),
-			InstanceId: aws.String(instanceID),
-		})
-		if err == nil && *output.Status == ssm.CommandInvocationStatusSuccess && output.ResponseCode != nil {
-			break
-		}
-		time.Sleep(2 * time.Second)
-	}
+// GetConsoleHostKeys returns SSH host keys cloud-init printed to the console output of the instance
+func GetConsoleHostKeys(instanceID string) ([]ssh2.PublicKey, error) {
+	ctx, cancel := CallContext()
+	defer cancel()
+
+	ec2Client, err := NewEC2Client(instanceConfig(instanceID))
 	if err != nil {
-		return fmt.Errorf("can't get command invocation: %w", err)
+		return nil, err
 	}
 
-	//
-
-	// Separate success criteria localstack ssm implementation bug where ResponseCode == nil)
-	if strings.Contains(config.App.Config.AWSEndpointUrl, "localhost") || strings.Contains(config.App.Config.AWSEndpointUrl, "127.0.0.1") {
-		if *output.Status != "Success" {
-			return fmt.Errorf("command failed %s: %s, ", *output.StandardOutputContent, *output.StandardErrorContent)
-		}
+	result, err := ec2Client.GetConsoleOutputWithContext(ctx, &ec2.GetConsoleOutputInput{
+		InstanceId: aws.String(instanceID),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't get console output: %w", err)
+	}
 
-		return nil
+	consoleOutput, err := base64.StdEncoding.DecodeString(aws.StringValue(result.Output))
+	if err != nil {
+		return nil, fmt.Errorf("can't decode console output: %w", err)
 	}
 
-	// Non-localstack success criteria
-	if *output.ResponseCode != 0 && *output.Status != "Success" {
-		return fmt.Errorf("command failed with exit code %d: %s, ", *output.ResponseCode, *output.StandardErrorContent)
+	return ssh.ParseConsoleHostKeys(string(consoleOutput)), nil
+}
+
+// userHomeDirectory returns the home directory of a user on the router: /root for root, /home/<user> otherwise
+func userHomeDirectory(user string) string {
+	if user == "root" {
+		return "/root"
 	}
 
-	return nil
+	return fmt.Sprintf("/home/%s", user)
 }
 
 // GetVPCIDFromSubnet returns the VPC ID for a given subnet ID
 func GetVPCIDFromSubnet(subnetID string) (string, error) {
+	ctx, cancel := CallContext()
+	defer cancel()
+
 	ec2Client, err := NewEC2Client(*config.App.Session.Config)
 	if err != nil {
 		return "", err
@@ -341,7 +338,7 @@ func GetVPCIDFromSubnet(subnetID string) (string, error) {
 		SubnetIds: []*string{aws.String(subnetID)},
 	}
 
-	result, err := ec2Client.DescribeSubnets(input)
+	result, err := ec2Client.DescribeSubnetsWithContext(ctx, input)
 	if err != nil {
 		return "", err
 	}
@@ -355,13 +352,16 @@ func GetVPCIDFromSubnet(subnetID string) (string, error) {
 
 // CheckSubnetNetworkAccess checks if the subnet has network access by checking routes
 func CheckSubnetNetworkAccess(subnetID string) (bool, bool, error) {
+	ctx, cancel := CallContext()
+	defer cancel()
+
 	ec2Client, err := NewEC2Client(*config.App.Session.Config)
 	if err != nil {
 		return false, false, fmt.Errorf("failed to create EC2 client: %w", err)
 	}
 
 	// Get the route table associated with the subnet
-	routeTablesOutput, err := ec2Client.DescribeRouteTables(&ec2.DescribeRouteTablesInput{
+	routeTablesOutput, err := ec2Client.DescribeRouteTablesWithContext(ctx, &ec2.DescribeRouteTablesInput{
 		Filters: []*ec2.Filter{
 			{
 				Name:   aws.String("association.subnet-id"),
@@ -389,6 +389,9 @@ func CheckSubnetNetworkAccess(subnetID string) (bool, bool, error) {
 }
 
 func GetSubnetsWithSSM() ([]*ec2.Subnet, error) {
+	ctx, cancel := CallContext()
+	defer cancel()
+
 	ec2Client, err := NewEC2Client(*config.App.Session.Config)
 	if err != nil {
 		return nil, err
@@ -397,7 +400,7 @@ func GetSubnetsWithSSM() ([]*ec2.Subnet, error) {
 	input := &ec2.DescribeSubnetsInput{}
 
 	var subnets []*ec2.Subnet
-	err = ec2Client.DescribeSubnetsPages(input, func(page *ec2.DescribeSubnetsOutput, lastPage bool) bool {
+	err = ec2Client.DescribeSubnetsPagesWithContext(ctx, input, func(page *ec2.DescribeSubnetsOutput, lastPage bool) bool {
 		for _, subnet := range page.Subnets {
 			hasAccess, _, err := CheckSubnetNetworkAccess(*subnet.SubnetId)
 			if err != nil {
@@ -419,6 +422,9 @@ func GetSubnetsWithSSM() ([]*ec2.Subnet, error) {
 
 // GetAvailableKeyPairs returns a list of available key pairs in AWS Account
 func GetAvailableKeyPairs() ([]*ec2.KeyPairInfo, error) {
+	ctx, cancel := CallContext()
+	defer cancel()
+
 	ec2Client, err := NewEC2Client(*config.App.Session.Config)
 	if err != nil {
 		return nil, err
@@ -426,7 +432,7 @@ func GetAvailableKeyPairs() ([]*ec2.KeyPairInfo, error) {
 
 	input := &ec2.DescribeKeyPairsInput{}
 
-	result, err := ec2Client.DescribeKeyPairs(input)
+	result, err := ec2Client.DescribeKeyPairsWithContext(ctx, input)
 	if err != nil {
 		return nil, err
 	}
@@ -456,13 +462,16 @@ func InferPortByHost(host string) (int, error) {
 
 // inferPortFromRDS checks RDS clusters for a matching endpoint and returns its port.
 func inferPortFromRDS(host string) (int, error) {
+	ctx, cancel := CallContext()
+	defer cancel()
+
 	rdsClient, err := NewRDSClient(*config.App.Session.Config)
 	if err != nil {
 		return 0, fmt.Errorf("failed to create RDS client: %v", err)
 	}
 
 	var clusters []*rds.DBCluster
-	err = rdsClient.DescribeDBClustersPages(&rds.DescribeDBClustersInput{},
+	err = rdsClient.DescribeDBClustersPagesWithContext(ctx, &rds.DescribeDBClustersInput{},
 		func(page *rds.DescribeDBClustersOutput, lastPage bool) bool {
 			clusters = append(clusters, page.DBClusters...)
 			return !lastPage
@@ -481,13 +490,20 @@ func inferPortFromRDS(host string) (int, error) {
 
 // inferPortFromElastiCache checks ElastiCache clusters (Redis and Memcached) for a matching endpoint and returns its port.
 func inferPortFromElastiCache(host string) (int, error) {
-	elastiCacheClient := elasticache.New(session.New()) // Initialize ElastiCache client
+	ctx, cancel := CallContext()
+	defer cancel()
+
+	elastiCacheClient, err := NewElastiCacheClient(*config.App.Session.Config)
+	if err != nil {
+		return 0, err
+	}
+
 	input := &elasticache.DescribeCacheClustersInput{
 		ShowCacheNodeInfo: aws.Bool(true),
 	}
 
 	var clusters []*elasticache.CacheCluster
-	err := elastiCacheClient.DescribeCacheClustersPages(input,
+	err = elastiCacheClient.DescribeCacheClustersPagesWithContext(ctx, input,
 		func(page *elasticache.DescribeCacheClustersOutput, lastPage bool) bool {
 			clusters = append(clusters, page.CacheClusters...)
 			return !lastPage
@@ -508,7 +524,13 @@ func inferPortFromElastiCache(host string) (int, error) {
 
 // inferPortFromOpenSearch checks OpenSearch domains for a matching endpoint and returns the default port (443 for HTTPS).
 func inferPortFromOpenSearch(host string) (int, error) {
-	osClient := opensearchservice.New(session.New()) // Initialize OpenSearch client
+	ctx, cancel := CallContext()
+	defer cancel()
+
+	osClient, err := NewOpenSearchClient(*config.App.Session.Config)
+	if err != nil {
+		return 0, err
+	}
 
 	var domains []*opensearchservice.DomainStatus
 	input := &opensearchservice.DescribeDomainsInput{
@@ -516,7 +538,7 @@ func inferPortFromOpenSearch(host string) (int, error) {
 	}
 
 	// Get all OpenSearch domain names first
-	domainsList, err := osClient.ListDomainNames(&opensearchservice.ListDomainNamesInput{})
+	domainsList, err := osClient.ListDomainNamesWithContext(ctx, &opensearchservice.ListDomainNamesInput{})
 	if err != nil {
 		return 0, fmt.Errorf("failed to list OpenSearch domains: %v", err)
 	}
@@ -525,7 +547,7 @@ func inferPortFromOpenSearch(host string) (int, error) {
 	}
 
 	// Describe each domain to find endpoint information
-	output, err := osClient.DescribeDomains(input)
+	output, err := osClient.DescribeDomainsWithContext(ctx, input)
 	if err != nil {
 		return 0, fmt.Errorf("failed to describe OpenSearch domains: %v", err)
 	}
@@ -541,13 +563,16 @@ func inferPortFromOpenSearch(host string) (int, error) {
 }
 
 func WaitForInstanceReady(instanceID string) error {
+	// Booting and registering in SSM takes longer than a single call, so the wait has its own deadline
+	ctx, cancel := context.WithTimeout(commandContext, instanceReadyTimeout)
+	defer cancel()
+
 	if strings.Contains(config.App.Config.AWSEndpointUrl, "localhost") {
 		logger.Debug("Skipping actual checking the instance to be ready in localstack, since it doesn't support it.")
 		// wait 1 second to simulate the instance to be ready
 
 		logger.Debug("Just Waiting 5 seconds for the instance to be ready")
-		time.Sleep(5 * time.Second)
-		return nil
+		return sleep(ctx, 5*time.Second)
 	}
 
 	ec2Client, err := NewEC2Client(*config.App.Session.Config)
@@ -559,12 +584,15 @@ func WaitForInstanceReady(instanceID string) error {
 		InstanceIds: []*string{&instanceID},
 	}
 
-	err = ec2Client.WaitUntilInstanceRunning(input)
+	err = ec2Client.WaitUntilInstanceRunningWithContext(ctx, input)
 	if err != nil {
 		return err
 	}
 
-	ssmClient := ssm.New(config.App.Session)
+	ssmClient, err := NewSSMClient(*config.App.Session.Config)
+	if err != nil {
+		return err
+	}
 	ssmInput := &ssm.DescribeInstanceInformationInput{
 		InstanceInformationFilterList: []*ssm.InstanceInformationFilter{
 			{
@@ -574,15 +602,18 @@ func WaitForInstanceReady(instanceID string) error {
 		},
 	}
 
-	timeout := time.After(5 * time.Minute)
-	tick := time.Tick(10 * time.Second)
+	tick := time.NewTicker(10 * time.Second)
+	defer tick.Stop()
 
 	for {
 		select {
-		case <-timeout:
-			return fmt.Errorf("timeout waiting for instance %s to be ready", instanceID)
-		case <-tick:
-			ssmOutput, err := ssmClient.DescribeInstanceInformation(ssmInput)
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return fmt.Errorf("timeout waiting for instance %s to be ready", instanceID)
+			}
+			return ctx.Err()
+		case <-tick.C:
+			ssmOutput, err := ssmClient.DescribeInstanceInformationWithContext(ctx, ssmInput)
 			if err != nil {
 				return fmt.Errorf("error describing instance information: %w", err)
 			}
@@ -594,9 +625,82 @@ func WaitForInstanceReady(instanceID string) error {
 	}
 }
 
-// GetInstanceUsername retrieves the default SSH username for an EC2 instance.
+// GetSSMInstanceInformation returns SSM agent information (ping status, agent version) of the instances registered in SSM, keyed by instance ID
+func GetSSMInstanceInformation(instanceIDs []string) (map[string]*ssm.InstanceInformation, error) {
+	ctx, cancel := CallContext()
+	defer cancel()
+
+	information := map[string]*ssm.InstanceInformation{}
+
+	// Instances are registered in SSM of their own account and region
+	locationInstanceIDs := map[string][]string{}
+	for _, instanceID := range instanceIDs {
+		location := fmt.Sprintf("%s/%s", InstanceAccount(instanceID), InstanceRegion(instanceID))
+		locationInstanceIDs[location] = append(locationInstanceIDs[location], instanceID)
+	}
+
+	for location, ids := range locationInstanceIDs {
+		ssmClient, err := NewSSMClient(instanceConfig(ids[0]))
+		if err != nil {
+			return nil, err
+		}
+
+		// InstanceIds filter accepts up to 50 values
+		for start := 0; start < len(ids); start += 50 {
+			end := min(start+50, len(ids))
+
+			err = ssmClient.DescribeInstanceInformationPagesWithContext(ctx, &ssm.DescribeInstanceInformationInput{
+				Filters: []*ssm.InstanceInformationStringFilter{
+					{
+						Key:    aws.String("InstanceIds"),
+						Values: aws.StringSlice(ids[start:end]),
+					},
+				},
+			}, func(page *ssm.DescribeInstanceInformationOutput, lastPage bool) bool {
+				for _, info := range page.InstanceInformationList {
+					information[aws.StringValue(info.InstanceId)] = info
+				}
+				return !lastPage
+			})
+			if err != nil {
+				return nil, fmt.Errorf("error describing instance information in %s: %w", location, err)
+			}
+		}
+	}
+
+	return information, nil
+}
+
+// instanceUsers caches SSH users of instances by instance ID, as detecting one can take an SSM command
+var instanceUsers sync.Map
+
+// probeUsernameScript prints the user to SSH as on an instance: the default user of cloud-init if it exists,
+// the first regular user with a login shell otherwise
+const probeUsernameScript = `user=$(awk '/default_user:/ { found = 1 } found && /name:/ { print $2; exit }' /etc/cloud/cloud.cfg /etc/cloud/cloud.cfg.d/*.cfg 2>/dev/null)
+if [ -n "$user" ] && getent passwd "$user" > /dev/null; then echo "$user"; exit 0; fi
+getent passwd | awk -F: '$3 >= 1000 && $3 < 65534 && $7 !~ /(nologin|false)$/ { print $1; exit }'`
+
+// GetInstanceUsername retrieves the SSH username for an EC2 instance: the atun.io/user tag, the default user of the AMI
+// or, for AMIs the user is not known for (e.g. custom or hardened ones), the user found on the instance over SSM
 func GetInstanceUsername(instanceID string) (string, error) {
-	ec2Client, err := NewEC2Client(*config.App.Session.Config)
+	if username, ok := instanceUsers.Load(instanceID); ok {
+		return username.(string), nil
+	}
+
+	username, err := detectInstanceUsername(instanceID)
+	if err != nil {
+		return "", err
+	}
+	instanceUsers.Store(instanceID, username)
+
+	return username, nil
+}
+
+func detectInstanceUsername(instanceID string) (string, error) {
+	ctx, cancel := CallContext()
+	defer cancel()
+
+	ec2Client, err := NewEC2Client(instanceConfig(instanceID))
 	if err != nil {
 		logger.Error("Failed to create EC2 client", "error", err)
 		return "", err
@@ -606,7 +710,7 @@ func GetInstanceUsername(instanceID string) (string, error) {
 		InstanceIds: []*string{aws.String(instanceID)},
 	}
 
-	result, err := ec2Client.DescribeInstances(input)
+	result, err := ec2Client.DescribeInstancesWithContext(ctx, input)
 	if err != nil {
 		logger.Error("Failed to describe instance", "error", err)
 		return "", err
@@ -618,33 +722,94 @@ func GetInstanceUsername(instanceID string) (string, error) {
 
 	instance := result.Reservations[0].Instances[0]
 
-	// Get AMI ID
-	if instance.ImageId == nil {
-		return "", fmt.Errorf("instance %s has no AMI ID", instanceID)
+	// The tag overrides detection
+	for _, tag := range instance.Tags {
+		if aws.StringValue(tag.Key) == "atun.io/user" && aws.StringValue(tag.Value) != "" {
+			logger.Debug("Username set with a tag", "instance", instanceID, "user", aws.StringValue(tag.Value))
+			return aws.StringValue(tag.Value), nil
+		}
 	}
-	amiID := *instance.ImageId
 
-	// Get AMI details
-	amiInput := &ec2.DescribeImagesInput{
-		ImageIds: []*string{aws.String(amiID)},
+	// Get AMI details. Private AMIs of other accounts and deregistered AMIs can't be described, the user is probed then
+	if instance.ImageId != nil {
+		amiResult, err := ec2Client.DescribeImagesWithContext(ctx, &ec2.DescribeImagesInput{
+			ImageIds: []*string{instance.ImageId},
+		})
+		if err == nil && len(amiResult.Images) > 0 {
+			amiName := aws.StringValue(amiResult.Images[0].Name)
+
+			// Determine default username based on AMI name
+			if username := detectUsernameFromAMI(amiName); username != "" {
+				return username, nil
+			}
+			logger.Debug("Unknown AMI, probing the username over SSM", "instance", instanceID, "ami", amiName)
+		} else {
+			logger.Debug("Can't get AMI details, probing the username over SSM", "instance", instanceID, "ami", aws.StringValue(instance.ImageId), "error", err)
+		}
 	}
 
-	amiResult, err := ec2Client.DescribeImages(amiInput)
-	if err != nil || len(amiResult.Images) == 0 {
-		return "", fmt.Errorf("failed to retrieve AMI details for %s", amiID)
+	if username := probedUsername(instanceID); username != "" {
+		logger.Debug("Using the username probed before", "instance", instanceID, "user", username)
+		return username, nil
 	}
 
-	amiName := *amiResult.Images[0].Name
+	username, err := probeUsername(instanceID)
+	if err != nil {
+		return "", err
+	}
+	saveProbedUsername(instanceID, username)
+
+	return username, nil
+}
+
+// probeUsername finds the user to SSH as on the instance over SSM
+func probeUsername(instanceID string) (string, error) {
+	results, err := RunCommand([]string{instanceID}, probeUsernameScript, CommandOptions{
+		Comment: "Find the SSH user",
+		Timeout: time.Minute,
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not determine the username of %s (set the atun.io/user tag): %w", instanceID, err)
+	}
 
-	// Determine default username based on AMI name
-	username := detectUsernameFromAMI(amiName)
+	username := strings.TrimSpace(results[0].Stdout)
 	if username == "" {
-		return "", fmt.Errorf("could not determine default username for AMI %s", amiName)
+		return "", fmt.Errorf("no regular user found on %s. Set the atun.io/user tag to the user to SSH as", instanceID)
 	}
+	logger.Debug("Username found over SSM", "instance", instanceID, "user", username)
 
 	return username, nil
 }
 
+// usernameFile keeps the user probed on an instance, so it's not probed over SSM on every run. TunnelDir is per env and profile
+func usernameFile(instanceID string) string {
+	return filepath.Join(config.App.Config.TunnelDir, fmt.Sprintf("%s-user", instanceID))
+}
+
+// probedUsername returns the user probed on the instance before, empty if it wasn't
+func probedUsername(instanceID string) string {
+	if config.App.Config.TunnelDir == "" {
+		return ""
+	}
+
+	data, err := os.ReadFile(usernameFile(instanceID))
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(data))
+}
+
+func saveProbedUsername(instanceID string, username string) {
+	if config.App.Config.TunnelDir == "" {
+		return
+	}
+
+	if err := os.WriteFile(usernameFile(instanceID), []byte(username+"\n"), 0644); err != nil {
+		logger.Debug("Can't save the probed username", "instance", instanceID, "error", err)
+	}
+}
+
 // detectUsernameFromAMI infers the default SSH username based on AMI name.
 func detectUsernameFromAMI(amiName string) string {
 	switch {
@@ -679,6 +844,7 @@ func ConnectToSSMConsole(instanceID string) error {
 	sessionCommand := exec.Command(
 		"aws", "ssm", "start-session",
 		"--target", instanceID,
+		"--region", InstanceRegion(instanceID),
 		"--document-name", "AWS-StartInteractiveCommand",
 		"--parameters", "command=/bin/bash",
 	)
@@ -694,8 +860,17 @@ func ConnectToSSMConsole(instanceID string) error {
 	return nil
 }
 
-// MFAInputRequired checks if MFA is required for the current session
+// MFAInputRequired checks if MFA (or SSO login) is required for the current session
 func MFAInputRequired(app *config.Atun) bool {
+	if SSOLoginRequired(app.Config.AWSProfile) {
+		return true
+	}
+
+	// The code is provided without typing it in
+	if app.Config.AWSMFACode != "" || app.Config.AWSMFACommand != "" {
+		return false
+	}
+
 	mfaUpdateRequired, err := isMFAUpdateRequired(app.Config.AWSMFASharedCredentialsFile, app.Config.AWSProfile)
 	if err != nil {
 		logger.Error("Failed to check MFA requirement", "error", err)
@@ -704,3 +879,27 @@ func MFAInputRequired(app *config.Atun) bool {
 
 	return mfaUpdateRequired
 }
+
+// DescribeInstance returns the EC2 instance with the given ID
+func DescribeInstance(instanceID string) (*ec2.Instance, error) {
+	ctx, cancel := CallContext()
+	defer cancel()
+
+	ec2Client, err := NewEC2Client(instanceConfig(instanceID))
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := ec2Client.DescribeInstancesWithContext(ctx, &ec2.DescribeInstancesInput{
+		InstanceIds: []*string{aws.String(instanceID)},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe instance %s: %w", instanceID, err)
+	}
+
+	if len(result.Reservations) == 0 || len(result.Reservations[0].Instances) == 0 {
+		return nil, fmt.Errorf("no instance found for ID %s", instanceID)
+	}
+
+	return result.Reservations[0].Instances[0], nil
+}
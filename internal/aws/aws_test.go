@@ -0,0 +1,38 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package aws
+
+import (
+	"testing"
+
+	"github.com/DimmKirr/atun/internal/config"
+)
+
+func TestProbedUsername(t *testing.T) {
+	previous := config.App
+	t.Cleanup(func() { config.App = previous })
+
+	config.App = &config.Atun{Config: &config.Config{TunnelDir: t.TempDir()}}
+
+	if got := probedUsername("i-0123456789abcdef0"); got != "" {
+		t.Errorf("probedUsername() = %q before the user was probed", got)
+	}
+
+	saveProbedUsername("i-0123456789abcdef0", "ops")
+	if got := probedUsername("i-0123456789abcdef0"); got != "ops" {
+		t.Errorf("probedUsername() = %q, want %q", got, "ops")
+	}
+	if got := probedUsername("i-0fedcba9876543210"); got != "" {
+		t.Errorf("probedUsername() of another instance = %q", got)
+	}
+
+	// Without a tunnel directory nothing is kept
+	config.App = &config.Atun{Config: &config.Config{}}
+	saveProbedUsername("i-0123456789abcdef0", "ops")
+	if got := probedUsername("i-0123456789abcdef0"); got != "" {
+		t.Errorf("probedUsername() without a tunnel directory = %q", got)
+	}
+}
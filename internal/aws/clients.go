@@ -0,0 +1,155 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package aws
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/DimmKirr/atun/internal/config"
+	"github.com/DimmKirr/atun/internal/logger"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2instanceconnect"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/elasticache"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/opensearchservice"
+	"github.com/aws/aws-sdk-go/service/rds"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// DefaultCallTimeout is used when AWS_CALL_TIMEOUT is not set
+const DefaultCallTimeout = 30 * time.Second
+
+// instanceReadyTimeout is how long a new router has to boot and register in SSM
+const instanceReadyTimeout = 5 * time.Minute
+
+// commandContext is the context of the running command. It's cancelled on Ctrl-C, so no AWS call outlives the command
+var commandContext = context.Background()
+
+// SetContext binds AWS calls to the context of the running command
+func SetContext(ctx context.Context) {
+	commandContext = ctx
+}
+
+// CallContext returns a context for a single AWS call (or a short sequence of them) that times out after AWS_CALL_TIMEOUT
+func CallContext() (context.Context, context.CancelFunc) {
+	timeout := config.App.Config.AWSCallTimeout
+	if timeout <= 0 {
+		timeout = DefaultCallTimeout
+	}
+
+	return context.WithTimeout(commandContext, timeout)
+}
+
+// sleep waits for d, returning early with an error if the command is cancelled
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// clientKey identifies a cached client: the service, account (credentials) and region it talks to
+type clientKey struct {
+	service     string
+	credentials *credentials.Credentials
+	region      string
+	endpoint    string
+}
+
+// clients caches service clients, so a session (shared config parsing, credential resolution) is built once per account and region
+var clients sync.Map
+
+// cachedClient returns the client of a service for awsConfig, creating it on first use
+func cachedClient[T any](service string, awsConfig aws.Config, newClient func(client.ConfigProvider, ...*aws.Config) T) (T, error) {
+	key := clientKey{
+		service:     service,
+		credentials: awsConfig.Credentials,
+		region:      aws.StringValue(awsConfig.Region),
+		endpoint:    aws.StringValue(awsConfig.Endpoint),
+	}
+
+	if cached, ok := clients.Load(key); ok {
+		return cached.(T), nil
+	}
+
+	logger.Debug("Creating AWS client", "service", service, "region", key.region, "endpoint", key.endpoint)
+
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            awsConfig,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		var empty T
+		return empty, err
+	}
+
+	cached, _ := clients.LoadOrStore(key, newClient(sess))
+	return cached.(T), nil
+}
+
+func NewEC2Client(awsConfig aws.Config) (*ec2.EC2, error) {
+	return cachedClient("ec2", awsConfig, ec2.New)
+}
+
+func NewRDSClient(awsConfig aws.Config) (*rds.RDS, error) {
+	return cachedClient("rds", awsConfig, rds.New)
+}
+
+func NewSTSClient(awsConfig aws.Config) (*sts.STS, error) {
+	return cachedClient("sts", awsConfig, sts.New)
+}
+
+func NewSSMClient(awsConfig aws.Config) (*ssm.SSM, error) {
+	return cachedClient("ssm", awsConfig, ssm.New)
+}
+
+func NewIAMClient(awsConfig aws.Config) (*iam.IAM, error) {
+	return cachedClient("iam", awsConfig, iam.New)
+}
+
+func NewECSClient(awsConfig aws.Config) (*ecs.ECS, error) {
+	return cachedClient("ecs", awsConfig, ecs.New)
+}
+
+func NewElastiCacheClient(awsConfig aws.Config) (*elasticache.ElastiCache, error) {
+	return cachedClient("elasticache", awsConfig, elasticache.New)
+}
+
+func NewOpenSearchClient(awsConfig aws.Config) (*opensearchservice.OpenSearchService, error) {
+	return cachedClient("opensearch", awsConfig, opensearchservice.New)
+}
+
+func NewEC2InstanceConnectClient(awsConfig aws.Config) (*ec2instanceconnect.EC2InstanceConnect, error) {
+	return cachedClient("ec2-instance-connect", awsConfig, ec2instanceconnect.New)
+}
+
+func NewS3Client(awsConfig aws.Config) (*s3.S3, error) {
+	return cachedClient("s3", awsConfig, s3.New)
+}
+
+func NewCloudWatchLogsClient(awsConfig aws.Config) (*cloudwatchlogs.CloudWatchLogs, error) {
+	return cachedClient("logs", awsConfig, cloudwatchlogs.New)
+}
+
+func NewSecretsManagerClient(awsConfig aws.Config) (*secretsmanager.SecretsManager, error) {
+	return cachedClient("secretsmanager", awsConfig, secretsmanager.New)
+}
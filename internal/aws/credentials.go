@@ -0,0 +1,50 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package aws
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/DimmKirr/atun/internal/config"
+	"github.com/DimmKirr/atun/internal/logger"
+)
+
+// Credentials are credentials of the atun AWS session (MFA or SSO included) in the form other tools consume them
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// Expiration is zero for credentials that don't expire (e.g. access keys of a user without MFA)
+	Expiration time.Time
+}
+
+// GetCredentials authenticates app (refreshing MFA and SSO sessions as needed) and returns its credentials
+func GetCredentials(app *config.Atun) (Credentials, error) {
+	InitAWSClients(app)
+
+	value, err := app.Session.Config.Credentials.Get()
+	if err != nil {
+		return Credentials{}, fmt.Errorf("can't get credentials of profile %s: %w", app.Config.AWSProfile, err)
+	}
+
+	credentials := Credentials{
+		AccessKeyID:     value.AccessKeyID,
+		SecretAccessKey: value.SecretAccessKey,
+		SessionToken:    value.SessionToken,
+	}
+
+	// SSO and assumed role providers know when credentials expire. MFA session credentials come from a file with the expiration next to them
+	if expiration, err := app.Session.Config.Credentials.ExpiresAt(); err == nil {
+		credentials.Expiration = expiration
+	} else if expiration, err := mfaTokenExpiration(app.Config.AWSMFASharedCredentialsFile, app.Config.AWSProfile); err == nil && value.SessionToken != "" {
+		credentials.Expiration = expiration
+	} else {
+		logger.Debug("Credentials don't expire", "profile", app.Config.AWSProfile)
+	}
+
+	return credentials, nil
+}
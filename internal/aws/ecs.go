@@ -0,0 +1,450 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package aws
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DimmKirr/atun/internal/config"
+	"github.com/DimmKirr/atun/internal/logger"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// ECSTask is a running ECS task that acts as a router (a container running sshd with ECS Exec enabled)
+type ECSTask struct {
+	Cluster     string
+	ID          string
+	Container   string
+	RuntimeID   string
+	Service     string
+	State       string
+	ExecEnabled bool
+	CreatedAt   time.Time
+	Tags        map[string]string
+}
+
+// RouterID returns the router ID of the task (<cluster>_<task id>_<container runtime id>).
+// It matches the SSM target of the container without the ecs: prefix, so it's safe to use in file names and as an SSH host alias.
+func (t ECSTask) RouterID() string {
+	return fmt.Sprintf("%s_%s_%s", t.Cluster, t.ID, t.RuntimeID)
+}
+
+// ParseECSRouterID splits the router ID into cluster, task ID and container runtime ID.
+// Cluster names may contain underscores, task and runtime IDs can't, so the ID is split from the right.
+func ParseECSRouterID(routerID string) (string, string, string, error) {
+	runtimeSep := strings.LastIndex(routerID, "_")
+	if runtimeSep <= 0 {
+		return "", "", "", fmt.Errorf("invalid ecs router id %s (expected <cluster>_<task id>_<runtime id>)", routerID)
+	}
+
+	taskSep := strings.LastIndex(routerID[:runtimeSep], "_")
+	if taskSep <= 0 {
+		return "", "", "", fmt.Errorf("invalid ecs router id %s (expected <cluster>_<task id>_<runtime id>)", routerID)
+	}
+
+	return routerID[:taskSep], routerID[taskSep+1 : runtimeSep], routerID[runtimeSep+1:], nil
+}
+
+// ECSTarget returns the SSM target of the router container (ecs:<cluster>_<task id>_<runtime id>)
+func ECSTarget(routerID string) string {
+	return fmt.Sprintf("ecs:%s", routerID)
+}
+
+// ListECSTasksWithTags returns running ECS tasks that have all the tags.
+// Tags are merged from the task definition, the service and the task itself (in that order of precedence), so a router can be tagged on any level.
+func ListECSTasksWithTags(tags map[string][]string) ([]ECSTask, error) {
+	ctx, cancel := CallContext()
+	defer cancel()
+
+	if len(tags) == 0 {
+		return nil, fmt.Errorf("no tags provided for filtering")
+	}
+
+	ecsClient, err := NewECSClient(*config.App.Session.Config)
+	if err != nil {
+		logger.Error("Failed to create ECS client", "error", err)
+		return nil, err
+	}
+
+	clusters, err := listECSClusters(ecsClient)
+	if err != nil {
+		return nil, err
+	}
+
+	// Task definitions and services are shared between tasks, so their tags are fetched once
+	taskDefinitionTags := map[string]map[string]string{}
+	serviceTags := map[string]map[string]string{}
+
+	var tasks []ECSTask
+	for _, cluster := range clusters {
+		var taskArns []*string
+		err = ecsClient.ListTasksPagesWithContext(ctx, &ecs.ListTasksInput{
+			Cluster:       aws.String(cluster),
+			DesiredStatus: aws.String(ecs.DesiredStatusRunning),
+		}, func(page *ecs.ListTasksOutput, lastPage bool) bool {
+			taskArns = append(taskArns, page.TaskArns...)
+			return !lastPage
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list tasks of cluster %s: %w", cluster, err)
+		}
+
+		// DescribeTasks accepts up to 100 tasks per call
+		for start := 0; start < len(taskArns); start += 100 {
+			end := min(start+100, len(taskArns))
+
+			output, err := ecsClient.DescribeTasksWithContext(ctx, &ecs.DescribeTasksInput{
+				Cluster: aws.String(cluster),
+				Tasks:   taskArns[start:end],
+				Include: []*string{aws.String(ecs.TaskFieldTags)},
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to describe tasks of cluster %s: %w", cluster, err)
+			}
+
+			for _, t := range output.Tasks {
+				task := newECSTask(cluster, t)
+
+				if _, ok := taskDefinitionTags[aws.StringValue(t.TaskDefinitionArn)]; !ok {
+					taskDefinitionTags[aws.StringValue(t.TaskDefinitionArn)] = getECSTaskDefinitionTags(ecsClient, aws.StringValue(t.TaskDefinitionArn))
+				}
+
+				serviceKey := fmt.Sprintf("%s/%s", cluster, task.Service)
+				if _, ok := serviceTags[serviceKey]; !ok && task.Service != "" {
+					serviceTags[serviceKey] = getECSServiceTags(ecsClient, cluster, task.Service)
+				}
+
+				task.Tags = mergeTags(taskDefinitionTags[aws.StringValue(t.TaskDefinitionArn)], serviceTags[serviceKey], ecsTagsToMap(t.Tags))
+
+				if !hasTags(task.Tags, tags) {
+					continue
+				}
+
+				if task.RuntimeID == "" {
+					logger.Debug("Task matches the tags but its container has no runtime ID yet. Skipping", "cluster", cluster, "task", task.ID)
+					continue
+				}
+
+				tasks = append(tasks, task)
+			}
+		}
+	}
+
+	logger.Debug(fmt.Sprintf("Found %d ECS tasks with matching tags", len(tasks)))
+	return tasks, nil
+}
+
+// GetECSTask describes the task of the router ID
+func GetECSTask(routerID string) (ECSTask, error) {
+	ctx, cancel := CallContext()
+	defer cancel()
+
+	cluster, taskID, runtimeID, err := ParseECSRouterID(routerID)
+	if err != nil {
+		return ECSTask{}, err
+	}
+
+	ecsClient, err := NewECSClient(*config.App.Session.Config)
+	if err != nil {
+		return ECSTask{}, err
+	}
+
+	output, err := ecsClient.DescribeTasksWithContext(ctx, &ecs.DescribeTasksInput{
+		Cluster: aws.String(cluster),
+		Tasks:   []*string{aws.String(taskID)},
+		Include: []*string{aws.String(ecs.TaskFieldTags)},
+	})
+	if err != nil {
+		return ECSTask{}, fmt.Errorf("failed to describe task %s: %w", taskID, err)
+	}
+
+	if len(output.Tasks) == 0 {
+		return ECSTask{}, fmt.Errorf("no task %s found in cluster %s", taskID, cluster)
+	}
+
+	t := output.Tasks[0]
+	task := newECSTask(cluster, t)
+
+	// Pin the container of the router ID (the task may run sidecars)
+	for _, c := range t.Containers {
+		if aws.StringValue(c.RuntimeId) == runtimeID {
+			task.Container = aws.StringValue(c.Name)
+			task.RuntimeID = runtimeID
+		}
+	}
+
+	serviceTags := map[string]string{}
+	if task.Service != "" {
+		serviceTags = getECSServiceTags(ecsClient, cluster, task.Service)
+	}
+	task.Tags = mergeTags(getECSTaskDefinitionTags(ecsClient, aws.StringValue(t.TaskDefinitionArn)), serviceTags, ecsTagsToMap(t.Tags))
+
+	return task, nil
+}
+
+// WaitForECSTaskReady waits until a task with the tags is running and its ECS Exec agent accepts connections
+func WaitForECSTaskReady(tags map[string][]string) (string, error) {
+	timeout := time.After(5 * time.Minute)
+	tick := time.NewTicker(10 * time.Second)
+	defer tick.Stop()
+
+	for {
+		tasks, err := ListECSTasksWithTags(tags)
+		if err != nil {
+			return "", err
+		}
+
+		for _, task := range tasks {
+			if task.ExecEnabled && task.State == ecs.DesiredStatusRunning {
+				return task.RouterID(), nil
+			}
+			logger.Debug("Waiting for ECS task to be ready", "task", task.ID, "state", task.State, "execEnabled", task.ExecEnabled)
+		}
+
+		select {
+		case <-timeout:
+			return "", fmt.Errorf("timeout waiting for ECS task to be ready")
+		case <-tick.C:
+		}
+	}
+}
+
+// ecsExecBeginMarker and ecsExecEndMarker cut the command output out of the ECS Exec session. The exit status of the command follows the end marker
+const ecsExecBeginMarker, ecsExecEndMarker = "__ATUN_BEGIN__", "__ATUN_END__:"
+
+// ExecECSCommand runs a shell command in the router container via ECS Exec and returns its output.
+// ECS Exec has no non-interactive mode and always exits 0, so the output and the exit status are cut out of the session between markers.
+// The command is wrapped in sh -c '...', so it can't have single quotes
+func ExecECSCommand(routerID string, command string) (string, error) {
+	task, err := GetECSTask(routerID)
+	if err != nil {
+		return "", err
+	}
+
+	wrappedCommand := fmt.Sprintf("sh -c 'echo %s; %s; echo %s$?'", ecsExecBeginMarker, command, ecsExecEndMarker)
+	logger.Debug("Executing ECS command", "cluster", task.Cluster, "task", task.ID, "container", task.Container, "command", wrappedCommand)
+
+	cmd := exec.Command(
+		"aws", "ecs", "execute-command",
+		"--cluster", task.Cluster,
+		"--task", task.ID,
+		"--container", task.Container,
+		"--interactive",
+		"--command", wrappedCommand,
+	)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("failed to execute command in %s: %w: %s", routerID, err, strings.TrimSpace(string(output)))
+	}
+
+	commandOutput, status, err := parseECSExecOutput(string(output))
+	if err != nil {
+		return "", fmt.Errorf("command in %s %w", routerID, err)
+	}
+	if status != 0 {
+		return commandOutput, fmt.Errorf("command in %s exited with status %d: %s", routerID, status, commandOutput)
+	}
+
+	return commandOutput, nil
+}
+
+// parseECSExecOutput returns the output and the exit status of the command from the output of the ECS Exec session
+func parseECSExecOutput(output string) (string, int, error) {
+	begin := strings.Index(output, ecsExecBeginMarker)
+	end := strings.LastIndex(output, ecsExecEndMarker)
+	if begin == -1 || end == -1 || end < begin {
+		return "", 0, fmt.Errorf("didn't complete: %s", strings.TrimSpace(output))
+	}
+
+	statusField := strings.Fields(output[end+len(ecsExecEndMarker):])
+	if len(statusField) == 0 {
+		return "", 0, fmt.Errorf("didn't report its exit status: %s", strings.TrimSpace(output))
+	}
+
+	status, err := strconv.Atoi(statusField[0])
+	if err != nil {
+		return "", 0, fmt.Errorf("reported an invalid exit status %q", statusField[0])
+	}
+
+	return strings.TrimSpace(output[begin+len(ecsExecBeginMarker) : end]), status, nil
+}
+
+// GetECSWhoAmI returns the user the router container runs as
+func GetECSWhoAmI(routerID string) (string, error) {
+	return ExecECSCommand(routerID, "whoami")
+}
+
+// EnsureECSSSHPublicKeyPresent authorizes the public key for the user sshd of the router container runs as
+func EnsureECSSSHPublicKeyPresent(routerID string, publicKey string) error {
+	key := strings.TrimSpace(publicKey)
+
+	_, err := ExecECSCommand(routerID, fmt.Sprintf(
+		`mkdir -p ~/.ssh && chmod 700 ~/.ssh && (grep -qF "%s" ~/.ssh/authorized_keys 2>/dev/null || echo "%s" >> ~/.ssh/authorized_keys) && chmod 600 ~/.ssh/authorized_keys`,
+		key,
+		key,
+	))
+
+	return err
+}
+
+// ConnectToECSShell opens an interactive shell in the router container via ECS Exec
+func ConnectToECSShell(routerID string) error {
+	task, err := GetECSTask(routerID)
+	if err != nil {
+		return err
+	}
+
+	sessionCommand := exec.Command(
+		"aws", "ecs", "execute-command",
+		"--cluster", task.Cluster,
+		"--task", task.ID,
+		"--container", task.Container,
+		"--interactive",
+		"--command", "/bin/sh",
+	)
+
+	sessionCommand.Stdout = os.Stdout
+	sessionCommand.Stderr = os.Stderr
+	sessionCommand.Stdin = os.Stdin
+
+	if err := sessionCommand.Run(); err != nil {
+		return fmt.Errorf("failed to start ECS Exec session: %w", err)
+	}
+
+	return nil
+}
+
+// listECSClusters returns the configured cluster or all clusters of the account
+func listECSClusters(ecsClient *ecs.ECS) ([]string, error) {
+	ctx, cancel := CallContext()
+	defer cancel()
+
+	if config.App.Config.ECSCluster != "" {
+		return []string{config.App.Config.ECSCluster}, nil
+	}
+
+	var clusters []string
+	err := ecsClient.ListClustersPagesWithContext(ctx, &ecs.ListClustersInput{}, func(page *ecs.ListClustersOutput, lastPage bool) bool {
+		clusters = append(clusters, aws.StringValueSlice(page.ClusterArns)...)
+		return !lastPage
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ECS clusters: %w", err)
+	}
+
+	// Use cluster names instead of ARNs since they are a part of the router ID
+	for i, cluster := range clusters {
+		clusters[i] = cluster[strings.LastIndex(cluster, "/")+1:]
+	}
+
+	return clusters, nil
+}
+
+func newECSTask(cluster string, t *ecs.Task) ECSTask {
+	task := ECSTask{
+		Cluster:   cluster,
+		ID:        aws.StringValue(t.TaskArn)[strings.LastIndex(aws.StringValue(t.TaskArn), "/")+1:],
+		State:     aws.StringValue(t.LastStatus),
+		CreatedAt: aws.TimeValue(t.CreatedAt),
+	}
+
+	// Tasks started by a service have a service:<name> group
+	if group := aws.StringValue(t.Group); strings.HasPrefix(group, "service:") {
+		task.Service = strings.TrimPrefix(group, "service:")
+	}
+
+	// Use the first container with a running ECS Exec agent
+	for _, c := range t.Containers {
+		if task.RuntimeID == "" {
+			task.Container = aws.StringValue(c.Name)
+			task.RuntimeID = aws.StringValue(c.RuntimeId)
+		}
+
+		for _, agent := range c.ManagedAgents {
+			if aws.StringValue(agent.Name) == ecs.ManagedAgentNameExecuteCommandAgent && aws.StringValue(agent.LastStatus) == ecs.DesiredStatusRunning {
+				task.Container = aws.StringValue(c.Name)
+				task.RuntimeID = aws.StringValue(c.RuntimeId)
+				task.ExecEnabled = aws.BoolValue(t.EnableExecuteCommand)
+				return task
+			}
+		}
+	}
+
+	return task
+}
+
+func getECSTaskDefinitionTags(ecsClient *ecs.ECS, taskDefinitionArn string) map[string]string {
+	ctx, cancel := CallContext()
+	defer cancel()
+
+	output, err := ecsClient.DescribeTaskDefinitionWithContext(ctx, &ecs.DescribeTaskDefinitionInput{
+		TaskDefinition: aws.String(taskDefinitionArn),
+		Include:        []*string{aws.String(ecs.TaskDefinitionFieldTags)},
+	})
+	if err != nil {
+		logger.Debug("Failed to describe task definition", "taskDefinition", taskDefinitionArn, "error", err)
+		return map[string]string{}
+	}
+
+	return ecsTagsToMap(output.Tags)
+}
+
+func getECSServiceTags(ecsClient *ecs.ECS, cluster string, service string) map[string]string {
+	ctx, cancel := CallContext()
+	defer cancel()
+
+	output, err := ecsClient.DescribeServicesWithContext(ctx, &ecs.DescribeServicesInput{
+		Cluster:  aws.String(cluster),
+		Services: []*string{aws.String(service)},
+		Include:  []*string{aws.String(ecs.ServiceFieldTags)},
+	})
+	if err != nil || len(output.Services) == 0 {
+		logger.Debug("Failed to describe service", "cluster", cluster, "service", service, "error", err)
+		return map[string]string{}
+	}
+
+	return ecsTagsToMap(output.Services[0].Tags)
+}
+
+func ecsTagsToMap(tags []*ecs.Tag) map[string]string {
+	result := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		result[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+
+	return result
+}
+
+// mergeTags merges tag maps, later maps take precedence
+func mergeTags(tagMaps ...map[string]string) map[string]string {
+	result := map[string]string{}
+	for _, tags := range tagMaps {
+		for key, value := range tags {
+			result[key] = value
+		}
+	}
+
+	return result
+}
+
+// hasTags checks that all required tags are present with one of the accepted values
+func hasTags(tags map[string]string, required map[string][]string) bool {
+	for key, values := range required {
+		if !slices.Contains(values, tags[key]) {
+			return false
+		}
+	}
+
+	return true
+}
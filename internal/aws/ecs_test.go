@@ -0,0 +1,73 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package aws
+
+import "testing"
+
+func TestParseECSExecOutput(t *testing.T) {
+	tests := []struct {
+		name       string
+		output     string
+		wantOutput string
+		wantStatus int
+		wantErr    bool
+	}{
+		{
+			name:       "success",
+			output:     "\r\nThe Session Manager plugin was installed successfully.\r\nStarting session with SessionId: ecs-execute-command-0123\r\n__ATUN_BEGIN__\r\nroot\r\n__ATUN_END__:0\r\n\r\nExiting session with sessionId: ecs-execute-command-0123.\r\n",
+			wantOutput: "root",
+		},
+		{
+			name:       "failed command",
+			output:     "Starting session\r\n__ATUN_BEGIN__\r\nmkdir: can't create directory '/root/.ssh': Read-only file system\r\n__ATUN_END__:1\r\nExiting session\r\n",
+			wantOutput: "mkdir: can't create directory '/root/.ssh': Read-only file system",
+			wantStatus: 1,
+		},
+		{
+			name:       "no output",
+			output:     "__ATUN_BEGIN__\n__ATUN_END__:0\n",
+			wantOutput: "",
+		},
+		{
+			name:    "session dropped before the end",
+			output:  "Starting session\r\n__ATUN_BEGIN__\r\npartial output",
+			wantErr: true,
+		},
+		{
+			name:    "no exit status",
+			output:  "__ATUN_BEGIN__\nroot\n__ATUN_END__:",
+			wantErr: true,
+		},
+		{
+			name:    "invalid exit status",
+			output:  "__ATUN_BEGIN__\nroot\n__ATUN_END__:x\n",
+			wantErr: true,
+		},
+		{
+			name:    "no markers",
+			output:  "An error occurred (TargetNotConnectedException)",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, status, err := parseECSExecOutput(tt.output)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseECSExecOutput() = %q, %d, want an error", output, status)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseECSExecOutput() returned an error: %v", err)
+			}
+			if output != tt.wantOutput || status != tt.wantStatus {
+				t.Errorf("parseECSExecOutput() = %q, %d, want %q, %d", output, status, tt.wantOutput, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package aws
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/DimmKirr/atun/internal/logger"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2instanceconnect"
+)
+
+// Methods of authorizing the local SSH key on EC2 routers
+const (
+	KeyPushEIC = "eic" // ec2-instance-connect:SendSSHPublicKey, valid for 60 seconds
+	KeyPushSSM = "ssm" // appended to authorized_keys via SSM Run Command
+)
+
+// SendSSHPublicKey pushes the public key to the instance metadata with EC2 Instance Connect.
+// The key is accepted by sshd of the instance for 60 seconds and is never written to disk, so it has to be pushed right before connecting.
+func SendSSHPublicKey(instanceID string, routerHostUser string, publicKey string) error {
+	ctx, cancel := CallContext()
+	defer cancel()
+
+	eicClient, err := NewEC2InstanceConnectClient(instanceConfig(instanceID))
+	if err != nil {
+		return err
+	}
+
+	output, err := eicClient.SendSSHPublicKeyWithContext(ctx, &ec2instanceconnect.SendSSHPublicKeyInput{
+		InstanceId:     aws.String(instanceID),
+		InstanceOSUser: aws.String(routerHostUser),
+		SSHPublicKey:   aws.String(strings.TrimSpace(publicKey)),
+	})
+	if err != nil {
+		return fmt.Errorf("can't send SSH public key via EC2 Instance Connect: %w", err)
+	}
+
+	if !aws.BoolValue(output.Success) {
+		return fmt.Errorf("EC2 Instance Connect didn't accept SSH public key (request %s)", aws.StringValue(output.RequestId))
+	}
+
+	logger.Debug("SSH public key sent via EC2 Instance Connect", "instanceID", instanceID, "user", routerHostUser, "requestID", aws.StringValue(output.RequestId))
+	return nil
+}
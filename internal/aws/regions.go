@@ -0,0 +1,204 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package aws
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/DimmKirr/atun/internal/config"
+	"github.com/DimmKirr/atun/internal/logger"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// instanceLocations keeps the account and region each discovered instance was found in, so calls about the instance go there
+var instanceLocations sync.Map
+
+// instanceLocation is where a discovered instance is
+type instanceLocation struct {
+	Target Target
+	Region string
+}
+
+// DiscoveryRegions returns regions routers are searched in: all enabled regions with --all-regions,
+// the configured aws_regions list if set, the session region otherwise
+func DiscoveryRegions() ([]string, error) {
+	if config.App.Config.AllRegions {
+		return listEnabledRegions()
+	}
+
+	if len(config.App.Config.AWSRegions) > 0 {
+		return config.App.Config.AWSRegions, nil
+	}
+
+	return []string{config.App.Config.AWSRegion}, nil
+}
+
+// InstanceRegion returns the region an instance was discovered in. Instances that weren't discovered (e.g. --router) are in the session region
+func InstanceRegion(instanceID string) string {
+	if location, ok := instanceLocations.Load(instanceID); ok {
+		return location.(instanceLocation).Region
+	}
+
+	return config.App.Config.AWSRegion
+}
+
+// InstanceAccount returns the alias (or ID) of the account an instance was discovered in, empty if it wasn't discovered
+func InstanceAccount(instanceID string) string {
+	if location, ok := instanceLocations.Load(instanceID); ok {
+		return location.(instanceLocation).Target.Account
+	}
+
+	return ""
+}
+
+// UseRouterSession switches the session of app to the account and region of the router,
+// so the tunnel (and aws CLI it runs) talks to the account and region the router is in
+func UseRouterSession(app *config.Atun, instanceID string) error {
+	location, ok := instanceLocations.Load(instanceID)
+	if !ok {
+		return nil
+	}
+
+	target := location.(instanceLocation).Target
+	region := location.(instanceLocation).Region
+
+	logger.Debug("Using AWS account and region of the router", "router", instanceID, "account", target.Account, "region", region)
+	app.Config.AWSRegion = region
+	app.Session = instanceSession(instanceID)
+
+	if !target.Assumed {
+		unexportCredentials()
+		return nil
+	}
+
+	return exportCredentials(target.Session)
+}
+
+// instanceSession returns a session for the account and region of the instance
+func instanceSession(instanceID string) *session.Session {
+	if location, ok := instanceLocations.Load(instanceID); ok {
+		return location.(instanceLocation).Target.Session.Copy(&aws.Config{Region: aws.String(location.(instanceLocation).Region)})
+	}
+
+	return config.App.Session
+}
+
+// instanceConfig returns the session config for the account and region of the instance
+func instanceConfig(instanceID string) aws.Config {
+	return *instanceSession(instanceID).Config
+}
+
+// listInstances runs DescribeInstances with filters in all regions of all targets concurrently.
+// A region that fails is skipped with a warning unless all of them fail.
+func listInstances(targets []Target, regions []string, filters []*ec2.Filter) ([]*ec2.Instance, error) {
+	ctx, cancel := CallContext()
+	defer cancel()
+
+	type locationResult struct {
+		location  instanceLocation
+		instances []*ec2.Instance
+		err       error
+	}
+
+	var results []*locationResult
+	for _, target := range targets {
+		for _, region := range regions {
+			results = append(results, &locationResult{location: instanceLocation{Target: target, Region: region}})
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, result := range results {
+		wg.Add(1)
+		go func(result *locationResult) {
+			defer wg.Done()
+
+			ec2Client, err := NewEC2Client(*result.location.Target.Session.Config.Copy(&aws.Config{Region: aws.String(result.location.Region)}))
+			if err != nil {
+				result.err = err
+				return
+			}
+
+			result.err = ec2Client.DescribeInstancesPagesWithContext(ctx, &ec2.DescribeInstancesInput{Filters: filters}, func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+				for _, reservation := range page.Reservations {
+					result.instances = append(result.instances, reservation.Instances...)
+				}
+				return !lastPage
+			})
+		}(result)
+	}
+	wg.Wait()
+
+	var instances []*ec2.Instance
+	var failed int
+	var lastErr error
+	for _, result := range results {
+		if result.err != nil {
+			logger.Warn("Failed to describe instances", "account", result.location.Target.Account, "region", result.location.Region, "error", result.err)
+			failed++
+			lastErr = result.err
+			continue
+		}
+
+		for _, instance := range result.instances {
+			instanceLocations.Store(aws.StringValue(instance.InstanceId), result.location)
+		}
+		instances = append(instances, result.instances...)
+	}
+
+	if failed == len(results) {
+		return nil, fmt.Errorf("failed to describe instances in %d account region(s): %w", failed, lastErr)
+	}
+
+	return instances, nil
+}
+
+// enabledRegions caches regions enabled in the account of the profile session, so --all-regions lists them once per session like DiscoveryTargets
+var enabledRegions struct {
+	sync.Mutex
+	base    *session.Session
+	regions []string
+}
+
+// listEnabledRegions returns regions enabled in the account (opt-in regions that aren't enabled are skipped)
+func listEnabledRegions() ([]string, error) {
+	base := baseSession()
+
+	enabledRegions.Lock()
+	defer enabledRegions.Unlock()
+
+	if enabledRegions.base == base && enabledRegions.regions != nil {
+		return enabledRegions.regions, nil
+	}
+
+	ctx, cancel := CallContext()
+	defer cancel()
+
+	ec2Client, err := NewEC2Client(*base.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := ec2Client.DescribeRegionsWithContext(ctx, &ec2.DescribeRegionsInput{})
+	if err != nil {
+		return nil, fmt.Errorf("error listing regions: %w", err)
+	}
+
+	var regions []string
+	for _, region := range result.Regions {
+		regions = append(regions, aws.StringValue(region.RegionName))
+	}
+	sort.Strings(regions)
+
+	enabledRegions.base = base
+	enabledRegions.regions = regions
+
+	return regions, nil
+}
@@ -0,0 +1,110 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package aws
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync/atomic"
+	"testing"
+
+	"github.com/DimmKirr/atun/internal/config"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// describeRegionsResponse is what EC2 returns for DescribeRegions (opt-in regions that aren't enabled are left out)
+const describeRegionsResponse = `<?xml version="1.0" encoding="UTF-8"?>
+<DescribeRegionsResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/">
+  <requestId>59dbff89-35bd-4eac-99ed-be587EXAMPLE</requestId>
+  <regionInfo>
+    <item><regionName>us-east-1</regionName><regionEndpoint>ec2.us-east-1.amazonaws.com</regionEndpoint></item>
+    <item><regionName>eu-west-1</regionName><regionEndpoint>ec2.eu-west-1.amazonaws.com</regionEndpoint></item>
+    <item><regionName>ap-south-1</regionName><regionEndpoint>ec2.ap-south-1.amazonaws.com</regionEndpoint></item>
+  </regionInfo>
+</DescribeRegionsResponse>`
+
+func TestDiscoveryRegions(t *testing.T) {
+	var describeRegionsCalls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		describeRegionsCalls.Add(1)
+		if err := r.ParseForm(); err != nil || r.Form.Get("Action") != "DescribeRegions" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/xml")
+		_, _ = w.Write([]byte(describeRegionsResponse))
+	}))
+	t.Cleanup(server.Close)
+
+	sess, err := session.NewSession(&aws.Config{
+		Region:      aws.String("eu-central-1"),
+		Endpoint:    aws.String(server.URL),
+		Credentials: credentials.NewStaticCredentials("AKIDEXAMPLE", "secret", ""),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previous := config.App
+	t.Cleanup(func() { config.App = previous })
+
+	tests := []struct {
+		name       string
+		allRegions bool
+		regions    []string
+		want       []string
+	}{
+		{
+			name: "session region",
+			want: []string{"eu-central-1"},
+		},
+		{
+			name:    "configured regions",
+			regions: []string{"us-east-1", "eu-west-1"},
+			want:    []string{"us-east-1", "eu-west-1"},
+		},
+		{
+			name:       "all enabled regions, sorted",
+			allRegions: true,
+			want:       []string{"ap-south-1", "eu-west-1", "us-east-1"},
+		},
+		{
+			name:       "all regions take precedence over configured ones",
+			allRegions: true,
+			regions:    []string{"us-east-1"},
+			want:       []string{"ap-south-1", "eu-west-1", "us-east-1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.App = &config.Atun{
+				Session: sess,
+				Config: &config.Config{
+					AWSRegion:  "eu-central-1",
+					AWSRegions: tt.regions,
+					AllRegions: tt.allRegions,
+				},
+			}
+
+			got, err := DiscoveryRegions()
+			if err != nil {
+				t.Fatalf("DiscoveryRegions() returned an error: %v", err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("DiscoveryRegions() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// Enabled regions are listed once per profile session
+	if calls := describeRegionsCalls.Load(); calls != 1 {
+		t.Errorf("DescribeRegions was called %d times, want 1", calls)
+	}
+}
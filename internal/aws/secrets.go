@@ -0,0 +1,70 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package aws
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// Prefixes of secret references, e.g. ssm:/atun/ssh-ca or secretsmanager:atun/ssh-ca
+const (
+	secretPrefixSSM            = "ssm:"
+	secretPrefixSecretsManager = "secretsmanager:"
+)
+
+// IsSecretRef reports whether the value refers to an SSM parameter or a Secrets Manager secret rather than a local file
+func IsSecretRef(ref string) bool {
+	return strings.HasPrefix(ref, secretPrefixSSM) || strings.HasPrefix(ref, secretPrefixSecretsManager)
+}
+
+// GetSecret reads a SecureString SSM parameter (ssm:<name>) or a Secrets Manager secret (secretsmanager:<name or ARN>) of the profile account
+func GetSecret(ref string) ([]byte, error) {
+	ctx, cancel := CallContext()
+	defer cancel()
+
+	switch {
+	case strings.HasPrefix(ref, secretPrefixSSM):
+		ssmClient, err := NewSSMClient(*baseSession().Config)
+		if err != nil {
+			return nil, err
+		}
+
+		output, err := ssmClient.GetParameterWithContext(ctx, &ssm.GetParameterInput{
+			Name:           aws.String(strings.TrimPrefix(ref, secretPrefixSSM)),
+			WithDecryption: aws.Bool(true),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("can't read SSM parameter %s: %w", ref, err)
+		}
+
+		return []byte(aws.StringValue(output.Parameter.Value)), nil
+	case strings.HasPrefix(ref, secretPrefixSecretsManager):
+		secretsClient, err := NewSecretsManagerClient(*baseSession().Config)
+		if err != nil {
+			return nil, err
+		}
+
+		output, err := secretsClient.GetSecretValueWithContext(ctx, &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(strings.TrimPrefix(ref, secretPrefixSecretsManager)),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("can't read secret %s: %w", ref, err)
+		}
+
+		if output.SecretString != nil {
+			return []byte(aws.StringValue(output.SecretString)), nil
+		}
+
+		return output.SecretBinary, nil
+	}
+
+	return nil, fmt.Errorf("unknown secret reference %s (supported: %s<parameter>, %s<secret>)", ref, secretPrefixSSM, secretPrefixSecretsManager)
+}
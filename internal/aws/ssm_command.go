@@ -0,0 +1,548 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package aws
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DimmKirr/atun/internal/config"
+	"github.com/DimmKirr/atun/internal/logger"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// commandPollInterval is how often the command invocation (and its CloudWatch output) is checked
+const commandPollInterval = 2 * time.Second
+
+// commandReportMargin is extra time for SSM to report the invocation after the execution timeout, so it's reported as timed out rather than cancelled locally
+const commandReportMargin = 30 * time.Second
+
+// ssmMaxCommandTargets is the limit of instance IDs in a single SendCommand
+const ssmMaxCommandTargets = 50
+
+// CommandOptions configures RunCommand. Zero values fall back to ssm_* settings of atun.toml
+type CommandOptions struct {
+	Comment string
+	// Timeout limits delivery and execution of the command
+	Timeout time.Duration
+	// OutputS3Bucket keeps the full output in S3. Without it the output is truncated by SSM to 24000 characters
+	OutputS3Bucket string
+	OutputS3Prefix string
+	// OutputLogGroup sends the output to CloudWatch Logs, so it's streamed while the command runs.
+	// Without it the output is written once the command finishes: SSM returns none for invocations in progress
+	OutputLogGroup string
+	// Stdout and Stderr receive the output as it arrives. Lines are prefixed with the instance ID when the command runs on several instances
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// CommandResult is the outcome of a command on one instance
+type CommandResult struct {
+	InstanceID string
+	Status     string
+	ExitCode   int
+	Stdout     string
+	Stderr     string
+	Err        error
+}
+
+// CommandError describes a command invocation that didn't succeed
+type CommandError struct {
+	InstanceID    string
+	Status        string
+	StatusDetails string
+	ExitCode      int
+	Stderr        string
+}
+
+func (e *CommandError) Error() string {
+	switch e.StatusDetails {
+	case "Undeliverable":
+		return fmt.Sprintf("command can't be delivered to %s: the instance is stopped or its SSM agent is offline", e.InstanceID)
+	case "DeliveryTimedOut":
+		return fmt.Sprintf("command wasn't delivered to %s in time: the SSM agent is offline", e.InstanceID)
+	case "ExecutionTimedOut":
+		return fmt.Sprintf("command timed out on %s", e.InstanceID)
+	case "InvalidPlatform":
+		return fmt.Sprintf("command can't run on %s: the platform doesn't support shell scripts", e.InstanceID)
+	case "AccessDenied":
+		return fmt.Sprintf("command can't run on %s: access denied", e.InstanceID)
+	case "Terminated":
+		return fmt.Sprintf("command was terminated on %s", e.InstanceID)
+	}
+
+	switch e.Status {
+	case ssm.CommandInvocationStatusCancelled, ssm.CommandInvocationStatusCancelling:
+		return fmt.Sprintf("command was cancelled on %s", e.InstanceID)
+	case ssm.CommandInvocationStatusTimedOut:
+		return fmt.Sprintf("command timed out on %s", e.InstanceID)
+	}
+
+	if stderr := strings.TrimSpace(e.Stderr); stderr != "" {
+		return fmt.Sprintf("command failed on %s with exit code %d: %s", e.InstanceID, e.ExitCode, stderr)
+	}
+
+	return fmt.Sprintf("command failed on %s with exit code %d", e.InstanceID, e.ExitCode)
+}
+
+// RunCommand runs a shell command on instances with SSM Run Command and waits for it to finish.
+// Results are in the order of instanceIDs. The error joins errors of all instances the command didn't succeed on
+func RunCommand(instanceIDs []string, command string, opts CommandOptions) ([]CommandResult, error) {
+	if opts.Timeout <= 0 {
+		opts.Timeout = config.App.Config.SSMCommandTimeout
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 2 * time.Minute
+	}
+	if opts.OutputS3Bucket == "" {
+		opts.OutputS3Bucket = config.App.Config.SSMOutputS3Bucket
+		opts.OutputS3Prefix = config.App.Config.SSMOutputS3Prefix
+	}
+	if opts.OutputLogGroup == "" {
+		opts.OutputLogGroup = config.App.Config.SSMOutputLogGroup
+	}
+
+	ctx, cancel := context.WithTimeout(commandContext, opts.Timeout+commandReportMargin)
+	defer cancel()
+
+	// Commands are sent to the account and region each instance was discovered in
+	var locations []string
+	locationInstanceIDs := map[string][]string{}
+	for _, instanceID := range instanceIDs {
+		location := fmt.Sprintf("%s/%s", InstanceAccount(instanceID), InstanceRegion(instanceID))
+		if _, ok := locationInstanceIDs[location]; !ok {
+			locations = append(locations, location)
+		}
+		locationInstanceIDs[location] = append(locationInstanceIDs[location], instanceID)
+	}
+
+	output := newCommandOutput(opts, len(instanceIDs) > 1)
+
+	results := map[string]*CommandResult{}
+	for _, instanceID := range instanceIDs {
+		results[instanceID] = &CommandResult{InstanceID: instanceID, ExitCode: -1}
+	}
+
+	var wg sync.WaitGroup
+	for _, location := range locations {
+		ids := locationInstanceIDs[location]
+
+		ssmClient, err := NewSSMClient(instanceConfig(ids[0]))
+		if err != nil {
+			for _, instanceID := range ids {
+				results[instanceID].Err = err
+			}
+			continue
+		}
+
+		for start := 0; start < len(ids); start += ssmMaxCommandTargets {
+			batch := ids[start:min(start+ssmMaxCommandTargets, len(ids))]
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				runBatch(ctx, ssmClient, batch, command, opts, output, results)
+			}()
+		}
+	}
+	wg.Wait()
+
+	var ordered []CommandResult
+	var errs []error
+	for _, instanceID := range instanceIDs {
+		ordered = append(ordered, *results[instanceID])
+		if results[instanceID].Err != nil {
+			errs = append(errs, results[instanceID].Err)
+		}
+	}
+
+	return ordered, errors.Join(errs...)
+}
+
+// runBatch sends the command to a batch of instances and waits for it on each of them, filling in their results.
+// The command is cancelled on the instances of the batch if ctx is done first (Ctrl-C or timeout)
+func runBatch(ctx context.Context, ssmClient *ssm.SSM, batch []string, command string, opts CommandOptions, output *commandOutput, results map[string]*CommandResult) {
+	commandID, err := sendCommand(ctx, ssmClient, batch, command, opts)
+	if err != nil {
+		for _, instanceID := range batch {
+			results[instanceID].Err = err
+		}
+		return
+	}
+	defer cancelCommandIfInterrupted(ctx, ssmClient, commandID)
+
+	var wg sync.WaitGroup
+	for _, instanceID := range batch {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			waitCommand(ctx, ssmClient, commandID, results[instanceID], opts, output)
+		}()
+	}
+	wg.Wait()
+}
+
+func sendCommand(ctx context.Context, ssmClient *ssm.SSM, instanceIDs []string, command string, opts CommandOptions) (string, error) {
+	logger.Debug("Sending SSM command", "instances", instanceIDs, "command", command, "timeout", opts.Timeout)
+
+	input := &ssm.SendCommandInput{
+		InstanceIds:  aws.StringSlice(instanceIDs),
+		DocumentName: aws.String("AWS-RunShellScript"),
+		Comment:      aws.String(opts.Comment),
+		// Delivery timeout, SSM accepts 30 seconds at least
+		TimeoutSeconds: aws.Int64(max(int64(opts.Timeout.Seconds()), 30)),
+		Parameters: map[string][]*string{
+			"commands":         {aws.String(command)},
+			"executionTimeout": {aws.String(fmt.Sprintf("%d", max(int64(opts.Timeout.Seconds()), 1)))},
+		},
+	}
+	if opts.OutputS3Bucket != "" {
+		input.OutputS3BucketName = aws.String(opts.OutputS3Bucket)
+		input.OutputS3KeyPrefix = aws.String(opts.OutputS3Prefix)
+	}
+	if opts.OutputLogGroup != "" {
+		input.CloudWatchOutputConfig = &ssm.CloudWatchOutputConfig{
+			CloudWatchOutputEnabled: aws.Bool(true),
+			CloudWatchLogGroupName:  aws.String(opts.OutputLogGroup),
+		}
+	}
+
+	output, err := ssmClient.SendCommandWithContext(ctx, input)
+	if err != nil {
+		return "", fmt.Errorf("can't send command: %w", err)
+	}
+
+	commandID := aws.StringValue(output.Command.CommandId)
+	logger.Debug("SSM command sent. Waiting for completion", "commandID", commandID)
+
+	return commandID, nil
+}
+
+// waitCommand waits for the invocation of the command on result.InstanceID to finish and fills in the result
+func waitCommand(ctx context.Context, ssmClient *ssm.SSM, commandID string, result *CommandResult, opts CommandOptions, output *commandOutput) {
+	instanceID := result.InstanceID
+	invocationInput := &ssm.GetCommandInvocationInput{
+		CommandId:  aws.String(commandID),
+		InstanceId: aws.String(instanceID),
+	}
+
+	var streamed *logTail
+	if opts.OutputLogGroup != "" && output.enabled() {
+		streamed = startLogTail(ctx, instanceID, commandID, opts.OutputLogGroup, output)
+	}
+
+	// The waiter retries while the invocation is pending (or not registered yet) and fails on Failed, Cancelled and TimedOut.
+	// Those are read from the invocation below, so only cancellation is an error here
+	err := ssmClient.WaitUntilCommandExecutedWithContext(ctx, invocationInput,
+		request.WithWaiterDelay(request.ConstantWaiterDelay(commandPollInterval)),
+		request.WithWaiterMaxAttempts(int((opts.Timeout+commandReportMargin)/commandPollInterval)),
+	)
+	var awsErr awserr.Error
+	if err != nil && !(errors.As(err, &awsErr) && awsErr.Code() == request.WaiterResourceNotReadyErrorCode) {
+		if streamed != nil {
+			streamed.stop()
+		}
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		result.Err = fmt.Errorf("error waiting for command on %s: %w", instanceID, err)
+		return
+	}
+
+	invocation, err := ssmClient.GetCommandInvocationWithContext(ctx, invocationInput)
+	if err != nil {
+		if streamed != nil {
+			streamed.stop()
+		}
+		result.Err = fmt.Errorf("can't get command invocation on %s: %w", instanceID, err)
+		return
+	}
+
+	result.Status = aws.StringValue(invocation.Status)
+	result.Stdout = aws.StringValue(invocation.StandardOutputContent)
+	result.Stderr = aws.StringValue(invocation.StandardErrorContent)
+
+	// LocalStack doesn't set the response code, a successful invocation exited with 0 anyway
+	switch {
+	case invocation.ResponseCode != nil && aws.Int64Value(invocation.ResponseCode) >= 0:
+		result.ExitCode = int(aws.Int64Value(invocation.ResponseCode))
+	case result.Status == ssm.CommandInvocationStatusSuccess:
+		result.ExitCode = 0
+	}
+
+	if opts.OutputS3Bucket != "" {
+		if stdout, stderr, err := readS3Output(ctx, instanceID, commandID, opts); err != nil {
+			logger.Warn("Can't read full command output from S3. Output may be truncated", "instance", instanceID, "bucket", opts.OutputS3Bucket, "error", err)
+		} else {
+			result.Stdout, result.Stderr = stdout, stderr
+		}
+	}
+
+	if streamed != nil {
+		streamed.stop()
+	}
+	if streamed == nil || !streamed.received() {
+		output.write(instanceID, result.Stdout, result.Stderr)
+	}
+
+	if result.Status != ssm.CommandInvocationStatusSuccess {
+		result.Err = &CommandError{
+			InstanceID:    instanceID,
+			Status:        result.Status,
+			StatusDetails: aws.StringValue(invocation.StatusDetails),
+			ExitCode:      result.ExitCode,
+			Stderr:        result.Stderr,
+		}
+	}
+}
+
+// cancelCommandIfInterrupted cancels the command on Ctrl-C or timeout, so it doesn't keep running on instances nobody waits for
+func cancelCommandIfInterrupted(ctx context.Context, ssmClient *ssm.SSM, commandID string) {
+	if ctx.Err() == nil {
+		return
+	}
+
+	cancelCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := ssmClient.CancelCommandWithContext(cancelCtx, &ssm.CancelCommandInput{CommandId: aws.String(commandID)}); err != nil {
+		logger.Debug("Can't cancel SSM command", "commandID", commandID, "error", err)
+		return
+	}
+	logger.Debug("SSM command cancelled", "commandID", commandID)
+}
+
+// readS3Output reads the full stdout and stderr the SSM agent uploaded to <prefix>/<command ID>/<instance ID>/.../{stdout,stderr}
+func readS3Output(ctx context.Context, instanceID string, commandID string, opts CommandOptions) (string, string, error) {
+	s3Client, err := NewS3Client(instanceConfig(instanceID))
+	if err != nil {
+		return "", "", err
+	}
+
+	prefix := fmt.Sprintf("%s/%s/", commandID, instanceID)
+	if opts.OutputS3Prefix != "" {
+		prefix = fmt.Sprintf("%s/%s", strings.TrimSuffix(opts.OutputS3Prefix, "/"), prefix)
+	}
+
+	var stdout, stderr strings.Builder
+	err = s3Client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(opts.OutputS3Bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			key := aws.StringValue(object.Key)
+
+			var target *strings.Builder
+			switch {
+			case strings.HasSuffix(key, "/stdout"):
+				target = &stdout
+			case strings.HasSuffix(key, "/stderr"):
+				target = &stderr
+			default:
+				continue
+			}
+
+			content, getErr := s3Client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+				Bucket: aws.String(opts.OutputS3Bucket),
+				Key:    object.Key,
+			})
+			if getErr != nil {
+				err = getErr
+				return false
+			}
+			_, copyErr := io.Copy(target, content.Body)
+			content.Body.Close()
+			if copyErr != nil {
+				err = copyErr
+				return false
+			}
+		}
+		return !lastPage
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	return stdout.String(), stderr.String(), nil
+}
+
+// logTail streams the output of a command invocation from CloudWatch Logs while it runs
+type logTail struct {
+	done     chan struct{}
+	finished chan struct{}
+	mu       sync.Mutex
+	got      bool
+}
+
+func startLogTail(ctx context.Context, instanceID string, commandID string, logGroup string, output *commandOutput) *logTail {
+	tail := &logTail{done: make(chan struct{}), finished: make(chan struct{})}
+
+	logsClient, err := NewCloudWatchLogsClient(instanceConfig(instanceID))
+	if err != nil {
+		logger.Warn("Can't stream command output from CloudWatch Logs", "error", err)
+		close(tail.finished)
+		return tail
+	}
+
+	// Streams are named <command ID>/<instance ID>/<plugin>/{stdout,stderr}
+	streams := map[string]io.Writer{
+		fmt.Sprintf("%s/%s/aws-runShellScript/stdout", commandID, instanceID): output.stdoutWriter(instanceID),
+		fmt.Sprintf("%s/%s/aws-runShellScript/stderr", commandID, instanceID): output.stderrWriter(instanceID),
+	}
+	tokens := map[string]*string{}
+
+	poll := func() {
+		for stream, writer := range streams {
+			for {
+				events, err := logsClient.GetLogEventsWithContext(ctx, &cloudwatchlogs.GetLogEventsInput{
+					LogGroupName:  aws.String(logGroup),
+					LogStreamName: aws.String(stream),
+					StartFromHead: aws.Bool(true),
+					NextToken:     tokens[stream],
+				})
+				if err != nil {
+					// The stream appears once the command prints something
+					return
+				}
+
+				for _, event := range events.Events {
+					message := aws.StringValue(event.Message)
+					if !strings.HasSuffix(message, "\n") {
+						message += "\n"
+					}
+					_, _ = io.WriteString(writer, message)
+
+					tail.mu.Lock()
+					tail.got = true
+					tail.mu.Unlock()
+				}
+
+				// The same token is returned once the end of the stream is reached
+				if aws.StringValue(events.NextForwardToken) == aws.StringValue(tokens[stream]) {
+					break
+				}
+				tokens[stream] = events.NextForwardToken
+			}
+		}
+	}
+
+	go func() {
+		defer close(tail.finished)
+		for {
+			select {
+			case <-tail.done:
+				// Output of the last seconds is delivered to CloudWatch after the invocation finishes
+				_ = sleep(ctx, commandPollInterval)
+				poll()
+				return
+			case <-ctx.Done():
+				return
+			case <-time.After(commandPollInterval):
+				poll()
+			}
+		}
+	}()
+
+	return tail
+}
+
+func (t *logTail) stop() {
+	select {
+	case <-t.finished:
+		return
+	default:
+	}
+
+	close(t.done)
+	<-t.finished
+}
+
+func (t *logTail) received() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.got
+}
+
+// commandOutput writes the output of instances to opts.Stdout and opts.Stderr, one line at a time
+type commandOutput struct {
+	stdout io.Writer
+	stderr io.Writer
+	prefix bool
+	mu     sync.Mutex
+}
+
+func newCommandOutput(opts CommandOptions, prefix bool) *commandOutput {
+	return &commandOutput{stdout: opts.Stdout, stderr: opts.Stderr, prefix: prefix}
+}
+
+func (o *commandOutput) enabled() bool {
+	return o.stdout != nil || o.stderr != nil
+}
+
+func (o *commandOutput) write(instanceID string, stdout string, stderr string) {
+	_, _ = io.WriteString(o.stdoutWriter(instanceID), stdout)
+	_, _ = io.WriteString(o.stderrWriter(instanceID), stderr)
+}
+
+func (o *commandOutput) stdoutWriter(instanceID string) io.Writer {
+	return o.lineWriter(o.stdout, instanceID)
+}
+
+func (o *commandOutput) stderrWriter(instanceID string) io.Writer {
+	return o.lineWriter(o.stderr, instanceID)
+}
+
+func (o *commandOutput) lineWriter(w io.Writer, instanceID string) io.Writer {
+	if w == nil {
+		return io.Discard
+	}
+
+	prefix := ""
+	if o.prefix {
+		prefix = fmt.Sprintf("[%s] ", instanceID)
+	}
+
+	return &lineWriter{output: o, w: w, prefix: prefix}
+}
+
+// lineWriter writes whole lines, so output of instances running concurrently doesn't interleave mid-line
+type lineWriter struct {
+	output *commandOutput
+	w      io.Writer
+	prefix string
+}
+
+func (l *lineWriter) Write(p []byte) (int, error) {
+	l.output.mu.Lock()
+	defer l.output.mu.Unlock()
+
+	for _, line := range bytes.SplitAfter(p, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		text := l.prefix + string(line)
+		if !bytes.HasSuffix(line, []byte("\n")) {
+			text += "\n"
+		}
+		if _, err := io.WriteString(l.w, text); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
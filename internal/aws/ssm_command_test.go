@@ -0,0 +1,150 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package aws
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+func TestCommandError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  CommandError
+		want string
+	}{
+		{
+			name: "undeliverable",
+			err:  CommandError{InstanceID: "i-a", Status: ssm.CommandInvocationStatusFailed, StatusDetails: "Undeliverable", ExitCode: -1},
+			want: "command can't be delivered to i-a: the instance is stopped or its SSM agent is offline",
+		},
+		{
+			name: "delivery timed out",
+			err:  CommandError{InstanceID: "i-a", Status: ssm.CommandInvocationStatusTimedOut, StatusDetails: "DeliveryTimedOut"},
+			want: "command wasn't delivered to i-a in time: the SSM agent is offline",
+		},
+		{
+			name: "execution timed out",
+			err:  CommandError{InstanceID: "i-a", Status: ssm.CommandInvocationStatusTimedOut, StatusDetails: "ExecutionTimedOut", ExitCode: -1},
+			want: "command timed out on i-a",
+		},
+		{
+			name: "invalid platform",
+			err:  CommandError{InstanceID: "i-a", Status: ssm.CommandInvocationStatusFailed, StatusDetails: "InvalidPlatform"},
+			want: "command can't run on i-a: the platform doesn't support shell scripts",
+		},
+		{
+			name: "access denied",
+			err:  CommandError{InstanceID: "i-a", Status: ssm.CommandInvocationStatusFailed, StatusDetails: "AccessDenied"},
+			want: "command can't run on i-a: access denied",
+		},
+		{
+			name: "terminated",
+			err:  CommandError{InstanceID: "i-a", Status: ssm.CommandInvocationStatusFailed, StatusDetails: "Terminated"},
+			want: "command was terminated on i-a",
+		},
+		{
+			name: "cancelled",
+			err:  CommandError{InstanceID: "i-a", Status: ssm.CommandInvocationStatusCancelled, StatusDetails: "Cancelled"},
+			want: "command was cancelled on i-a",
+		},
+		{
+			name: "timed out without details",
+			err:  CommandError{InstanceID: "i-a", Status: ssm.CommandInvocationStatusTimedOut},
+			want: "command timed out on i-a",
+		},
+		{
+			name: "failed with stderr",
+			err:  CommandError{InstanceID: "i-a", Status: ssm.CommandInvocationStatusFailed, StatusDetails: "Failed", ExitCode: 2, Stderr: "ls: /nope: No such file or directory\n"},
+			want: "command failed on i-a with exit code 2: ls: /nope: No such file or directory",
+		},
+		{
+			name: "failed without stderr",
+			err:  CommandError{InstanceID: "i-a", Status: ssm.CommandInvocationStatusFailed, StatusDetails: "Failed", ExitCode: 1, Stderr: " \n"},
+			want: "command failed on i-a with exit code 1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.err.Error(); got != tt.want {
+				t.Errorf("Error() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLineWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix bool
+		writes []string
+		want   string
+	}{
+		{
+			name:   "single instance",
+			writes: []string{"one\ntwo\n"},
+			want:   "one\ntwo\n",
+		},
+		{
+			name:   "prefixed lines",
+			prefix: true,
+			writes: []string{"one\ntwo\n"},
+			want:   "[i-a] one\n[i-a] two\n",
+		},
+		{
+			name:   "missing trailing newline is added",
+			prefix: true,
+			writes: []string{"one\ntwo"},
+			want:   "[i-a] one\n[i-a] two\n",
+		},
+		{
+			name:   "empty lines are kept",
+			prefix: true,
+			writes: []string{"one\n\ntwo\n"},
+			want:   "[i-a] one\n[i-a] \n[i-a] two\n",
+		},
+		{
+			name:   "empty write",
+			prefix: true,
+			writes: []string{""},
+			want:   "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			w := newCommandOutput(CommandOptions{Stdout: &out}, tt.prefix).stdoutWriter("i-a")
+
+			for _, s := range tt.writes {
+				n, err := w.Write([]byte(s))
+				if err != nil {
+					t.Fatalf("Write(%q) returned an error: %v", s, err)
+				}
+				if n != len(s) {
+					t.Errorf("Write(%q) = %d, want %d", s, n, len(s))
+				}
+			}
+
+			if got := out.String(); got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommandOutputWithoutWriters(t *testing.T) {
+	output := newCommandOutput(CommandOptions{}, true)
+	if output.enabled() {
+		t.Error("enabled() = true without stdout and stderr writers")
+	}
+
+	// Output of instances is discarded rather than failing
+	output.write("i-a", "out\n", "err\n")
+}
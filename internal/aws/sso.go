@@ -0,0 +1,293 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package aws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/DimmKirr/atun/internal/logger"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials/ssocreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ssooidc"
+	"github.com/pterm/pterm"
+	"gopkg.in/ini.v1"
+)
+
+// ssoTokenExpiryMargin makes the token count as expired a bit early, so it doesn't expire in the middle of a command
+const ssoTokenExpiryMargin = 5 * time.Minute
+
+// ssoProfile is the IAM Identity Center (SSO) configuration of a profile in the shared config file
+type ssoProfile struct {
+	// SessionName is the sso_session the profile refers to, empty for legacy profiles with sso_start_url
+	SessionName string
+	StartURL    string
+	Region      string
+	Scopes      []string
+}
+
+// CacheKey returns the key of the token in ~/.aws/sso/cache, the same one aws CLI and SDKs use
+func (p ssoProfile) CacheKey() string {
+	if p.SessionName != "" {
+		return p.SessionName
+	}
+
+	return p.StartURL
+}
+
+// ssoCachedToken is the token cache file format shared with aws CLI and SDKs
+type ssoCachedToken struct {
+	StartURL              string `json:"startUrl"`
+	Region                string `json:"region"`
+	AccessToken           string `json:"accessToken"`
+	ExpiresAt             string `json:"expiresAt"`
+	ClientID              string `json:"clientId,omitempty"`
+	ClientSecret          string `json:"clientSecret,omitempty"`
+	RegistrationExpiresAt string `json:"registrationExpiresAt,omitempty"`
+	RefreshToken          string `json:"refreshToken,omitempty"`
+}
+
+// EnsureSSOToken logs in with the OIDC device authorization flow if the profile uses IAM Identity Center (SSO)
+// and its cached token is missing or expired. It's a no-op for other profiles.
+func EnsureSSOToken(profile string) error {
+	ssoConfig, err := getSSOProfile(profile)
+	if err != nil || ssoConfig == nil {
+		return err
+	}
+
+	if !ssoLoginRequired(*ssoConfig) {
+		return nil
+	}
+
+	return ssoLogin(*ssoConfig)
+}
+
+// SSOLoginRequired reports whether the profile uses IAM Identity Center (SSO) and the user has to log in
+func SSOLoginRequired(profile string) bool {
+	ssoConfig, err := getSSOProfile(profile)
+	if err != nil || ssoConfig == nil {
+		return false
+	}
+
+	return ssoLoginRequired(*ssoConfig)
+}
+
+func ssoLoginRequired(ssoConfig ssoProfile) bool {
+	cacheFile, err := ssocreds.StandardCachedTokenFilepath(ssoConfig.CacheKey())
+	if err != nil {
+		return true
+	}
+
+	data, err := os.ReadFile(cacheFile)
+	if err != nil {
+		logger.Debug("No cached SSO token", "path", cacheFile)
+		return true
+	}
+
+	var token ssoCachedToken
+	if err := json.Unmarshal(data, &token); err != nil || token.AccessToken == "" {
+		logger.Debug("Invalid cached SSO token", "path", cacheFile, "error", err)
+		return true
+	}
+
+	expiresAt, err := time.Parse(time.RFC3339, token.ExpiresAt)
+	if err != nil {
+		return true
+	}
+
+	return time.Now().Add(ssoTokenExpiryMargin).After(expiresAt)
+}
+
+// ssoLogin runs the OIDC device authorization flow and writes the token to the cache of aws CLI and SDKs
+func ssoLogin(ssoConfig ssoProfile) error {
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            aws.Config{Region: aws.String(ssoConfig.Region)},
+		SharedConfigState: session.SharedConfigDisable,
+	})
+	if err != nil {
+		return err
+	}
+	oidc := ssooidc.New(sess)
+
+	registerCtx, registerCancel := CallContext()
+	defer registerCancel()
+
+	registration, err := oidc.RegisterClientWithContext(registerCtx, &ssooidc.RegisterClientInput{
+		ClientName: aws.String("atun"),
+		ClientType: aws.String("public"),
+		Scopes:     aws.StringSlice(ssoConfig.Scopes),
+	})
+	if err != nil {
+		return fmt.Errorf("can't register SSO client: %w", err)
+	}
+
+	authorization, err := oidc.StartDeviceAuthorizationWithContext(registerCtx, &ssooidc.StartDeviceAuthorizationInput{
+		ClientId:     registration.ClientId,
+		ClientSecret: registration.ClientSecret,
+		StartUrl:     aws.String(ssoConfig.StartURL),
+	})
+	if err != nil {
+		return fmt.Errorf("can't start SSO device authorization: %w", err)
+	}
+
+	pterm.Printfln(" %s AWS SSO login required. Open %s and confirm the code %s",
+		pterm.LightBlue("▶︎"),
+		pterm.Bold.Sprint(aws.StringValue(authorization.VerificationUriComplete)),
+		pterm.Bold.Sprint(aws.StringValue(authorization.UserCode)),
+	)
+
+	interval := time.Duration(aws.Int64Value(authorization.Interval)) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	deadline := time.Now().Add(time.Duration(aws.Int64Value(authorization.ExpiresIn)) * time.Second)
+
+	// The user confirms the code in a browser, so polling is bound to the command (Ctrl-C) and the code expiry only
+	ctx, cancel := context.WithDeadline(commandContext, deadline)
+	defer cancel()
+
+	for {
+		if err := sleep(ctx, interval); err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				break
+			}
+			return fmt.Errorf("SSO login cancelled: %w", err)
+		}
+
+		token, err := oidc.CreateTokenWithContext(ctx, &ssooidc.CreateTokenInput{
+			ClientId:     registration.ClientId,
+			ClientSecret: registration.ClientSecret,
+			DeviceCode:   authorization.DeviceCode,
+			GrantType:    aws.String("urn:ietf:params:oauth:grant-type:device_code"),
+		})
+
+		var awsErr awserr.Error
+		if errors.As(err, &awsErr) {
+			switch awsErr.Code() {
+			case ssooidc.ErrCodeAuthorizationPendingException:
+				continue
+			case ssooidc.ErrCodeSlowDownException:
+				interval += 5 * time.Second
+				continue
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("SSO login failed: %w", err)
+		}
+
+		if err := writeSSOToken(ssoConfig, registration, token); err != nil {
+			return err
+		}
+
+		logger.Info("Logged in with AWS SSO", "startURL", ssoConfig.StartURL)
+		return nil
+	}
+
+	return fmt.Errorf("SSO login timed out: the code wasn't confirmed in time")
+}
+
+func writeSSOToken(ssoConfig ssoProfile, registration *ssooidc.RegisterClientOutput, token *ssooidc.CreateTokenOutput) error {
+	cacheFile, err := ssocreds.StandardCachedTokenFilepath(ssoConfig.CacheKey())
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(ssoCachedToken{
+		StartURL:              ssoConfig.StartURL,
+		Region:                ssoConfig.Region,
+		AccessToken:           aws.StringValue(token.AccessToken),
+		ExpiresAt:             time.Now().Add(time.Duration(aws.Int64Value(token.ExpiresIn)) * time.Second).UTC().Format(time.RFC3339),
+		ClientID:              aws.StringValue(registration.ClientId),
+		ClientSecret:          aws.StringValue(registration.ClientSecret),
+		RegistrationExpiresAt: time.Unix(aws.Int64Value(registration.ClientSecretExpiresAt), 0).UTC().Format(time.RFC3339),
+		RefreshToken:          aws.StringValue(token.RefreshToken),
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(cacheFile), 0700); err != nil {
+		return fmt.Errorf("can't create SSO cache directory: %w", err)
+	}
+
+	if err := os.WriteFile(cacheFile, data, 0600); err != nil {
+		return fmt.Errorf("can't write SSO token cache: %w", err)
+	}
+	logger.Debug("SSO token cached", "path", cacheFile)
+
+	return nil
+}
+
+// getSSOProfile reads the SSO configuration of the profile from the shared config file. It returns nil for profiles without SSO
+func getSSOProfile(profile string) (*ssoProfile, error) {
+	if profile == "" {
+		profile = os.Getenv("AWS_PROFILE")
+	}
+	if profile == "" {
+		profile = "default"
+	}
+
+	configFile := os.Getenv("AWS_CONFIG_FILE")
+	if configFile == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		configFile = filepath.Join(homeDir, ".aws", "config")
+	}
+
+	sharedConfig, err := ini.Load(configFile)
+	if err != nil {
+		// No shared config file means no SSO profiles
+		return nil, nil
+	}
+
+	sectionName := fmt.Sprintf("profile %s", profile)
+	if profile == "default" && !sharedConfig.HasSection(sectionName) {
+		sectionName = "default"
+	}
+
+	section, err := sharedConfig.GetSection(sectionName)
+	if err != nil {
+		return nil, nil
+	}
+
+	if sessionName := section.Key("sso_session").String(); sessionName != "" {
+		ssoSession, err := sharedConfig.GetSection(fmt.Sprintf("sso-session %s", sessionName))
+		if err != nil {
+			return nil, fmt.Errorf("sso-session %s of profile %s not found in %s", sessionName, profile, configFile)
+		}
+
+		scopes := []string{"sso:account:access"}
+		if value := ssoSession.Key("sso_registration_scopes").String(); value != "" {
+			scopes = strings.Split(strings.ReplaceAll(value, " ", ""), ",")
+		}
+
+		return &ssoProfile{
+			SessionName: sessionName,
+			StartURL:    ssoSession.Key("sso_start_url").String(),
+			Region:      ssoSession.Key("sso_region").String(),
+			Scopes:      scopes,
+		}, nil
+	}
+
+	if startURL := section.Key("sso_start_url").String(); startURL != "" {
+		return &ssoProfile{
+			StartURL: startURL,
+			Region:   section.Key("sso_region").String(),
+		}, nil
+	}
+
+	return nil, nil
+}
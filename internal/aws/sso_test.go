@@ -0,0 +1,193 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package aws
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials/ssocreds"
+)
+
+const testSharedConfig = `[default]
+region = eu-west-1
+
+[profile legacy]
+sso_start_url = https://legacy.awsapps.com/start
+sso_region = us-east-1
+sso_account_id = 111111111111
+sso_role_name = Admin
+
+[profile modern]
+sso_session = corp
+sso_account_id = 222222222222
+sso_role_name = Admin
+
+[profile scoped]
+sso_session = scoped
+
+[profile missing-session]
+sso_session = nope
+
+[profile keys]
+aws_access_key_id = AKIDEXAMPLE
+
+[sso-session corp]
+sso_start_url = https://corp.awsapps.com/start
+sso_region = eu-central-1
+
+[sso-session scoped]
+sso_start_url = https://scoped.awsapps.com/start
+sso_region = eu-central-1
+sso_registration_scopes = sso:account:access, codecatalyst:read_write
+`
+
+func TestGetSSOProfile(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(configFile, []byte(testSharedConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AWS_CONFIG_FILE", configFile)
+	t.Setenv("AWS_PROFILE", "")
+
+	tests := []struct {
+		name     string
+		profile  string
+		want     *ssoProfile
+		wantErr  bool
+		cacheKey string
+	}{
+		{
+			name:     "legacy profile",
+			profile:  "legacy",
+			want:     &ssoProfile{StartURL: "https://legacy.awsapps.com/start", Region: "us-east-1"},
+			cacheKey: "https://legacy.awsapps.com/start",
+		},
+		{
+			name:     "sso-session profile",
+			profile:  "modern",
+			want:     &ssoProfile{SessionName: "corp", StartURL: "https://corp.awsapps.com/start", Region: "eu-central-1", Scopes: []string{"sso:account:access"}},
+			cacheKey: "corp",
+		},
+		{
+			name:     "sso-session with registration scopes",
+			profile:  "scoped",
+			want:     &ssoProfile{SessionName: "scoped", StartURL: "https://scoped.awsapps.com/start", Region: "eu-central-1", Scopes: []string{"sso:account:access", "codecatalyst:read_write"}},
+			cacheKey: "scoped",
+		},
+		{
+			name:    "missing sso-session",
+			profile: "missing-session",
+			wantErr: true,
+		},
+		{
+			name:    "profile with keys",
+			profile: "keys",
+		},
+		{
+			name:    "default profile without SSO",
+			profile: "",
+		},
+		{
+			name:    "unknown profile",
+			profile: "unknown",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := getSSOProfile(tt.profile)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getSSOProfile(%q) error = %v, wantErr %v", tt.profile, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getSSOProfile(%q) = %+v, want %+v", tt.profile, got, tt.want)
+			}
+			if got != nil && got.CacheKey() != tt.cacheKey {
+				t.Errorf("CacheKey() = %q, want %q", got.CacheKey(), tt.cacheKey)
+			}
+		})
+	}
+
+	// Without a shared config file there are no SSO profiles
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "missing"))
+	if got, err := getSSOProfile("legacy"); got != nil || err != nil {
+		t.Errorf("getSSOProfile() without a config file = %+v, %v", got, err)
+	}
+}
+
+func TestSSOLoginRequired(t *testing.T) {
+	// The token cache is in ~/.aws/sso/cache
+	t.Setenv("HOME", t.TempDir())
+
+	ssoConfig := ssoProfile{SessionName: "corp", StartURL: "https://corp.awsapps.com/start", Region: "eu-central-1"}
+	cacheFile, err := ssocreds.StandardCachedTokenFilepath(ssoConfig.CacheKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(cacheFile), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  bool
+	}{
+		{
+			name: "no cached token",
+			want: true,
+		},
+		{
+			name:  "valid token",
+			token: `{"accessToken": "token", "expiresAt": "` + time.Now().Add(time.Hour).UTC().Format(time.RFC3339) + `"}`,
+			want:  false,
+		},
+		{
+			name:  "expired token",
+			token: `{"accessToken": "token", "expiresAt": "` + time.Now().Add(-time.Hour).UTC().Format(time.RFC3339) + `"}`,
+			want:  true,
+		},
+		{
+			name:  "token expires within the margin",
+			token: `{"accessToken": "token", "expiresAt": "` + time.Now().Add(ssoTokenExpiryMargin/2).UTC().Format(time.RFC3339) + `"}`,
+			want:  true,
+		},
+		{
+			name:  "token without access token",
+			token: `{"expiresAt": "` + time.Now().Add(time.Hour).UTC().Format(time.RFC3339) + `"}`,
+			want:  true,
+		},
+		{
+			name:  "invalid expiry",
+			token: `{"accessToken": "token", "expiresAt": "tomorrow"}`,
+			want:  true,
+		},
+		{
+			name:  "invalid cache file",
+			token: `not json`,
+			want:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Remove(cacheFile)
+			if tt.token != "" {
+				if err := os.WriteFile(cacheFile, []byte(tt.token), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if got := ssoLoginRequired(ssoConfig); got != tt.want {
+				t.Errorf("ssoLoginRequired() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -27,21 +27,49 @@ type Atun struct {
 
 type Config struct {
 	Hosts                       []Endpoint
+	SkippedHosts                []SkippedHost
 	SSHKeyPath                  string
+	SSHSessionKeyTTL            time.Duration
+	SSHAuth                     string
+	SSHCAKey                    string
+	SSHCertTTL                  time.Duration
+	SSHAgent                    bool
+	SSHAgentKey                 string
 	SSHConfigFile               string
 	SSHStrictHostKeyChecking    bool
+	SSHRepinHostKeys            bool // Set by up --repin-host-keys, replaces changed host keys of a router
+	SSHKeyPush                  string
+	SSHBastionHost              string
+	SSHBastionPort              int
 	SSHSocketFile               string
 	AWSProfile                  string
 	AWSRegion                   string
+	AWSRegions                  []string
+	AllRegions                  bool
+	Accounts                    []AccountTarget
 	AWSKeyPair                  string
 	AWSEndpointUrl              string
 	AWSInstanceType             string
 	AWSMFASharedCredentialsFile string
 	AWSMFACode                  string
+	AWSMFACommand               string
+	AWSMFADevice                string
+	AWSCallTimeout              time.Duration
+	SSMCommandTimeout           time.Duration
+	SSMOutputS3Bucket           string
+	SSMOutputS3Prefix           string
+	SSMOutputLogGroup           string
 	ConfigFile                  string
 	RouterVPCID                 string
 	RouterSubnetID              string
 	RouterHostID                string
+	RouterType                  string
+	RouterTransport             string
+	KubeConfig                  string
+	KubeContext                 string
+	KubeNamespace               string
+	ECSCluster                  string
+	RouterECSImage              string
 	RouterInstanceName          string
 	RouterHostAMI               string
 	RouterHostUser              string
@@ -55,22 +83,51 @@ type Config struct {
 	DemoMode                    bool
 }
 
-// TODO: Add ability to add multiple ports for forwarding for one host
-//  (maybe <host>: [{"local":0, "remote":22, "proto": "ssm"}, {"local":0, "remote":443, "proto": "ssm"}])
-
+// Endpoint is a port of a host forwarded through the router. A host with several ports (schema v2) is an Endpoint per port
 type Endpoint struct {
-	Name   string `jsonschema:"-"`
-	Proto  string `json:"proto" jsonschema:"proto"`
-	Remote int    `json:"remote" jsonschema:"remote"`
-	Local  int    `json:"local" jsonschema:"local"`
+	Name        string            `jsonschema:"-"`
+	Proto       string            `json:"proto" jsonschema:"proto"`
+	Remote      int               `json:"remote" jsonschema:"remote"`
+	Local       int               `json:"local" jsonschema:"local"`
+	Alias       string            `json:"alias,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	HealthCheck string            `json:"health_check,omitempty" mapstructure:"health_check"`
+}
+
+// SkippedHost is a host tag of the router that can't be read. Its endpoints are not forwarded
+type SkippedHost struct {
+	Key   string
+	Value string
+	Error error
+}
+
+// AccountTarget is an AWS account routers are searched in, reached by assuming a role from the AWS profile
+type AccountTarget struct {
+	RoleARN     string `mapstructure:"role_arn"`
+	ExternalID  string `mapstructure:"external_id"`
+	SessionName string `mapstructure:"session_name"`
+	Alias       string `mapstructure:"alias"`
 }
 
 // RouterInfo represents the information about a router
 type RouterInfo struct {
-	ID        string
-	Type      string
-	State     string
-	CreatedAt time.Time
+	ID           string
+	Name         string
+	Type         string
+	State        string
+	Account      string
+	Region       string
+	Zone         string
+	InstanceType string
+	VPCID        string
+	SubnetID     string
+	Env          string
+	Endpoints    int
+	PingStatus   string
+	AgentVersion string
+	AdHoc        bool
+	CreatedAt    time.Time
 }
 
 var App *Atun
@@ -162,33 +219,68 @@ func LoadConfig() error {
 	viper.SetDefault("AWS_MFA_SHARED_CREDENTIALS_FILE", filepath.Join(homeDir, ".aws", "credentials"))
 
 	// Set Default Values if none are set
-	viper.SetDefault("SSH_KEY_PATH", filepath.Join(homeDir, ".ssh", "id_rsa"))
-	viper.SetDefault("SSH_STRICT_HOST_KEY_CHECKING", true)
+	viper.SetDefault("SSH_KEY_PATH", "")           // A key is generated per tunnel unless a key is set
+	viper.SetDefault("SSH_SESSION_KEY_TTL", "24h") // Session keys left on routers by crashed sessions are removed after this
+	viper.SetDefault("SSH_AUTH", "key")            // "key" authorizes session keys on routers, "ca" signs short-lived certificates instead
+	viper.SetDefault("SSH_CERT_TTL", "1h")
+	viper.SetDefault("SSH_AGENT", false)                   // Authenticate with a key of the ssh-agent (SSH_AUTH_SOCK) instead of a session key
+	viper.SetDefault("SSH_STRICT_HOST_KEY_CHECKING", true) // Host keys of routers are pinned in known_hosts of the env and checked
+	viper.SetDefault("SSH_BASTION_PORT", 22)
+	viper.SetDefault("SSH_KEY_PUSH", "ssm") // Append keys to authorized_keys via SSM. eic pushes them with EC2 Instance Connect (valid for 60s) and falls back to SSM
 	viper.SetDefault("AWS_INSTANCE_TYPE", "t3.nano")
+	viper.SetDefault("AWS_CALL_TIMEOUT", "30s") // A stuck AWS API call fails the command instead of hanging it
+	viper.SetDefault("SSM_COMMAND_TIMEOUT", "2m")
 	viper.SetDefault("ROUTER_INSTANCE_NAME", "atun-router")
-	viper.SetDefault("SSH_STRICT_HOST_KEY_CHECKING", false) // Strict host key checking is disabled by default for better user experience. Debatable
-	viper.SetDefault("AUTO_ALLOCATE_PORT", false)           // Port auto-allocation is disabled by default
-	viper.SetDefault("LOG_PLAIN_TEXT", false)               // Set LOG_PLAIN_TEXT to false by default
-	viper.SetDefault("TERRAFORM_VERSION", "latest")         // Default to latest Terraform version
-	viper.SetDefault("DEMO_MODE", false)                    // Default to false
+	viper.SetDefault("ROUTER_TYPE", "ec2")
+	viper.SetDefault("ROUTER_ECS_IMAGE", "public.ecr.aws/docker/library/alpine:3")
+	viper.SetDefault("AUTO_ALLOCATE_PORT", false)   // Port auto-allocation is disabled by default
+	viper.SetDefault("LOG_PLAIN_TEXT", false)       // Set LOG_PLAIN_TEXT to false by default
+	viper.SetDefault("TERRAFORM_VERSION", "latest") // Default to latest Terraform version
+	viper.SetDefault("DEMO_MODE", false)            // Default to false
 
 	// TODO?: Move init a separate file with correct imports of config
 	App = &Atun{
-		Version: "1",
+		Version: "2", // Version of the atun.io tag schema written to routers, see internal/schema
 		Config: &Config{
 			Hosts:                       []Endpoint{},
 			Env:                         viper.GetString("ENV"),
 			SSHKeyPath:                  viper.GetString("SSH_KEY_PATH"),
+			SSHSessionKeyTTL:            viper.GetDuration("SSH_SESSION_KEY_TTL"),
+			SSHAuth:                     viper.GetString("SSH_AUTH"),
+			SSHCAKey:                    viper.GetString("SSH_CA_KEY"),
+			SSHCertTTL:                  viper.GetDuration("SSH_CERT_TTL"),
+			SSHAgent:                    viper.GetBool("SSH_AGENT"),
+			SSHAgentKey:                 viper.GetString("SSH_AGENT_KEY"),
 			SSHStrictHostKeyChecking:    viper.GetBool("SSH_STRICT_HOST_KEY_CHECKING"),
+			SSHKeyPush:                  viper.GetString("SSH_KEY_PUSH"),
+			SSHBastionHost:              viper.GetString("SSH_BASTION_HOST"),
+			SSHBastionPort:              viper.GetInt("SSH_BASTION_PORT"),
 			AWSProfile:                  viper.GetString("AWS_PROFILE"),
 			AWSRegion:                   viper.GetString("AWS_REGION"),
+			AWSRegions:                  splitList(viper.GetStringSlice("AWS_REGIONS")),
+			AllRegions:                  viper.GetBool("ALL_REGIONS"),
 			AWSKeyPair:                  viper.GetString("AWS_KEY_PAIR"),
 			AWSInstanceType:             viper.GetString("AWS_INSTANCE_TYPE"),
 			AWSEndpointUrl:              viper.GetString("AWS_ENDPOINT_URL"),
 			AWSMFASharedCredentialsFile: viper.GetString("AWS_MFA_SHARED_CREDENTIALS_FILE"),
+			AWSMFACode:                  viper.GetString("AWS_MFA_CODE"),
+			AWSMFACommand:               viper.GetString("AWS_MFA_COMMAND"),
+			AWSMFADevice:                viper.GetString("AWS_MFA_DEVICE"),
+			AWSCallTimeout:              viper.GetDuration("AWS_CALL_TIMEOUT"),
+			SSMCommandTimeout:           viper.GetDuration("SSM_COMMAND_TIMEOUT"),
+			SSMOutputS3Bucket:           viper.GetString("SSM_OUTPUT_S3_BUCKET"),
+			SSMOutputS3Prefix:           viper.GetString("SSM_OUTPUT_S3_PREFIX"),
+			SSMOutputLogGroup:           viper.GetString("SSM_OUTPUT_LOG_GROUP"),
 			RouterVPCID:                 viper.GetString("ROUTER_VPC_ID"),
 			RouterSubnetID:              viper.GetString("ROUTER_SUBNET_ID"),
 			RouterHostID:                viper.GetString("ROUTER_HOST_ID"),
+			RouterType:                  viper.GetString("ROUTER_TYPE"),
+			RouterTransport:             viper.GetString("ROUTER_TRANSPORT"),
+			KubeConfig:                  viper.GetString("KUBE_CONFIG"),
+			KubeContext:                 viper.GetString("KUBE_CONTEXT"),
+			KubeNamespace:               viper.GetString("KUBE_NAMESPACE"),
+			ECSCluster:                  viper.GetString("ECS_CLUSTER"),
+			RouterECSImage:              viper.GetString("ROUTER_ECS_IMAGE"),
 			RouterInstanceName:          viper.GetString("ROUTER_INSTANCE_NAME"),
 			RouterHostAMI:               viper.GetString("ROUTER_HOST_AMI"),
 			RouterHostUser:              viper.GetString("ROUTER_HOST_USER"),
@@ -223,6 +315,20 @@ func LoadConfig() error {
 	return nil
 }
 
+// splitList splits comma-separated items (e.g. ATUN_AWS_REGIONS="us-east-1,eu-west-1") of a list setting
+func splitList(items []string) []string {
+	var list []string
+	for _, item := range items {
+		for _, value := range strings.Split(item, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				list = append(list, value)
+			}
+		}
+	}
+
+	return list
+}
+
 func SaveConfig() error {
 	// Save the config file to the current working directory
 	currentDir, err := os.Getwd()
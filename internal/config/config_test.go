@@ -0,0 +1,139 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+func TestSplitList(t *testing.T) {
+	tests := []struct {
+		name  string
+		items []string
+		want  []string
+	}{
+		{
+			name:  "not set",
+			items: nil,
+			want:  nil,
+		},
+		{
+			name:  "toml list",
+			items: []string{"us-east-1", "eu-west-1"},
+			want:  []string{"us-east-1", "eu-west-1"},
+		},
+		{
+			name:  "comma-separated env var",
+			items: []string{"us-east-1,eu-west-1"},
+			want:  []string{"us-east-1", "eu-west-1"},
+		},
+		{
+			name:  "whitespace is trimmed",
+			items: []string{" us-east-1 , eu-west-1\t", "  ap-south-1"},
+			want:  []string{"us-east-1", "eu-west-1", "ap-south-1"},
+		},
+		{
+			name:  "empty entries are skipped",
+			items: []string{"us-east-1,,", " ", ",eu-west-1", ""},
+			want:  []string{"us-east-1", "eu-west-1"},
+		},
+		{
+			name:  "only empty entries",
+			items: []string{",", " , "},
+			want:  nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitList(tt.items); !slices.Equal(got, tt.want) {
+				t.Errorf("splitList(%q) = %q, want %q", tt.items, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigMFACode(t *testing.T) {
+	tests := []struct {
+		name        string
+		flag        string
+		env         string
+		wantCode    string
+		wantCommand string
+	}{
+		{
+			name:        "only aws_mfa_command",
+			wantCommand: "op item get aws --otp",
+		},
+		{
+			name:        "env var",
+			env:         "222222",
+			wantCode:    "222222",
+			wantCommand: "op item get aws --otp",
+		},
+		{
+			name:        "flag takes precedence over env var",
+			flag:        "111111",
+			env:         "222222",
+			wantCode:    "111111",
+			wantCommand: "op item get aws --otp",
+		},
+	}
+
+	workDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(workDir, "atun.toml"), []byte(`aws_mfa_command = "op item get aws --otp"`+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	currentDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(workDir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = os.Chdir(currentDir)
+		viper.Reset()
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Reset()
+			t.Setenv("HOME", t.TempDir())
+			t.Setenv("ATUN_AWS_MFA_CODE", tt.env)
+
+			// Bound the same way as the --mfa-code flag of the root command
+			flags := pflag.NewFlagSet("atun", pflag.ContinueOnError)
+			flags.String("mfa-code", "", "")
+			if err := viper.BindPFlag("AWS_MFA_CODE", flags.Lookup("mfa-code")); err != nil {
+				t.Fatal(err)
+			}
+			if tt.flag != "" {
+				if err := flags.Set("mfa-code", tt.flag); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := LoadConfig(); err != nil {
+				t.Fatalf("LoadConfig() returned an error: %v", err)
+			}
+
+			if App.Config.AWSMFACode != tt.wantCode {
+				t.Errorf("AWSMFACode = %q, want %q", App.Config.AWSMFACode, tt.wantCode)
+			}
+			if App.Config.AWSMFACommand != tt.wantCommand {
+				t.Errorf("AWSMFACommand = %q, want %q", App.Config.AWSMFACommand, tt.wantCommand)
+			}
+		})
+	}
+}
@@ -6,7 +6,6 @@
 package infra
 
 import (
-	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
@@ -16,6 +15,7 @@ import (
 	"github.com/DimmKirr/atun/internal/config"
 	"github.com/DimmKirr/atun/internal/constraints"
 	"github.com/DimmKirr/atun/internal/logger"
+	"github.com/DimmKirr/atun/internal/schema"
 	"github.com/aws/jsii-runtime-go"
 	awsprovider "github.com/cdktf/cdktf-provider-aws-go/aws/v19/provider"
 	"github.com/hashicorp/terraform-cdk-go/cdktf"
@@ -27,11 +27,11 @@ func createStack(c *config.Config) {
 		Outdir: jsii.String(filepath.Join(c.TunnelDir)), // Set your desired directory here
 	})
 
-	stack := cdktf.NewTerraformStack(app, jsii.String(fmt.Sprintf("%s-%s", c.AWSProfile, c.Env)))
+	stack := cdktf.NewTerraformStack(app, jsii.String(stackName(c)))
 
 	// Configure the local backend to store state in the tunnel directory
 	cdktf.NewLocalBackend(stack, &cdktf.LocalBackendConfig{
-		Path: jsii.String(filepath.Join(c.TunnelDir, "terraform.tfstate")), // Specify state file path
+		Path: jsii.String(filepath.Join(c.TunnelDir, stateFileName(c))), // Specify state file path
 	})
 
 	awsprovider.NewAwsProvider(stack, jsii.String("AWS"), &awsprovider.AwsProviderConfig{
@@ -42,7 +42,7 @@ func createStack(c *config.Config) {
 	// TODO: get hosts from atun.toml and add it to the tags with a loop
 
 	atun := config.Atun{
-		Version: "1",
+		Version: schema.Version,
 		Config:  c,
 	}
 
@@ -70,27 +70,16 @@ func createStack(c *config.Config) {
 	// Set Env
 	tags["atun.io/env"] = atun.Config.Env
 
-	// TODO: Support multiple port configurations per host
-	// Group hosts by their Name and create slices for their configurations
-	hostConfigs := make(map[string]map[string]interface{})
-
-	// Process each host and add it to the final map using the Name as the key
-	for _, host := range atun.Config.Hosts {
-		key := fmt.Sprintf("atun.io/host/%s", host.Name)
-		hostConfig := map[string]interface{}{
-			"proto":  host.Proto,
-			"local":  host.Local,
-			"remote": host.Remote,
-		}
-
-		hostConfigs[key] = hostConfig
+	// Mark the router as created by atun, so it can be told apart from routers managed elsewhere
+	tags["atun.io/ad-hoc"] = "true"
 
+	// Ports of the same host go to one tag
+	hostTags, err := schema.HostTags(atun.Config.Hosts)
+	if err != nil {
+		logger.Fatal("Error building endpoint tags", "error", err)
 	}
-
-	// Marshal each grouped configuration into a JSON string and store in finalMap
-	for key, configs := range hostConfigs {
-		configsJSON, _ := json.Marshal(configs)
-		tags[key] = string(configsJSON)
+	for key, value := range hostTags {
+		tags[key] = value
 	}
 
 	//// Convert struct to JSON
@@ -155,6 +144,12 @@ func createStack(c *config.Config) {
 
 	logger.Debug("Terraform Variables", "variables", terraformVariablesModules)
 
+	if c.RouterType == "ecs" {
+		addECSRouter(stack, c, tags, config.App.Config.RouterSubnetID, !isPrivate)
+		app.Synth()
+		return
+	}
+
 	cdktf.NewTerraformHclModule(stack, jsii.String("router"), &cdktf.TerraformHclModuleConfig{
 		// TODO: Make an abstraction atun-router module so anyone can fork and switch configs
 		Source:  jsii.String("hazelops/ec2-bastion/aws"),
@@ -166,6 +161,24 @@ func createStack(c *config.Config) {
 	app.Synth()
 }
 
+// stackName returns the name of the ad-hoc router stack. Router types get separate stacks, so they can co-exist in one env
+func stackName(c *config.Config) string {
+	if c.RouterType == "" || c.RouterType == "ec2" {
+		return fmt.Sprintf("%s-%s", c.AWSProfile, c.Env)
+	}
+
+	return fmt.Sprintf("%s-%s-%s", c.AWSProfile, c.Env, c.RouterType)
+}
+
+// stateFileName returns the local state file name of the ad-hoc router stack
+func stateFileName(c *config.Config) string {
+	if c.RouterType == "" || c.RouterType == "ec2" {
+		return "terraform.tfstate"
+	}
+
+	return fmt.Sprintf("terraform-%s.tfstate", c.RouterType)
+}
+
 // ApplyCDKTF performs the 'apply' of theCDKTF stack
 func ApplyCDKTF(c *config.Config) error {
 
@@ -183,7 +196,7 @@ func ApplyCDKTF(c *config.Config) error {
 
 	createStack(c)
 	// Change to the synthesized directory
-	synthDir := filepath.Join(c.TunnelDir, "stacks", fmt.Sprintf("%s-%s", c.AWSProfile, c.Env))
+	synthDir := filepath.Join(c.TunnelDir, "stacks", stackName(c))
 	logger.Debug("Synthesized directory", "dir", synthDir)
 
 	// Ensure correct Terraform version is installed
@@ -227,7 +240,7 @@ func ApplyCDKTF(c *config.Config) error {
 func DestroyCDKTF(c *config.Config) error {
 	createStack(c)
 	// Change to the synthesized directory
-	synthDir := filepath.Join(c.TunnelDir, "stacks", fmt.Sprintf("%s-%s", c.AWSProfile, c.Env))
+	synthDir := filepath.Join(c.TunnelDir, "stacks", stackName(c))
 
 	// Ensure correct Terraform version is installed
 	if err := CheckTerraformVersion(); err != nil {
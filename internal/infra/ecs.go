@@ -0,0 +1,151 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package infra
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/DimmKirr/atun/internal/config"
+	"github.com/DimmKirr/atun/internal/logger"
+	"github.com/DimmKirr/atun/internal/ssh"
+	"github.com/aws/jsii-runtime-go"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/ecscluster"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/ecsservice"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/ecstaskdefinition"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/iamrole"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/iamrolepolicy"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/securitygroup"
+	"github.com/hashicorp/terraform-cdk-go/cdktf"
+)
+
+// defaultECSRouterImage is bootstrapped into an SSH router on start. Custom images are expected to run sshd on port 22 themselves
+const defaultECSRouterImage = "public.ecr.aws/docker/library/alpine:3"
+
+// ecsRouterBootstrap installs and starts sshd in the default image. The root account is unlocked for key-only logins,
+// and the public key of the user who created the router is authorized right away.
+const ecsRouterBootstrap = `apk add --no-cache openssh-server && ssh-keygen -A && sed -i 's/^root:!/root:*/' /etc/shadow && mkdir -p /root/.ssh && echo "$ATUN_PUBLIC_KEY" > /root/.ssh/authorized_keys && chmod 700 /root/.ssh && chmod 600 /root/.ssh/authorized_keys && exec /usr/sbin/sshd -D -e -o AllowTcpForwarding=yes -o PermitRootLogin=prohibit-password`
+
+// addECSRouter adds a Fargate router (a single-task ECS service with ECS Exec enabled) to the stack
+func addECSRouter(stack cdktf.TerraformStack, c *config.Config, tags map[string]interface{}, subnetID string, assignPublicIP bool) {
+	name := fmt.Sprintf("%s-%s", c.RouterInstanceName, c.Env)
+
+	resourceTags := map[string]*string{}
+	for key, value := range tags {
+		resourceTags[key] = jsii.String(fmt.Sprintf("%v", value))
+	}
+
+	cluster := ecscluster.NewEcsCluster(stack, jsii.String("cluster"), &ecscluster.EcsClusterConfig{
+		Name: jsii.String(name),
+		Tags: &resourceTags,
+	})
+
+	// Task role allows the ECS Exec agent to open SSM sessions
+	taskRole := iamrole.NewIamRole(stack, jsii.String("task_role"), &iamrole.IamRoleConfig{
+		Name: jsii.String(fmt.Sprintf("%s-task", name)),
+		AssumeRolePolicy: jsii.String(`{
+  "Version": "2012-10-17",
+  "Statement": [{"Effect": "Allow", "Principal": {"Service": "ecs-tasks.amazonaws.com"}, "Action": "sts:AssumeRole"}]
+}`),
+	})
+
+	iamrolepolicy.NewIamRolePolicy(stack, jsii.String("task_role_ecs_exec"), &iamrolepolicy.IamRolePolicyConfig{
+		Name: jsii.String("ecs-exec"),
+		Role: taskRole.Id(),
+		Policy: jsii.String(`{
+  "Version": "2012-10-17",
+  "Statement": [{
+    "Effect": "Allow",
+    "Action": ["ssmmessages:CreateControlChannel", "ssmmessages:CreateDataChannel", "ssmmessages:OpenControlChannel", "ssmmessages:OpenDataChannel"],
+    "Resource": "*"
+  }]
+}`),
+	})
+
+	// No ingress: the router is only reachable through ECS Exec
+	securityGroup := securitygroup.NewSecurityGroup(stack, jsii.String("security_group"), &securitygroup.SecurityGroupConfig{
+		Name:  jsii.String(name),
+		VpcId: jsii.String(c.RouterVPCID),
+		Egress: []*securitygroup.SecurityGroupEgress{
+			{
+				Protocol:   jsii.String("-1"),
+				FromPort:   jsii.Number(0),
+				ToPort:     jsii.Number(0),
+				CidrBlocks: jsii.Strings("0.0.0.0/0"),
+			},
+		},
+		Tags: &resourceTags,
+	})
+
+	containerDefinitions, err := json.Marshal([]map[string]interface{}{ecsRouterContainer(c)})
+	if err != nil {
+		logger.Fatal("Error marshalling ECS container definitions", "error", err)
+	}
+
+	taskDefinition := ecstaskdefinition.NewEcsTaskDefinition(stack, jsii.String("task_definition"), &ecstaskdefinition.EcsTaskDefinitionConfig{
+		Family:                  jsii.String(name),
+		Cpu:                     jsii.String("256"),
+		Memory:                  jsii.String("512"),
+		NetworkMode:             jsii.String("awsvpc"),
+		RequiresCompatibilities: jsii.Strings("FARGATE"),
+		TaskRoleArn:             taskRole.Arn(),
+		ContainerDefinitions:    jsii.String(string(containerDefinitions)),
+		Tags:                    &resourceTags,
+	})
+
+	ecsservice.NewEcsService(stack, jsii.String("service"), &ecsservice.EcsServiceConfig{
+		Name:                 jsii.String(name),
+		Cluster:              cluster.Id(),
+		TaskDefinition:       taskDefinition.Arn(),
+		DesiredCount:         jsii.Number(1),
+		LaunchType:           jsii.String("FARGATE"),
+		EnableExecuteCommand: jsii.Bool(true),
+		PropagateTags:        jsii.String("SERVICE"),
+		WaitForSteadyState:   jsii.Bool(true),
+		NetworkConfiguration: &ecsservice.EcsServiceNetworkConfiguration{
+			Subnets:        jsii.Strings(subnetID),
+			SecurityGroups: &[]*string{securityGroup.Id()},
+			AssignPublicIp: jsii.Bool(assignPublicIP),
+		},
+		Tags: &resourceTags,
+	})
+}
+
+// ecsRouterContainer returns the container definition of the router
+func ecsRouterContainer(c *config.Config) map[string]interface{} {
+	image := c.RouterECSImage
+	if image == "" {
+		image = defaultECSRouterImage
+	}
+
+	container := map[string]interface{}{
+		"name":      "atun-router",
+		"image":     image,
+		"essential": true,
+		// Reaps orphaned session manager processes of ECS Exec
+		"linuxParameters": map[string]interface{}{
+			"initProcessEnabled": true,
+		},
+	}
+
+	if image != defaultECSRouterImage {
+		return container
+	}
+
+	publicKey, err := ssh.GetPublicKey(c.SSHKeyPath)
+	if err != nil {
+		// Not fatal, the key is authorized via ECS Exec on the first `atun up`
+		logger.Debug("Error getting public key. It will be authorized on connect", "error", err)
+	}
+
+	container["command"] = []string{"sh", "-c", ecsRouterBootstrap}
+	container["environment"] = []map[string]string{
+		{"name": "ATUN_PUBLIC_KEY", "value": strings.TrimSpace(publicKey)},
+	}
+
+	return container
+}
@@ -0,0 +1,288 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package k8s
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/DimmKirr/atun/internal/config"
+	"github.com/DimmKirr/atun/internal/logger"
+)
+
+// kubectl is the kubectl binary. Tests point it to a fake one
+var kubectl = "kubectl"
+
+// Workload is a pod or a deployment that acts as a jump host
+type Workload struct {
+	Namespace   string
+	Kind        string
+	Name        string
+	Annotations map[string]string
+	State       string
+	CreatedAt   time.Time
+}
+
+// Target returns the workload reference as accepted by kubectl (e.g. deployment/atun-router)
+func (w Workload) Target() string {
+	return fmt.Sprintf("%s/%s", w.Kind, w.Name)
+}
+
+// ID returns the router ID of the workload (<namespace>.<kind>.<name>).
+// It's used in socket and config file names and as an SSH host alias, so it can't contain slashes.
+func (w Workload) ID() string {
+	return fmt.Sprintf("%s.%s.%s", w.Namespace, w.Kind, w.Name)
+}
+
+// ParseID splits the router ID (<namespace>.<kind>.<name>) into namespace and kubectl target
+func ParseID(id string) (string, string, error) {
+	parts := strings.SplitN(id, ".", 3)
+	if len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+		return "", "", fmt.Errorf("invalid k8s router id %s (expected <namespace>.<kind>.<name>)", id)
+	}
+
+	if parts[1] != "pod" && parts[1] != "deployment" {
+		return "", "", fmt.Errorf("invalid k8s router kind %s (expected pod or deployment)", parts[1])
+	}
+
+	return parts[0], fmt.Sprintf("%s/%s", parts[1], parts[2]), nil
+}
+
+// object is a subset of pod and deployment fields returned by kubectl get -o json
+type object struct {
+	Kind     string `json:"kind"`
+	Metadata struct {
+		Name              string            `json:"name"`
+		Namespace         string            `json:"namespace"`
+		Annotations       map[string]string `json:"annotations"`
+		CreationTimestamp time.Time         `json:"creationTimestamp"`
+		OwnerReferences   []struct {
+			Kind string `json:"kind"`
+			Name string `json:"name"`
+		} `json:"ownerReferences"`
+	} `json:"metadata"`
+	Status struct {
+		Phase             string `json:"phase"`
+		Replicas          int    `json:"replicas"`
+		AvailableReplicas int    `json:"availableReplicas"`
+	} `json:"status"`
+}
+
+func (o object) workload() Workload {
+	w := Workload{
+		Namespace:   o.Metadata.Namespace,
+		Kind:        strings.ToLower(o.Kind),
+		Name:        o.Metadata.Name,
+		Annotations: o.Metadata.Annotations,
+		CreatedAt:   o.Metadata.CreationTimestamp,
+	}
+
+	switch w.Kind {
+	case "pod":
+		w.State = strings.ToLower(o.Status.Phase)
+	case "deployment":
+		w.State = fmt.Sprintf("%d/%d available", o.Status.AvailableReplicas, o.Status.Replicas)
+	}
+
+	return w
+}
+
+// Command builds a kubectl command honoring kubeconfig and context settings
+func Command(args ...string) *exec.Cmd {
+	var kubectlArgs []string
+
+	if config.App.Config.KubeConfig != "" {
+		kubectlArgs = append(kubectlArgs, "--kubeconfig", config.App.Config.KubeConfig)
+	}
+
+	if config.App.Config.KubeContext != "" {
+		kubectlArgs = append(kubectlArgs, "--context", config.App.Config.KubeContext)
+	}
+
+	cmd := exec.Command(kubectl, append(kubectlArgs, args...)...)
+	logger.Debug("Kubectl command", "command", cmd.String())
+
+	return cmd
+}
+
+func run(args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+
+	cmd := Command(args...)
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("kubectl %s failed: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+
+	return output, nil
+}
+
+// ListWorkloadsWithAnnotations returns running pods and deployments that have all the given annotations with one of the accepted values
+func ListWorkloadsWithAnnotations(annotations map[string][]string) ([]Workload, error) {
+	args := []string{"get", "deployments,pods", "-o", "json"}
+	if config.App.Config.KubeNamespace != "" {
+		args = append(args, "--namespace", config.App.Config.KubeNamespace)
+	} else {
+		args = append(args, "--all-namespaces")
+	}
+
+	output, err := run(args...)
+	if err != nil {
+		return nil, err
+	}
+
+	workloads, err := parseWorkloads(output, annotations)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Debug(fmt.Sprintf("Found %d workloads with matching annotations", len(workloads)))
+	return workloads, nil
+}
+
+// parseWorkloads returns workloads of kubectl get -o json output that have all the given annotations
+func parseWorkloads(output []byte, annotations map[string][]string) ([]Workload, error) {
+	var list struct {
+		Items []object `json:"items"`
+	}
+	if err := json.Unmarshal(output, &list); err != nil {
+		return nil, fmt.Errorf("can't parse kubectl output: %w", err)
+	}
+
+	var matching []object
+	for _, item := range list.Items {
+		if hasAnnotations(item.Metadata.Annotations, annotations) {
+			matching = append(matching, item)
+		}
+	}
+
+	// Deployments with matching annotations are used as-is, pods are used if they don't belong to one of them
+	deployments := map[string]bool{}
+	for _, item := range matching {
+		if item.Kind == "Deployment" {
+			deployments[item.Metadata.Namespace+"/"+item.Metadata.Name] = true
+		}
+	}
+
+	var workloads []Workload
+	for _, item := range matching {
+		if item.Kind == "Pod" {
+			if item.Status.Phase != "Running" || ownedByDeployment(item, deployments) {
+				continue
+			}
+		}
+		workloads = append(workloads, item.workload())
+	}
+
+	return workloads, nil
+}
+
+// GetWorkload returns the workload for the router ID
+func GetWorkload(id string) (Workload, error) {
+	namespace, target, err := ParseID(id)
+	if err != nil {
+		return Workload{}, err
+	}
+
+	output, err := run("get", target, "--namespace", namespace, "-o", "json")
+	if err != nil {
+		return Workload{}, err
+	}
+
+	var item object
+	if err := json.Unmarshal(output, &item); err != nil {
+		return Workload{}, fmt.Errorf("can't parse kubectl output: %w", err)
+	}
+
+	return item.workload(), nil
+}
+
+// Exec runs a command in the workload container and returns its stdout
+func Exec(id string, command ...string) (string, error) {
+	namespace, target, err := ParseID(id)
+	if err != nil {
+		return "", err
+	}
+
+	output, err := run(append([]string{"exec", "--namespace", namespace, target, "--"}, command...)...)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
+
+// GetWhoAmI returns the user the workload container runs as
+func GetWhoAmI(id string) (string, error) {
+	return Exec(id, "whoami")
+}
+
+// EnsureSSHPublicKeyPresent adds the public key to authorized_keys of the container user if it's not there yet
+func EnsureSSHPublicKeyPresent(id string, publicKey string) error {
+	publicKey = strings.TrimSpace(publicKey)
+
+	command := fmt.Sprintf(
+		`mkdir -p ~/.ssh && chmod 700 ~/.ssh && (grep -qF "%s" ~/.ssh/authorized_keys 2>/dev/null || echo "%s" >> ~/.ssh/authorized_keys)`,
+		publicKey,
+		publicKey,
+	)
+
+	_, err := Exec(id, "sh", "-c", command)
+	return err
+}
+
+// ConnectToShell opens an interactive shell in the workload container
+func ConnectToShell(id string) error {
+	namespace, target, err := ParseID(id)
+	if err != nil {
+		return err
+	}
+
+	cmd := Command("exec", "-it", "--namespace", namespace, target, "--", "sh", "-c", "command -v bash >/dev/null && exec bash || exec sh")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Stdin = os.Stdin
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to start kubectl exec session: %w", err)
+	}
+
+	return nil
+}
+
+func hasAnnotations(annotations map[string]string, required map[string][]string) bool {
+	for k, values := range required {
+		if !slices.Contains(values, annotations[k]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ownedByDeployment checks if the pod belongs to a replica set of one of the deployments (<deployment>-<hash>)
+func ownedByDeployment(pod object, deployments map[string]bool) bool {
+	for _, owner := range pod.Metadata.OwnerReferences {
+		if owner.Kind != "ReplicaSet" {
+			continue
+		}
+
+		i := strings.LastIndex(owner.Name, "-")
+		if i > 0 && deployments[pod.Metadata.Namespace+"/"+owner.Name[:i]] {
+			return true
+		}
+	}
+
+	return false
+}
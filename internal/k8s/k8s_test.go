@@ -0,0 +1,281 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package k8s
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DimmKirr/atun/internal/config"
+	"github.com/DimmKirr/atun/internal/logger"
+)
+
+func TestMain(m *testing.M) {
+	logger.Initialize("error", true)
+	config.App = &config.Atun{Config: &config.Config{}}
+
+	os.Exit(m.Run())
+}
+
+func TestParseID(t *testing.T) {
+	tests := []struct {
+		id        string
+		namespace string
+		target    string
+		wantErr   bool
+	}{
+		{id: "tools.deployment.atun-router", namespace: "tools", target: "deployment/atun-router"},
+		{id: "default.pod.jump", namespace: "default", target: "pod/jump"},
+		// Names of pods can have dots, only the first two separate the parts
+		{id: "tools.pod.jump.v2", namespace: "tools", target: "pod/jump.v2"},
+		{id: "tools.statefulset.router", wantErr: true},
+		{id: "tools.deployment", wantErr: true},
+		{id: ".pod.jump", wantErr: true},
+		{id: "tools.pod.", wantErr: true},
+		{id: "i-0123456789abcdef0", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			namespace, target, err := ParseID(tt.id)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseID(%q) = %q, %q, want an error", tt.id, namespace, target)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseID(%q) returned an error: %v", tt.id, err)
+			}
+			if namespace != tt.namespace || target != tt.target {
+				t.Errorf("ParseID(%q) = %q, %q, want %q, %q", tt.id, namespace, target, tt.namespace, tt.target)
+			}
+		})
+	}
+}
+
+func TestWorkloadIDRoundTrip(t *testing.T) {
+	w := Workload{Namespace: "tools", Kind: "deployment", Name: "atun-router"}
+
+	namespace, target, err := ParseID(w.ID())
+	if err != nil {
+		t.Fatalf("ParseID(%q) returned an error: %v", w.ID(), err)
+	}
+	if namespace != w.Namespace || target != w.Target() {
+		t.Errorf("ParseID(%q) = %q, %q, want %q, %q", w.ID(), namespace, target, w.Namespace, w.Target())
+	}
+}
+
+func TestOwnedByDeployment(t *testing.T) {
+	deployments := map[string]bool{"tools/atun-router": true}
+
+	tests := []struct {
+		name      string
+		namespace string
+		ownerKind string
+		ownerName string
+		want      bool
+	}{
+		{name: "replica set of the deployment", namespace: "tools", ownerKind: "ReplicaSet", ownerName: "atun-router-5d8f7c9b6", want: true},
+		{name: "replica set of another deployment", namespace: "tools", ownerKind: "ReplicaSet", ownerName: "atun-router-v2-5d8f7c9b6", want: false},
+		{name: "deployment in another namespace", namespace: "default", ownerKind: "ReplicaSet", ownerName: "atun-router-5d8f7c9b6", want: false},
+		{name: "owned by a job", namespace: "tools", ownerKind: "Job", ownerName: "atun-router-5d8f7c9b6", want: false},
+		{name: "replica set without a hash", namespace: "tools", ownerKind: "ReplicaSet", ownerName: "atun-router", want: false},
+		{name: "bare pod", namespace: "tools", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var pod object
+			pod.Kind = "Pod"
+			pod.Metadata.Namespace = tt.namespace
+			if tt.ownerKind != "" {
+				pod.Metadata.OwnerReferences = append(pod.Metadata.OwnerReferences, struct {
+					Kind string `json:"kind"`
+					Name string `json:"name"`
+				}{Kind: tt.ownerKind, Name: tt.ownerName})
+			}
+
+			if got := ownedByDeployment(pod, deployments); got != tt.want {
+				t.Errorf("ownedByDeployment() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHasAnnotations(t *testing.T) {
+	required := map[string][]string{
+		"atun.io/version": {"1", "2"},
+		"atun.io/env":     {"dev"},
+	}
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        bool
+	}{
+		{name: "v1", annotations: map[string]string{"atun.io/version": "1", "atun.io/env": "dev"}, want: true},
+		{name: "v2 with other annotations", annotations: map[string]string{"atun.io/version": "2", "atun.io/env": "dev", "team": "data"}, want: true},
+		{name: "other env", annotations: map[string]string{"atun.io/version": "2", "atun.io/env": "prod"}, want: false},
+		{name: "unsupported version", annotations: map[string]string{"atun.io/version": "3", "atun.io/env": "dev"}, want: false},
+		{name: "no version", annotations: map[string]string{"atun.io/env": "dev"}, want: false},
+		{name: "no annotations", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hasAnnotations(tt.annotations, required); got != tt.want {
+				t.Errorf("hasAnnotations() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseWorkloads(t *testing.T) {
+	output := []byte(`{"items": [
+  {"kind": "Deployment", "metadata": {"name": "atun-router", "namespace": "tools", "annotations": {"atun.io/version": "2", "atun.io/env": "dev"}},
+   "status": {"replicas": 2, "availableReplicas": 1}},
+  {"kind": "Pod", "metadata": {"name": "atun-router-5d8f7c9b6-abcde", "namespace": "tools", "annotations": {"atun.io/version": "2", "atun.io/env": "dev"},
+   "ownerReferences": [{"kind": "ReplicaSet", "name": "atun-router-5d8f7c9b6"}]}, "status": {"phase": "Running"}},
+  {"kind": "Pod", "metadata": {"name": "jump", "namespace": "default", "annotations": {"atun.io/version": "1", "atun.io/env": "dev"}}, "status": {"phase": "Running"}},
+  {"kind": "Pod", "metadata": {"name": "jump-pending", "namespace": "default", "annotations": {"atun.io/version": "1", "atun.io/env": "dev"}}, "status": {"phase": "Pending"}},
+  {"kind": "Pod", "metadata": {"name": "jump-prod", "namespace": "default", "annotations": {"atun.io/version": "1", "atun.io/env": "prod"}}, "status": {"phase": "Running"}},
+  {"kind": "Pod", "metadata": {"name": "web", "namespace": "default"}, "status": {"phase": "Running"}}
+]}`)
+
+	workloads, err := parseWorkloads(output, map[string][]string{
+		"atun.io/version": {"1", "2"},
+		"atun.io/env":     {"dev"},
+	})
+	if err != nil {
+		t.Fatalf("parseWorkloads() returned an error: %v", err)
+	}
+
+	var got []string
+	for _, w := range workloads {
+		got = append(got, fmt.Sprintf("%s (%s)", w.ID(), w.State))
+	}
+
+	want := []string{"tools.deployment.atun-router (1/2 available)", "default.pod.jump (running)"}
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Errorf("parseWorkloads() = %v, want %v", got, want)
+	}
+
+	if _, err := parseWorkloads([]byte("error: the server doesn't have a resource type"), nil); err == nil {
+		t.Error("parseWorkloads() of invalid output should return an error")
+	}
+}
+
+// fakeKubectl points kubectl to a script that records its arguments and runs body
+func fakeKubectl(t *testing.T, body string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	argsFile := filepath.Join(dir, "args")
+	script := fmt.Sprintf("#!/bin/sh\necho \"$@\" > %s\n%s\n", argsFile, body)
+	path := filepath.Join(dir, "kubectl")
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	previous := kubectl
+	kubectl = path
+	t.Cleanup(func() { kubectl = previous })
+
+	return argsFile
+}
+
+func TestProxy(t *testing.T) {
+	// sshd of the router, it echoes what it gets
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.Copy(conn, conn)
+	}()
+
+	argsFile := fakeKubectl(t, fmt.Sprintf("echo 'Forwarding from %s -> 22'\necho 'Forwarding from [::1]:1 -> 22'\nexec sleep 60", listener.Addr()))
+
+	var out bytes.Buffer
+	if err := Proxy("tools.deployment.atun-router", 22, strings.NewReader("SSH-2.0-OpenSSH_9.6\r\n"), &out); err != nil {
+		t.Fatalf("Proxy() returned an error: %v", err)
+	}
+
+	if got := out.String(); got != "SSH-2.0-OpenSSH_9.6\r\n" {
+		t.Errorf("Proxy() got %q back from the router", got)
+	}
+
+	args, err := os.ReadFile(argsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "port-forward --namespace tools --address 127.0.0.1 deployment/atun-router :22"; strings.TrimSpace(string(args)) != want {
+		t.Errorf("kubectl args = %q, want %q", strings.TrimSpace(string(args)), want)
+	}
+}
+
+func TestPortForwardFails(t *testing.T) {
+	fakeKubectl(t, `echo 'error: unable to forward port because pod is not running. Current status=Pending' >&2; exit 1`)
+
+	_, _, err := PortForward("tools.pod.jump", 22)
+	if err == nil || !strings.Contains(err.Error(), "pod is not running") {
+		t.Errorf("PortForward() error = %v, want the kubectl error", err)
+	}
+}
+
+func TestPortForwardTimeout(t *testing.T) {
+	fakeKubectl(t, "exec sleep 60")
+
+	previous := portForwardTimeout
+	portForwardTimeout = 100 * time.Millisecond
+	t.Cleanup(func() { portForwardTimeout = previous })
+
+	if _, _, err := PortForward("tools.pod.jump", 22); err == nil {
+		t.Error("PortForward() should fail if kubectl is never ready")
+	}
+}
+
+func TestProxyCommand(t *testing.T) {
+	config.App.Config.KubeConfig = "/home/jane doe/.kube/config"
+	config.App.Config.KubeContext = "staging"
+	t.Cleanup(func() {
+		config.App.Config.KubeConfig = ""
+		config.App.Config.KubeContext = ""
+	})
+
+	command, err := ProxyCommand("tools.deployment.atun-router")
+	if err != nil {
+		t.Fatalf("ProxyCommand() returned an error: %v", err)
+	}
+
+	for _, want := range []string{
+		"env ATUN_KUBE_CONFIG='/home/jane doe/.kube/config' ",
+		" router k8s-proxy --log-level error --kube-context staging tools.deployment.atun-router %p",
+	} {
+		if !strings.Contains(command, want) {
+			t.Errorf("ProxyCommand() = %q, want it to contain %q", command, want)
+		}
+	}
+
+	if _, err := ProxyCommand("tools.statefulset.router"); err == nil {
+		t.Error("ProxyCommand() of an invalid router id should return an error")
+	}
+}
@@ -0,0 +1,152 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package k8s
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DimmKirr/atun/internal/config"
+	"github.com/DimmKirr/atun/internal/logger"
+)
+
+// portForwardTimeout is how long kubectl port-forward has to get ready
+var portForwardTimeout = 30 * time.Second
+
+// forwardingPattern matches the line kubectl port-forward prints once it's ready: Forwarding from 127.0.0.1:54321 -> 22
+var forwardingPattern = regexp.MustCompile(`^Forwarding from (127\.0\.0\.1:[0-9]+) -> [0-9]+`)
+
+// ProxyCommand returns the SSH ProxyCommand that reaches sshd of the workload through the API server.
+// It runs atun itself (atun router k8s-proxy), which bridges SSH to kubectl port-forward, so no nc is needed locally or in the pod
+func ProxyCommand(id string) (string, error) {
+	if _, _, err := ParseID(id); err != nil {
+		return "", err
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("can't find atun executable: %w", err)
+	}
+
+	command := []string{"env"}
+	if config.App.Config.KubeConfig != "" {
+		command = append(command, "ATUN_KUBE_CONFIG="+shellQuote(config.App.Config.KubeConfig))
+	}
+	command = append(command, shellQuote(executable), "router", "k8s-proxy", "--log-level", "error")
+	if config.App.Config.KubeContext != "" {
+		command = append(command, "--kube-context", shellQuote(config.App.Config.KubeContext))
+	}
+
+	return strings.Join(append(command, id, "%p"), " "), nil
+}
+
+// PortForward forwards a free local port to the port of the workload with kubectl port-forward (through the API server).
+// It returns the local address once kubectl is ready. Killing the returned command stops the forward
+func PortForward(id string, port int) (string, *exec.Cmd, error) {
+	namespace, target, err := ParseID(id)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var stderr bytes.Buffer
+	cmd := Command("port-forward", "--namespace", namespace, "--address", "127.0.0.1", target, fmt.Sprintf(":%d", port))
+	cmd.Stderr = &stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return "", nil, fmt.Errorf("can't start kubectl port-forward: %w", err)
+	}
+
+	ready := make(chan string, 1)
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			if match := forwardingPattern.FindStringSubmatch(scanner.Text()); match != nil {
+				ready <- match[1]
+				break
+			}
+		}
+		close(ready)
+
+		// kubectl logs every connection, the pipe has to be drained so it doesn't block
+		_, _ = io.Copy(io.Discard, stdout)
+	}()
+
+	select {
+	case address, ok := <-ready:
+		if ok {
+			logger.Debug("Port forward is ready", "router", id, "address", address, "port", port)
+			return address, cmd, nil
+		}
+		_ = cmd.Wait()
+		return "", nil, fmt.Errorf("kubectl port-forward to %s failed: %s", id, strings.TrimSpace(stderr.String()))
+	case <-time.After(portForwardTimeout):
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return "", nil, fmt.Errorf("kubectl port-forward to %s wasn't ready in %s", id, portForwardTimeout)
+	}
+}
+
+// Proxy connects in and out (stdin and stdout of the SSH ProxyCommand) to the port of the workload until either side closes
+func Proxy(id string, port int, in io.Reader, out io.Writer) error {
+	address, cmd, err := PortForward(id, port)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	}()
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		return fmt.Errorf("can't connect to port forward of %s: %w", id, err)
+	}
+	defer conn.Close()
+
+	var once sync.Once
+	done := make(chan error, 2)
+	finish := func(err error) { once.Do(func() { done <- err }) }
+
+	go func() {
+		_, err := io.Copy(conn, in)
+		// SSH closed its side, let the router finish sending
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			_ = tcpConn.CloseWrite()
+		}
+		if err != nil {
+			finish(err)
+		}
+	}()
+	go func() {
+		_, err := io.Copy(out, conn)
+		finish(err)
+	}()
+
+	return <-done
+}
+
+// shellQuote single-quotes s for sh if it has characters other than safe ones
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./:=@%+,") == "" {
+		return s
+	}
+
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
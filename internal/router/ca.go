@@ -0,0 +1,68 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package router
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/DimmKirr/atun/internal/aws"
+	"github.com/DimmKirr/atun/internal/config"
+	"github.com/DimmKirr/atun/internal/ssh"
+	ssh2 "golang.org/x/crypto/ssh"
+)
+
+// LoadCA reads the user CA key from ssh_ca_key: a local file, an SSM parameter (ssm:<name>) or a Secrets Manager secret (secretsmanager:<name>)
+func LoadCA(app *config.Atun) (ssh2.Signer, error) {
+	ref := app.Config.SSHCAKey
+	if ref == "" {
+		return nil, fmt.Errorf("ssh_ca_key is required with ssh_auth = %q", ssh.AuthCA)
+	}
+
+	var data []byte
+	var err error
+	if aws.IsSecretRef(ref) {
+		data, err = aws.GetSecret(ref)
+	} else {
+		if rest, ok := strings.CutPrefix(ref, "~/"); ok {
+			homeDir, _ := os.UserHomeDir()
+			ref = filepath.Join(homeDir, rest)
+		}
+		data, err = os.ReadFile(ref)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't read SSH CA key: %w", err)
+	}
+
+	return ssh.ParseCAKey(data)
+}
+
+// CAPublicKey returns the public key of the user CA in the authorized_keys format
+func CAPublicKey(app *config.Atun) (string, error) {
+	ca, err := LoadCA(app)
+	if err != nil {
+		return "", err
+	}
+
+	return string(ssh2.MarshalAuthorizedKey(ca.PublicKey())), nil
+}
+
+// signSessionCertificate signs the session key of app.Config.RouterHostID with the user CA
+func signSessionCertificate(app *config.Atun) error {
+	if _, err := ssh.EnsureSessionKey(app); err != nil {
+		return err
+	}
+
+	ca, err := LoadCA(app)
+	if err != nil {
+		return err
+	}
+
+	_, err = ssh.SignSessionCertificate(app, ca)
+	return err
+}
@@ -0,0 +1,260 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package router
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/DimmKirr/atun/internal/aws"
+	"github.com/DimmKirr/atun/internal/config"
+	"github.com/DimmKirr/atun/internal/constraints"
+	"github.com/DimmKirr/atun/internal/infra"
+	"github.com/DimmKirr/atun/internal/logger"
+	"github.com/DimmKirr/atun/internal/ssh"
+	"github.com/DimmKirr/atun/internal/tunnel"
+	awssdk "github.com/aws/aws-sdk-go/aws"
+)
+
+// EC2 is a router backed by an EC2 instance with atun.io tags, connected via SSH over SSM
+type EC2 struct{}
+
+func init() {
+	Register("ec2", func() Router { return &EC2{} })
+}
+
+func (r *EC2) Type() string {
+	return "ec2"
+}
+
+func (r *EC2) RequiresAWS() bool {
+	return true
+}
+
+func (r *EC2) Discover() ([]string, error) {
+	return tunnel.GetRouterHostIDsFromTags()
+}
+
+// List returns EC2 routers of the env in any state but terminated, e.g. stopped ones too
+func (r *EC2) List() ([]string, error) {
+	return tunnel.ListRouterHostIDsFromTags()
+}
+
+func (r *EC2) Describe(routerID string) (config.RouterInfo, error) {
+	instance, err := aws.DescribeInstance(routerID)
+	if err != nil {
+		return config.RouterInfo{}, err
+	}
+
+	info := config.RouterInfo{
+		ID:           routerID,
+		Type:         r.Type(),
+		Account:      aws.InstanceAccount(routerID),
+		Region:       aws.InstanceRegion(routerID),
+		State:        awssdk.StringValue(instance.State.Name),
+		InstanceType: awssdk.StringValue(instance.InstanceType),
+		CreatedAt:    awssdk.TimeValue(instance.LaunchTime),
+	}
+
+	if instance.Placement != nil {
+		info.Zone = awssdk.StringValue(instance.Placement.AvailabilityZone)
+	}
+	info.VPCID = awssdk.StringValue(instance.VpcId)
+	info.SubnetID = awssdk.StringValue(instance.SubnetId)
+
+	for _, tag := range instance.Tags {
+		key := awssdk.StringValue(tag.Key)
+		switch {
+		case key == "Name":
+			info.Name = awssdk.StringValue(tag.Value)
+		case key == "atun.io/env":
+			info.Env = awssdk.StringValue(tag.Value)
+		case key == "atun.io/ad-hoc":
+			info.AdHoc = awssdk.StringValue(tag.Value) == "true"
+		case strings.HasPrefix(key, "atun.io/host/"):
+			info.Endpoints++
+		}
+	}
+
+	// A router not registered in SSM is still listed, just without the agent details
+	ssmInformation, err := aws.GetSSMInstanceInformation([]string{routerID})
+	if err != nil {
+		logger.Debug("Can't get SSM status of router", "router", routerID, "error", err)
+	}
+	info.PingStatus = "Unknown"
+	if ssmInfo, ok := ssmInformation[routerID]; ok {
+		info.PingStatus = awssdk.StringValue(ssmInfo.PingStatus)
+		info.AgentVersion = awssdk.StringValue(ssmInfo.AgentVersion)
+	}
+
+	return info, nil
+}
+
+func (r *EC2) Endpoints(routerID string) (config.Atun, error) {
+	return tunnel.GetRouterHostConfig(routerID)
+}
+
+// Connect starts the tunnel. If the tunnel can't be started it authorizes the local SSH key on the router and retries.
+// The key is appended to authorized_keys via SSM, or pushed with EC2 Instance Connect first if enabled (SSH_KEY_PUSH=eic).
+func (r *EC2) Connect(app *config.Atun) (bool, []ssh.Endpoint, error) {
+	var err error
+
+	// The router can be in an account or region other than the profile's one when discovered in several of them
+	if err := aws.UseRouterSession(app, app.Config.RouterHostID); err != nil {
+		return false, nil, err
+	}
+
+	if _, err := ssh.EnsureSessionKey(app); err != nil {
+		return false, nil, err
+	}
+
+	// Generate SSH config file
+	app.Config.SSHConfigFile, err = ssh.GenerateSSHConfigFile(app)
+	if err != nil {
+		return false, nil, fmt.Errorf("error generating SSH config file: %w", err)
+	}
+	logger.Debug("SSH Config generated", "path", app.Config.SSHConfigFile)
+
+	ensureHostKeysPinned(app)
+
+	// With a user CA the router accepts the certificate, no key is pushed
+	if ssh.UsesCA(app) {
+		if err := signSessionCertificate(app); err != nil {
+			return false, nil, err
+		}
+
+		tunnelActive, connections, err := activateTunnel(app)
+		if err != nil {
+			return false, nil, fmt.Errorf("router %s didn't accept the certificate (does it trust the CA? Run atun router trust-ca): %w", app.Config.RouterHostID, err)
+		}
+
+		return tunnelActive, connections, nil
+	}
+
+	if app.Config.RouterTransport == ssh.TransportEICE {
+		return r.connectViaEICE(app)
+	}
+
+	// Try to start a tunnel before pushing the SSH key (to save on time spent on SSM)
+	tunnelActive, connections, err := activateTunnel(app)
+	if err == nil {
+		return tunnelActive, connections, nil
+	}
+	logger.Debug("SSH key doesn't seem to be present on the router host", "error", err)
+
+	publicKey, err := ssh.GetSessionPublicKey(app)
+	if err != nil {
+		return false, nil, fmt.Errorf("error getting public key: %w", err)
+	}
+	logger.Debug("Public key", "key", publicKey)
+
+	// Push a short-lived key with EC2 Instance Connect first, it doesn't leave anything on the router
+	if app.Config.SSHKeyPush == aws.KeyPushEIC {
+		if err := aws.SendSSHPublicKey(app.Config.RouterHostID, app.Config.RouterHostUser, publicKey); err != nil {
+			logger.Debug("EC2 Instance Connect is not available. Falling back to SSM", "error", err)
+		} else {
+			tunnelActive, connections, err = activateTunnel(app)
+			if err == nil {
+				return tunnelActive, connections, nil
+			}
+			// SendSSHPublicKey succeeds even if the instance doesn't run ec2-instance-connect
+			logger.Debug("Router host didn't accept the key pushed with EC2 Instance Connect. Falling back to SSM", "error", err)
+		}
+	}
+
+	logger.Debug("Ensuring local SSH key is authorized on router...", "SSHPublicKeyPath", app.Config.SSHKeyPath, "RouterHostID", app.Config.RouterHostID)
+
+	// Send the public key to the router instance
+	if err := aws.EnsureSSHPublicKeyPresent(app.Config.RouterHostID, publicKey, app.Config.RouterHostUser); err != nil {
+		return false, nil, fmt.Errorf("failed to add local SSH public key to the instance %s: %w", app.Config.RouterHostID, err)
+	}
+	logger.Debug("Public key added to router host ~/.ssh/authorized_keys", "RouterHostID", app.Config.RouterHostID)
+
+	// Retry starting the tunnel after the key is added
+	return activateTunnel(app)
+}
+
+// connectViaEICE pushes the local SSH key with EC2 Instance Connect (it's valid for 60 seconds) and starts the tunnel through an EC2 Instance Connect Endpoint.
+// The key is pushed on every connect since it can't be checked in advance and SSM may not be reachable in the VPC.
+func (r *EC2) connectViaEICE(app *config.Atun) (bool, []ssh.Endpoint, error) {
+	publicKey, err := ssh.GetSessionPublicKey(app)
+	if err != nil {
+		return false, nil, fmt.Errorf("error getting public key: %w", err)
+	}
+
+	if err := aws.SendSSHPublicKey(app.Config.RouterHostID, app.Config.RouterHostUser, publicKey); err != nil {
+		return false, nil, fmt.Errorf("failed to push local SSH public key to the instance %s: %w", app.Config.RouterHostID, err)
+	}
+
+	return activateTunnel(app)
+}
+
+func (r *EC2) Disconnect(app *config.Atun) (bool, error) {
+	return tunnel.DeactivateTunnel(app)
+}
+
+// RevokeKey removes the key from authorized_keys via SSM. Keys pushed with EC2 Instance Connect expire on their own
+func (r *EC2) RevokeKey(app *config.Atun, publicKey string) error {
+	if app.Config.RouterTransport == ssh.TransportEICE {
+		return nil
+	}
+
+	return aws.RemoveSSHPublicKey(app.Config.RouterHostID, publicKey, app.Config.RouterHostUser)
+}
+
+func (r *EC2) Status(app *config.Atun) (bool, []ssh.Endpoint, error) {
+	return ssh.GetSSHTunnelStatus(app)
+}
+
+func (r *EC2) Shell(routerID string) error {
+	if err := constraints.CheckConstraints(
+		constraints.WithAWSProfile(),
+		constraints.WithAWSCLI(),
+		constraints.WithSSMPlugin(),
+	); err != nil {
+		return err
+	}
+
+	if err := aws.UseRouterSession(config.App, routerID); err != nil {
+		return err
+	}
+
+	return aws.ConnectToSSMConsole(routerID)
+}
+
+// Create applies the ad-hoc router CDKTF stack and waits until the instance accepts SSM connections
+func (r *EC2) Create(app *config.Atun) (string, error) {
+	if err := infra.ApplyCDKTF(app.Config); err != nil {
+		return "", fmt.Errorf("error running CDKTF: %w", err)
+	}
+
+	routerID, err := tunnel.GetRouterHostIDFromTags()
+	if err != nil {
+		return "", fmt.Errorf("error discovering router host: %w", err)
+	}
+
+	// Wait until the instance is ready to accept SSM connections
+	if err := aws.WaitForInstanceReady(routerID); err != nil {
+		return routerID, fmt.Errorf("instance %s is still not ready: %w", routerID, err)
+	}
+
+	if ssh.UsesCA(app) {
+		caPublicKey, err := CAPublicKey(app)
+		if err != nil {
+			return routerID, err
+		}
+
+		if err := aws.TrustSSHCA(routerID, caPublicKey); err != nil {
+			return routerID, err
+		}
+	}
+
+	return routerID, nil
+}
+
+func (r *EC2) Delete(app *config.Atun) error {
+	return infra.DestroyCDKTF(app.Config)
+}
@@ -0,0 +1,190 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package router
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/DimmKirr/atun/internal/aws"
+	"github.com/DimmKirr/atun/internal/config"
+	"github.com/DimmKirr/atun/internal/constraints"
+	"github.com/DimmKirr/atun/internal/infra"
+	"github.com/DimmKirr/atun/internal/logger"
+	"github.com/DimmKirr/atun/internal/schema"
+	"github.com/DimmKirr/atun/internal/ssh"
+	"github.com/DimmKirr/atun/internal/tunnel"
+)
+
+// ECS is a router backed by an ECS task (a container running sshd) with atun.io tags on the task, service or task definition.
+// SSH is carried over an SSM session to the container opened by ECS Exec, so the task needs no ingress.
+type ECS struct{}
+
+func init() {
+	Register("ecs", func() Router { return &ECS{} })
+}
+
+func (r *ECS) Type() string {
+	return "ecs"
+}
+
+func (r *ECS) RequiresAWS() bool {
+	return true
+}
+
+func (r *ECS) Discover() ([]string, error) {
+	tasks, err := aws.ListECSTasksWithTags(r.discoveryTags())
+	if err != nil {
+		return nil, err
+	}
+
+	var routerIDs []string
+	for _, task := range tasks {
+		logger.Debug("Found ECS task", "id", task.RouterID(), "state", task.State, "execEnabled", task.ExecEnabled)
+		if !task.ExecEnabled {
+			logger.Warn("ECS task matches atun.io tags but ECS Exec is not enabled or its agent is not running. Skipping", "cluster", task.Cluster, "task", task.ID)
+			continue
+		}
+
+		routerIDs = append(routerIDs, task.RouterID())
+	}
+
+	if len(routerIDs) == 0 {
+		return nil, fmt.Errorf("no ECS tasks with ECS Exec enabled found with required tags")
+	}
+
+	return routerIDs, nil
+}
+
+func (r *ECS) Describe(routerID string) (config.RouterInfo, error) {
+	task, err := aws.GetECSTask(routerID)
+	if err != nil {
+		return config.RouterInfo{}, err
+	}
+
+	return config.RouterInfo{
+		ID:        routerID,
+		Type:      r.Type(),
+		Name:      task.Service,
+		State:     strings.ToLower(task.State),
+		Env:       task.Tags["atun.io/env"],
+		Endpoints: tunnel.CountHostTags(task.Tags),
+		AdHoc:     task.Tags["atun.io/ad-hoc"] == "true",
+		CreatedAt: task.CreatedAt,
+	}, nil
+}
+
+func (r *ECS) Endpoints(routerID string) (config.Atun, error) {
+	task, err := aws.GetECSTask(routerID)
+	if err != nil {
+		return config.Atun{}, err
+	}
+
+	logger.Debug("Task tags", "tags", task.Tags)
+
+	atun, err := tunnel.GetRouterConfigFromTags(task.Tags)
+	if err != nil {
+		return config.Atun{}, err
+	}
+
+	// ECS Exec runs commands as the container user, which is the one sshd authorizes keys for
+	atun.Config.RouterHostUser = config.App.Config.RouterHostUser
+	if atun.Config.RouterHostUser == "" {
+		atun.Config.RouterHostUser, err = aws.GetECSWhoAmI(routerID)
+		if err != nil {
+			return config.Atun{}, fmt.Errorf("error getting container user of %s: %w", routerID, err)
+		}
+	}
+
+	return atun, nil
+}
+
+// Connect starts the tunnel via sshd of the router container. If the tunnel can't be started it authorizes the local SSH key via ECS Exec and retries
+func (r *ECS) Connect(app *config.Atun) (bool, []ssh.Endpoint, error) {
+	var err error
+
+	if _, err := ssh.EnsureSessionKey(app); err != nil {
+		return false, nil, err
+	}
+
+	app.Config.SSHConfigFile, err = ssh.WriteSSHConfigFile(app, fmt.Sprintf(`# SSH over ECS Exec (generated by atun.io)
+host %s
+ServerAliveInterval 180
+ProxyCommand sh -c "aws ssm start-session --target %s --document-name AWS-StartSSHSession --parameters 'portNumber=%%p'"
+`, app.Config.RouterHostID, aws.ECSTarget(app.Config.RouterHostID)))
+	if err != nil {
+		return false, nil, fmt.Errorf("error generating SSH config file: %w", err)
+	}
+	logger.Debug("SSH Config generated", "path", app.Config.SSHConfigFile)
+
+	tunnelActive, connections, err := tunnel.ActivateTunnel(app)
+	if err == nil {
+		return tunnelActive, connections, nil
+	}
+	logger.Debug("SSH key doesn't seem to be present in the router container", "error", err)
+
+	publicKey, err := ssh.GetSessionPublicKey(app)
+	if err != nil {
+		return false, nil, fmt.Errorf("error getting public key: %w", err)
+	}
+
+	if err := aws.EnsureECSSSHPublicKeyPresent(app.Config.RouterHostID, publicKey); err != nil {
+		return false, nil, fmt.Errorf("failed to add local SSH public key to %s: %w", app.Config.RouterHostID, err)
+	}
+	logger.Debug("Public key added to ~/.ssh/authorized_keys", "RouterHostID", app.Config.RouterHostID)
+
+	return tunnel.ActivateTunnel(app)
+}
+
+func (r *ECS) Disconnect(app *config.Atun) (bool, error) {
+	return tunnel.DeactivateTunnel(app)
+}
+
+func (r *ECS) RevokeKey(app *config.Atun, publicKey string) error {
+	_, err := aws.ExecECSCommand(app.Config.RouterHostID, ssh.RevokeKeyCommand("~/.ssh/authorized_keys", publicKey))
+	return err
+}
+
+func (r *ECS) Status(app *config.Atun) (bool, []ssh.Endpoint, error) {
+	return ssh.GetSSHTunnelStatus(app)
+}
+
+func (r *ECS) Shell(routerID string) error {
+	if err := constraints.CheckConstraints(
+		constraints.WithAWSProfile(),
+		constraints.WithAWSCLI(),
+		constraints.WithSSMPlugin(),
+	); err != nil {
+		return err
+	}
+
+	return aws.ConnectToECSShell(routerID)
+}
+
+// Create applies the ad-hoc Fargate router CDKTF stack and waits until ECS Exec accepts connections
+func (r *ECS) Create(app *config.Atun) (string, error) {
+	if err := infra.ApplyCDKTF(app.Config); err != nil {
+		return "", fmt.Errorf("error running CDKTF: %w", err)
+	}
+
+	routerID, err := aws.WaitForECSTaskReady(r.discoveryTags())
+	if err != nil {
+		return "", fmt.Errorf("ECS router is still not ready: %w", err)
+	}
+
+	return routerID, nil
+}
+
+func (r *ECS) Delete(app *config.Atun) error {
+	return infra.DestroyCDKTF(app.Config)
+}
+
+func (r *ECS) discoveryTags() map[string][]string {
+	return map[string][]string{
+		"atun.io/version": schema.Versions,
+		"atun.io/env":     {config.App.Config.Env},
+	}
+}
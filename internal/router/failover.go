@@ -0,0 +1,90 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package router
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/DimmKirr/atun/internal/config"
+	"github.com/DimmKirr/atun/internal/constraints"
+	"github.com/DimmKirr/atun/internal/logger"
+	"github.com/DimmKirr/atun/internal/ssh"
+)
+
+// ConnectWithFailover connects to the first router of routerIDs (ordered from the preferred one) that accepts the tunnel.
+// Endpoints config is read from each router before connecting, so app ends up configured for the router that was connected to.
+func ConnectWithFailover(r Router, app *config.Atun, routerIDs []string) (bool, []ssh.Endpoint, error) {
+	if len(routerIDs) == 0 {
+		return false, nil, fmt.Errorf("no %s routers to connect to", r.Type())
+	}
+
+	// Transport set with a flag or env var takes precedence over the router tag
+	transport := app.Config.RouterTransport
+
+	var errs []error
+	for i, routerID := range routerIDs {
+		app.Config.RouterHostID = routerID
+		app.Config.RouterTransport = transport
+
+		tunnelActive, connections, err := connect(r, app)
+		if err == nil {
+			if i > 0 {
+				logger.Warn("Failed over to another router", "router", routerID, "failedRouters", routerIDs[:i])
+			}
+			return tunnelActive, connections, nil
+		}
+
+		errs = append(errs, fmt.Errorf("%s: %w", routerID, err))
+
+		if i < len(routerIDs)-1 {
+			logger.Warn("Can't connect to router. Failing over to the next one", "router", routerID, "next", routerIDs[i+1], "error", err)
+		}
+
+		// Don't leave a half-open tunnel (e.g. a running SSM session) behind
+		if _, err := r.Disconnect(app); err != nil {
+			logger.Debug("Error cleaning up tunnel of the failed router", "router", routerID, "error", err)
+		}
+	}
+
+	return false, nil, fmt.Errorf("can't connect to any of %d %s routers: %w", len(routerIDs), r.Type(), errors.Join(errs...))
+}
+
+// connect reads endpoints config of app.Config.RouterHostID into app and connects to it
+func connect(r Router, app *config.Atun) (bool, []ssh.Endpoint, error) {
+	routerConfig, err := r.Endpoints(app.Config.RouterHostID)
+	if err != nil {
+		return false, nil, fmt.Errorf("error getting router endpoints config: %w", err)
+	}
+
+	app.Version = routerConfig.Version
+	app.Config.Hosts = routerConfig.Config.Hosts
+	app.Config.SkippedHosts = routerConfig.Config.SkippedHosts
+	app.Config.RouterHostUser = routerConfig.Config.RouterHostUser
+	if app.Config.RouterTransport == "" {
+		app.Config.RouterTransport = routerConfig.Config.RouterTransport
+	}
+
+	for _, host := range app.Config.Hosts {
+		logger.Debug("Endpoint", "name", host.Name, "proto", host.Proto, "remote", host.Remote, "local", host.Local)
+	}
+	logger.Debug("Router transport", "router", app.Config.RouterHostID, "transport", app.Config.RouterTransport)
+
+	// The transport can come from the router tag, so the Session Manager plugin is checked once it's known
+	if requiresSSMPlugin(r, app) {
+		if err := constraints.CheckConstraints(constraints.WithSSMPlugin()); err != nil {
+			return false, nil, err
+		}
+	}
+
+	return r.Connect(app)
+}
+
+// requiresSSMPlugin reports whether the tunnel to the router needs the Session Manager plugin locally.
+// It doesn't when the tunnel goes through an EC2 Instance Connect Endpoint or directly to a bastion
+func requiresSSMPlugin(r Router, app *config.Atun) bool {
+	return r.RequiresAWS() && r.Type() != "ssh" && app.Config.RouterTransport != ssh.TransportEICE
+}
@@ -0,0 +1,105 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package router
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/DimmKirr/atun/internal/aws"
+	"github.com/DimmKirr/atun/internal/config"
+	"github.com/DimmKirr/atun/internal/logger"
+	"github.com/DimmKirr/atun/internal/ssh"
+	"github.com/DimmKirr/atun/internal/tunnel"
+	ssh2 "golang.org/x/crypto/ssh"
+)
+
+// fetchHostKeys reads host keys of the EC2 router out of band: over SSM, or from the console output if SSM isn't available
+// (always with the EC2 Instance Connect Endpoint transport, where SSM may not be reachable)
+func fetchHostKeys(app *config.Atun) ([]ssh2.PublicKey, error) {
+	var errs []error
+
+	if app.Config.RouterTransport != ssh.TransportEICE {
+		keys, err := aws.GetHostKeys(app.Config.RouterHostID)
+		if err == nil && len(keys) > 0 {
+			return keys, nil
+		}
+		logger.Debug("Can't read host keys over SSM. Trying console output", "router", app.Config.RouterHostID, "error", err)
+		errs = append(errs, err)
+	}
+
+	keys, err := aws.GetConsoleHostKeys(app.Config.RouterHostID)
+	if err == nil && len(keys) > 0 {
+		return keys, nil
+	}
+	errs = append(errs, err)
+
+	return nil, fmt.Errorf("can't read host keys of %s: %w", app.Config.RouterHostID, errors.Join(errs...))
+}
+
+// pinHostKeys fetches host keys of the EC2 router and pins them. It reports whether pinned keys changed.
+// Changed keys are only re-pinned with --repin-host-keys, otherwise the change is an error naming the old and new fingerprints
+func pinHostKeys(app *config.Atun) (bool, error) {
+	keys, err := fetchHostKeys(app)
+	if err != nil {
+		return false, err
+	}
+
+	changes, err := ssh.PinHostKeys(app, app.Config.RouterHostID, keys, app.Config.SSHRepinHostKeys)
+	var changedErr *ssh.HostKeyChangedError
+	if errors.As(err, &changedErr) {
+		return false, fmt.Errorf("%w. If the router was rebuilt, run atun up --repin-host-keys to pin the new keys", err)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	for _, change := range changes {
+		logger.Warn("Re-pinned the changed host key of the router",
+			"router", app.Config.RouterHostID, "type", change.Type, "pinned", change.Pinned, "new", change.Fetched)
+	}
+
+	return len(changes) > 0, nil
+}
+
+// ensureHostKeysPinned pins host keys of the EC2 router unless they are pinned already.
+// If the keys can't be read, the key the router presents on the first connection is pinned instead
+func ensureHostKeysPinned(app *config.Atun) {
+	if !app.Config.SSHStrictHostKeyChecking {
+		return
+	}
+
+	if pinned, _ := ssh.PinnedHostKeys(app, app.Config.RouterHostID); len(pinned) > 0 {
+		return
+	}
+
+	if _, err := pinHostKeys(app); err != nil {
+		logger.Warn("Can't read host keys of the router, trusting the key it presents on the first connection", "router", app.Config.RouterHostID, "error", err)
+	}
+}
+
+// activateTunnel starts the tunnel. If ssh rejects the host key of the router, its keys are read out of band again:
+// keys changed on the router (e.g. it was rebuilt) fail the tunnel unless re-pinning was requested, then the tunnel is retried.
+// Unchanged keys mean the connection isn't answered by the router and it's reported as such
+func activateTunnel(app *config.Atun) (bool, []ssh.Endpoint, error) {
+	tunnelActive, connections, err := tunnel.ActivateTunnel(app)
+
+	var hostKeyErr *ssh.HostKeyError
+	if !errors.As(err, &hostKeyErr) {
+		return tunnelActive, connections, err
+	}
+
+	changed, pinErr := pinHostKeys(app)
+	if pinErr != nil {
+		return false, nil, errors.Join(err, pinErr)
+	}
+
+	if !changed {
+		return false, nil, fmt.Errorf("%w. The router reports the pinned host keys, so the SSH connection may be intercepted", err)
+	}
+
+	return tunnel.ActivateTunnel(app)
+}
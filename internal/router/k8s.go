@@ -0,0 +1,162 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package router
+
+import (
+	"fmt"
+
+	"github.com/DimmKirr/atun/internal/config"
+	"github.com/DimmKirr/atun/internal/k8s"
+	"github.com/DimmKirr/atun/internal/logger"
+	"github.com/DimmKirr/atun/internal/schema"
+	"github.com/DimmKirr/atun/internal/ssh"
+	"github.com/DimmKirr/atun/internal/tunnel"
+)
+
+// K8s is a router backed by a pod or a deployment with atun.io annotations (a jump pod running sshd).
+// SSH is carried over the API server (kubectl port-forward), so neither the pod nor the cluster needs to be exposed.
+type K8s struct{}
+
+func init() {
+	Register("k8s", func() Router { return &K8s{} })
+}
+
+func (r *K8s) Type() string {
+	return "k8s"
+}
+
+func (r *K8s) RequiresAWS() bool {
+	return false
+}
+
+func (r *K8s) Discover() ([]string, error) {
+	annotations := map[string][]string{
+		"atun.io/version": schema.Versions,
+		"atun.io/env":     {config.App.Config.Env},
+	}
+
+	workloads, err := k8s.ListWorkloadsWithAnnotations(annotations)
+	if err != nil {
+		return nil, err
+	}
+
+	var routerIDs []string
+	for _, w := range workloads {
+		logger.Debug("Found workload", "id", w.ID(), "state", w.State)
+		routerIDs = append(routerIDs, w.ID())
+	}
+
+	if len(routerIDs) == 0 {
+		return nil, fmt.Errorf("no pods or deployments found with required annotations")
+	}
+
+	return routerIDs, nil
+}
+
+func (r *K8s) Describe(routerID string) (config.RouterInfo, error) {
+	w, err := k8s.GetWorkload(routerID)
+	if err != nil {
+		return config.RouterInfo{}, err
+	}
+
+	return config.RouterInfo{
+		ID:        routerID,
+		Type:      r.Type(),
+		Name:      w.Name,
+		State:     w.State,
+		Env:       w.Annotations["atun.io/env"],
+		Endpoints: tunnel.CountHostTags(w.Annotations),
+		CreatedAt: w.CreatedAt,
+	}, nil
+}
+
+func (r *K8s) Endpoints(routerID string) (config.Atun, error) {
+	w, err := k8s.GetWorkload(routerID)
+	if err != nil {
+		return config.Atun{}, err
+	}
+
+	logger.Debug("Workload annotations", "annotations", w.Annotations)
+
+	sshUser, err := k8s.GetWhoAmI(routerID)
+	if err != nil {
+		return config.Atun{}, fmt.Errorf("error getting container user of %s: %w", routerID, err)
+	}
+
+	atun, err := tunnel.GetRouterConfigFromTags(w.Annotations)
+	if err != nil {
+		return config.Atun{}, err
+	}
+
+	atun.Config.RouterHostUser = sshUser
+
+	return atun, nil
+}
+
+// Connect starts the tunnel via sshd of the jump pod. If the tunnel can't be started it authorizes the local SSH key in the pod and retries
+func (r *K8s) Connect(app *config.Atun) (bool, []ssh.Endpoint, error) {
+	if _, err := ssh.EnsureSessionKey(app); err != nil {
+		return false, nil, err
+	}
+
+	proxyCommand, err := k8s.ProxyCommand(app.Config.RouterHostID)
+	if err != nil {
+		return false, nil, err
+	}
+
+	app.Config.SSHConfigFile, err = ssh.WriteSSHConfigFile(app, fmt.Sprintf(`# SSH over Kubernetes API port-forward (generated by atun.io)
+host %s
+ServerAliveInterval 180
+ProxyCommand %s
+`, app.Config.RouterHostID, proxyCommand))
+	if err != nil {
+		return false, nil, fmt.Errorf("error generating SSH config file: %w", err)
+	}
+	logger.Debug("SSH Config generated", "path", app.Config.SSHConfigFile)
+
+	tunnelActive, connections, err := tunnel.ActivateTunnel(app)
+	if err == nil {
+		return tunnelActive, connections, nil
+	}
+	logger.Debug("SSH key doesn't seem to be present in the jump pod", "error", err)
+
+	publicKey, err := ssh.GetSessionPublicKey(app)
+	if err != nil {
+		return false, nil, fmt.Errorf("error getting public key: %w", err)
+	}
+
+	if err := k8s.EnsureSSHPublicKeyPresent(app.Config.RouterHostID, publicKey); err != nil {
+		return false, nil, fmt.Errorf("failed to add local SSH public key to %s: %w", app.Config.RouterHostID, err)
+	}
+	logger.Debug("Public key added to ~/.ssh/authorized_keys", "RouterHostID", app.Config.RouterHostID)
+
+	return tunnel.ActivateTunnel(app)
+}
+
+func (r *K8s) Disconnect(app *config.Atun) (bool, error) {
+	return tunnel.DeactivateTunnel(app)
+}
+
+func (r *K8s) RevokeKey(app *config.Atun, publicKey string) error {
+	_, err := k8s.Exec(app.Config.RouterHostID, "sh", "-c", ssh.RevokeKeyCommand("~/.ssh/authorized_keys", publicKey))
+	return err
+}
+
+func (r *K8s) Status(app *config.Atun) (bool, []ssh.Endpoint, error) {
+	return ssh.GetSSHTunnelStatus(app)
+}
+
+func (r *K8s) Shell(routerID string) error {
+	return k8s.ConnectToShell(routerID)
+}
+
+func (r *K8s) Create(app *config.Atun) (string, error) {
+	return "", fmt.Errorf("creating k8s routers is not supported. Add atun.io annotations to an existing pod or deployment running sshd")
+}
+
+func (r *K8s) Delete(app *config.Atun) error {
+	return fmt.Errorf("deleting k8s routers is not supported. Remove atun.io annotations from the pod or deployment instead")
+}
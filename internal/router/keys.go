@@ -0,0 +1,45 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package router
+
+import (
+	"os"
+
+	"github.com/DimmKirr/atun/internal/config"
+	"github.com/DimmKirr/atun/internal/logger"
+	"github.com/DimmKirr/atun/internal/ssh"
+)
+
+// RevokeSessionKey removes the session key of the tunnel with app.Config.RouterHostID from the router and deletes it locally.
+// Keys set with ssh_key_path are left alone
+func RevokeSessionKey(r Router, app *config.Atun) error {
+	if !ssh.UsesSessionKey(app) {
+		return nil
+	}
+
+	keyPath := ssh.SessionKeyPath(app)
+	publicKey, err := os.ReadFile(keyPath + ".pub")
+	if os.IsNotExist(err) {
+		logger.Debug("No session key to revoke", "path", keyPath)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// With a user CA nothing was written to the router, the certificate expires on its own
+	if ssh.UsesCA(app) {
+		return ssh.RemoveSessionKey(app)
+	}
+
+	if err := r.RevokeKey(app, string(publicKey)); err != nil {
+		// The local key is kept, it's removed from the router by the next session after ssh_session_key_ttl anyway
+		return err
+	}
+	logger.Debug("Session key removed from router", "router", app.Config.RouterHostID)
+
+	return ssh.RemoveSessionKey(app)
+}
@@ -0,0 +1,121 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package router
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/DimmKirr/atun/internal/config"
+	"github.com/DimmKirr/atun/internal/ssh"
+)
+
+// Router is a provider of a router type (ec2, k8s, ecs, ...).
+// Commands are written against this interface so new router types plug in without touching them.
+type Router interface {
+	// Type returns the router type name as used in --router-type
+	Type() string
+
+	// RequiresAWS reports whether the router is reached via AWS APIs and needs an AWS session
+	RequiresAWS() bool
+
+	// Discover returns IDs of all routers matching the current env, preferred router first
+	Discover() ([]string, error)
+
+	// Describe returns details of a router
+	Describe(routerID string) (config.RouterInfo, error)
+
+	// Endpoints reads the endpoints configuration (atun.io/* schema) of a router
+	Endpoints(routerID string) (config.Atun, error)
+
+	// Connect starts forwarding endpoints of app.Config.RouterHostID to the local machine
+	Connect(app *config.Atun) (bool, []ssh.Endpoint, error)
+
+	// Disconnect stops forwarding and returns whether the tunnel is still active
+	Disconnect(app *config.Atun) (bool, error)
+
+	// RevokeKey removes the public key of a session from authorized_keys on the router
+	RevokeKey(app *config.Atun, publicKey string) error
+
+	// Status returns whether the tunnel is active and the state of each endpoint
+	Status(app *config.Atun) (bool, []ssh.Endpoint, error)
+
+	// Shell opens an interactive shell on the router
+	Shell(routerID string) error
+
+	// Create provisions an ad-hoc router and returns its ID
+	Create(app *config.Atun) (string, error)
+
+	// Delete removes the ad-hoc router created by Create
+	Delete(app *config.Atun) error
+}
+
+// Lister is implemented by router types whose routers can be stopped, so listing them differs from discovering routers to connect to
+type Lister interface {
+	// List returns IDs of all routers matching the current env in any state, running ones first
+	List() ([]string, error)
+}
+
+// List returns IDs of all routers of the router type matching the current env, including ones that can't be connected to right now
+func List(r Router) ([]string, error) {
+	if lister, ok := r.(Lister); ok {
+		return lister.List()
+	}
+
+	return r.Discover()
+}
+
+// DefaultType is used when no router type is configured
+const DefaultType = "ec2"
+
+// Factory creates a Router of a specific type
+type Factory func() Router
+
+var registry = map[string]Factory{}
+
+// Register adds a router type to the registry. It's called from init() of each implementation
+func Register(routerType string, factory Factory) {
+	registry[routerType] = factory
+}
+
+// New returns a Router for the given type
+func New(routerType string) (Router, error) {
+	if routerType == "" {
+		routerType = DefaultType
+	}
+
+	factory, ok := registry[routerType]
+	if !ok {
+		return nil, fmt.Errorf("router type '%s' not supported (available: %v)", routerType, Types())
+	}
+
+	return factory(), nil
+}
+
+// Types returns all registered router types
+func Types() []string {
+	var types []string
+	for t := range registry {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+
+	return types
+}
+
+// DiscoverOne returns the preferred router discovered by r
+func DiscoverOne(r Router) (string, error) {
+	routerIDs, err := r.Discover()
+	if err != nil {
+		return "", err
+	}
+
+	if len(routerIDs) == 0 {
+		return "", fmt.Errorf("no %s routers found for env %s", r.Type(), config.App.Config.Env)
+	}
+
+	return routerIDs[0], nil
+}
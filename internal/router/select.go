@@ -0,0 +1,138 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package router
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/DimmKirr/atun/internal/config"
+	"github.com/DimmKirr/atun/internal/constraints"
+	"github.com/DimmKirr/atun/internal/logger"
+	"github.com/DimmKirr/atun/internal/ux"
+)
+
+// Select picks one of routerIDs (ordered from the preferred one).
+// In an interactive terminal it shows a picker with router details, the last choice for this env being the default.
+// Otherwise it uses the last choice if that router is still there and the preferred router if not.
+func Select(r Router, routerIDs []string) (string, error) {
+	if len(routerIDs) == 0 {
+		return "", fmt.Errorf("no %s routers found for env %s", r.Type(), config.App.Config.Env)
+	}
+
+	if len(routerIDs) == 1 {
+		return routerIDs[0], nil
+	}
+
+	lastChoice := lastSelected(r)
+
+	if !constraints.IsInteractiveTerminal() || config.App.Config.LogPlainText {
+		routerID := routerIDs[0]
+		if slices.Contains(routerIDs, lastChoice) {
+			routerID = lastChoice
+		}
+
+		logger.Info("Several routers found. Using the last selected or the preferred one", "router", routerID, "routers", routerIDs)
+		return routerID, nil
+	}
+
+	var options []string
+	var defaultOption string
+	optionIDs := map[string]string{}
+	for _, routerID := range routerIDs {
+		info, err := r.Describe(routerID)
+		if err != nil {
+			logger.Debug("Can't describe router", "router", routerID, "error", err)
+			info = config.RouterInfo{ID: routerID}
+		}
+
+		option := formatRouterOption(info)
+		options = append(options, option)
+		optionIDs[option] = routerID
+
+		if routerID == lastChoice {
+			defaultOption = option
+		}
+	}
+
+	selected, err := ux.GetInteractiveSelection(fmt.Sprintf("Several %s routers found. Select one", r.Type()), options, defaultOption)
+	if err != nil {
+		return "", fmt.Errorf("error selecting router: %w", err)
+	}
+
+	routerID, ok := optionIDs[selected]
+	if !ok {
+		return "", fmt.Errorf("unknown router selected: %s", selected)
+	}
+
+	// Picker output would shift the lines commands clear afterward
+	ux.ClearLines(1)
+
+	if err := saveSelected(r, routerID); err != nil {
+		logger.Debug("Can't remember selected router", "router", routerID, "error", err)
+	}
+
+	return routerID, nil
+}
+
+// Preferred returns routerIDs with routerID moved to the front, so failover still goes through the others
+func Preferred(routerIDs []string, routerID string) []string {
+	ordered := []string{routerID}
+	for _, id := range routerIDs {
+		if id != routerID {
+			ordered = append(ordered, id)
+		}
+	}
+
+	return ordered
+}
+
+// formatRouterOption renders a picker line, e.g. "atun-router (i-0abc) · eu-west-1a · t3.nano · launched 2h ago · 3 endpoints"
+func formatRouterOption(info config.RouterInfo) string {
+	option := info.ID
+	if info.Name != "" && info.Name != info.ID {
+		option = fmt.Sprintf("%s (%s)", info.Name, info.ID)
+	}
+
+	details := []string{option}
+	if info.Account != "" {
+		details = append(details, info.Account)
+	}
+	if info.Zone != "" {
+		details = append(details, info.Zone)
+	}
+	if info.InstanceType != "" {
+		details = append(details, info.InstanceType)
+	}
+	if !info.CreatedAt.IsZero() {
+		details = append(details, fmt.Sprintf("launched %s ago", time.Since(info.CreatedAt).Round(time.Minute)))
+	}
+	details = append(details, fmt.Sprintf("%d endpoints", info.Endpoints))
+
+	return strings.Join(details, " · ")
+}
+
+// selectionFile is where the last selected router of a type is kept. TunnelDir is per env and profile
+func selectionFile(r Router) string {
+	return filepath.Join(config.App.Config.TunnelDir, fmt.Sprintf("%s-router.selected", r.Type()))
+}
+
+func lastSelected(r Router) string {
+	data, err := os.ReadFile(selectionFile(r))
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(data))
+}
+
+func saveSelected(r Router, routerID string) error {
+	return os.WriteFile(selectionFile(r), []byte(routerID+"\n"), 0644)
+}
@@ -0,0 +1,178 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package router
+
+import (
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/DimmKirr/atun/internal/config"
+	"github.com/DimmKirr/atun/internal/logger"
+)
+
+func TestMain(m *testing.M) {
+	logger.Initialize("error", true)
+	config.App = &config.Atun{Config: &config.Config{}}
+
+	os.Exit(m.Run())
+}
+
+func TestPreferred(t *testing.T) {
+	tests := []struct {
+		name      string
+		routerIDs []string
+		routerID  string
+		want      []string
+	}{
+		{
+			name:      "already first",
+			routerIDs: []string{"i-a", "i-b", "i-c"},
+			routerID:  "i-a",
+			want:      []string{"i-a", "i-b", "i-c"},
+		},
+		{
+			name:      "moved to the front, others keep their order",
+			routerIDs: []string{"i-a", "i-b", "i-c"},
+			routerID:  "i-c",
+			want:      []string{"i-c", "i-a", "i-b"},
+		},
+		{
+			name:      "single router",
+			routerIDs: []string{"i-a"},
+			routerID:  "i-a",
+			want:      []string{"i-a"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Preferred(tt.routerIDs, tt.routerID); !slices.Equal(got, tt.want) {
+				t.Errorf("Preferred(%v, %q) = %v, want %v", tt.routerIDs, tt.routerID, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelect(t *testing.T) {
+	// Tests don't run in an interactive terminal, so Select never shows the picker
+	tests := []struct {
+		name       string
+		remembered string
+		routerIDs  []string
+		want       string
+	}{
+		{
+			name:      "no remembered router",
+			routerIDs: []string{"i-a", "i-b"},
+			want:      "i-a",
+		},
+		{
+			name:       "remembered router",
+			remembered: "i-b\n",
+			routerIDs:  []string{"i-a", "i-b"},
+			want:       "i-b",
+		},
+		{
+			name:       "remembered router is gone",
+			remembered: "i-gone\n",
+			routerIDs:  []string{"i-a", "i-b"},
+			want:       "i-a",
+		},
+		{
+			name:       "single router",
+			remembered: "i-b\n",
+			routerIDs:  []string{"i-a"},
+			want:       "i-a",
+		},
+	}
+
+	r := &SSH{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.App.Config.TunnelDir = t.TempDir()
+			if tt.remembered != "" {
+				if err := os.WriteFile(selectionFile(r), []byte(tt.remembered), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := Select(r, tt.routerIDs)
+			if err != nil {
+				t.Fatalf("Select() returned an error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Select(%v) = %q, want %q", tt.routerIDs, got, tt.want)
+			}
+		})
+	}
+
+	if _, err := Select(r, nil); err == nil {
+		t.Error("Select() without routers didn't return an error")
+	}
+}
+
+func TestSelectedRouterFile(t *testing.T) {
+	r := &SSH{}
+	config.App.Config.TunnelDir = t.TempDir()
+
+	if got := lastSelected(r); got != "" {
+		t.Errorf("lastSelected() without a file = %q, want empty", got)
+	}
+
+	if err := saveSelected(r, "i-a"); err != nil {
+		t.Fatalf("saveSelected() returned an error: %v", err)
+	}
+	if got := lastSelected(r); got != "i-a" {
+		t.Errorf("lastSelected() = %q, want %q", got, "i-a")
+	}
+
+	// Each router type remembers its own choice
+	if got := lastSelected(&EC2{}); got != "" {
+		t.Errorf("lastSelected() of ec2 routers = %q, want empty", got)
+	}
+}
+
+func TestFormatRouterOption(t *testing.T) {
+	tests := []struct {
+		name string
+		info config.RouterInfo
+		want string
+	}{
+		{
+			name: "only ID",
+			info: config.RouterInfo{ID: "i-a"},
+			want: "i-a · 0 endpoints",
+		},
+		{
+			name: "name same as ID",
+			info: config.RouterInfo{ID: "bastion", Name: "bastion", Endpoints: 1},
+			want: "bastion · 1 endpoints",
+		},
+		{
+			name: "all details",
+			info: config.RouterInfo{
+				ID:           "i-a",
+				Name:         "atun-router",
+				Account:      "123456789012",
+				Zone:         "eu-west-1a",
+				InstanceType: "t3.nano",
+				CreatedAt:    time.Now().Add(-2 * time.Hour),
+				Endpoints:    3,
+			},
+			want: "atun-router (i-a) · 123456789012 · eu-west-1a · t3.nano · launched 2h0m0s ago · 3 endpoints",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatRouterOption(tt.info); got != tt.want {
+				t.Errorf("formatRouterOption() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
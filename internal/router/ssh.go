@@ -0,0 +1,153 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package router
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+
+	"github.com/DimmKirr/atun/internal/config"
+	"github.com/DimmKirr/atun/internal/logger"
+	"github.com/DimmKirr/atun/internal/ssh"
+	"github.com/DimmKirr/atun/internal/tunnel"
+)
+
+// SSH is a router backed by a plain SSH bastion (no SSM agent) that is connected to directly by its IP or DNS name.
+// The bastion and its hosts are set locally (SSH_BASTION_HOST with hosts from atun.toml), nothing is discovered in AWS.
+type SSH struct{}
+
+func init() {
+	Register("ssh", func() Router { return &SSH{} })
+}
+
+func (r *SSH) Type() string {
+	return "ssh"
+}
+
+func (r *SSH) RequiresAWS() bool {
+	return false
+}
+
+// Discover returns the configured bastion. Without SSM there's no way to learn the user of a tagged instance, so it has to be set explicitly
+func (r *SSH) Discover() ([]string, error) {
+	if config.App.Config.SSHBastionHost == "" {
+		return nil, fmt.Errorf("no bastion configured for the ssh router. Set ssh_bastion_host in atun.toml or SSH_BASTION_HOST")
+	}
+
+	return []string{config.App.Config.SSHBastionHost}, nil
+}
+
+func (r *SSH) Describe(routerID string) (config.RouterInfo, error) {
+	return config.RouterInfo{
+		ID:   routerID,
+		Type: r.Type(),
+		// A bastion is not managed by atun, so its state is only known once connected
+		State: "unknown",
+	}, nil
+}
+
+func (r *SSH) Endpoints(routerID string) (config.Atun, error) {
+	if len(config.App.Config.Hosts) == 0 {
+		return config.Atun{}, fmt.Errorf("no hosts configured for bastion %s. Add them to atun.toml", routerID)
+	}
+
+	atun, err := tunnel.GetRouterConfigFromHosts(config.App.Config.Hosts)
+	if err != nil {
+		return config.Atun{}, err
+	}
+
+	atun.Config.RouterHostUser, err = r.localUser()
+	if err != nil {
+		return config.Atun{}, err
+	}
+
+	return atun, nil
+}
+
+// Connect starts the tunnel directly to the bastion. The local SSH key has to be authorized on the bastion already
+func (r *SSH) Connect(app *config.Atun) (bool, []ssh.Endpoint, error) {
+	address := app.Config.RouterHostID
+
+	var err error
+	app.Config.SSHConfigFile, err = ssh.WriteSSHConfigFile(app, fmt.Sprintf(`# SSH to a bastion host (generated by atun.io)
+host %s
+HostName %s
+Port %d
+ServerAliveInterval 180
+`, app.Config.RouterHostID, address, app.Config.SSHBastionPort))
+	if err != nil {
+		return false, nil, fmt.Errorf("error generating SSH config file: %w", err)
+	}
+	logger.Debug("SSH Config generated", "path", app.Config.SSHConfigFile)
+
+	tunnelActive, connections, err := tunnel.ActivateTunnel(app)
+	if err != nil {
+		return false, nil, fmt.Errorf("%w. Make sure %s is reachable on port %d and your SSH key is authorized for %s", err, address, app.Config.SSHBastionPort, app.Config.RouterHostUser)
+	}
+
+	return tunnelActive, connections, nil
+}
+
+func (r *SSH) Disconnect(app *config.Atun) (bool, error) {
+	return tunnel.DeactivateTunnel(app)
+}
+
+// RevokeKey is a no-op: keys of bastions are managed by their owners, atun doesn't authorize any
+func (r *SSH) RevokeKey(app *config.Atun, publicKey string) error {
+	return nil
+}
+
+func (r *SSH) Status(app *config.Atun) (bool, []ssh.Endpoint, error) {
+	return ssh.GetSSHTunnelStatus(app)
+}
+
+func (r *SSH) Shell(routerID string) error {
+	sshUser, err := r.localUser()
+	if err != nil {
+		return err
+	}
+
+	args := []string{"-p", fmt.Sprintf("%d", config.App.Config.SSHBastionPort)}
+	if _, err := os.Stat(config.App.Config.SSHKeyPath); err == nil {
+		args = append(args, "-i", config.App.Config.SSHKeyPath)
+	}
+	args = append(args, fmt.Sprintf("%s@%s", sshUser, routerID))
+
+	sessionCommand := exec.Command("ssh", args...)
+	sessionCommand.Stdout = os.Stdout
+	sessionCommand.Stderr = os.Stderr
+	sessionCommand.Stdin = os.Stdin
+
+	if err := sessionCommand.Run(); err != nil {
+		return fmt.Errorf("failed to start SSH session: %w", err)
+	}
+
+	return nil
+}
+
+func (r *SSH) Create(app *config.Atun) (string, error) {
+	return "", fmt.Errorf("creating ssh routers is not supported. Set SSH_BASTION_HOST to an existing bastion instead")
+}
+
+func (r *SSH) Delete(app *config.Atun) error {
+	return fmt.Errorf("deleting ssh routers is not supported. Bastions are not managed by atun")
+}
+
+// localUser returns the bastion user (ROUTER_HOST_USER). Same as ssh, it defaults to the local user
+func (r *SSH) localUser() (string, error) {
+	if config.App.Config.RouterHostUser != "" {
+		return config.App.Config.RouterHostUser, nil
+	}
+
+	currentUser, err := user.Current()
+	if err != nil {
+		return "", fmt.Errorf("error getting current user: %w", err)
+	}
+
+	return currentUser.Username, nil
+}
@@ -0,0 +1,239 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+// Package schema reads and writes atun.io/* router tags (schemas/schema.json).
+//
+// In v1 the value of an atun.io/host/<hostname> tag is a single port: {"proto":"ssm","remote":5432,"local":15432}.
+// In v2 it's a list of ports with metadata: {"ports":[{"remote":5432,"local":15432}],"transport":"ssm","alias":"db"}.
+//
+// schemas/schema.json is the source of truth for the tags. Validate mirrors its fields, enums and patterns in Go,
+// so it can explain how to fix a tag, and TestValidatorMatchesSchema fails when the two drift apart.
+// Change schema.json first, then the lists in validate.go.
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/DimmKirr/atun/internal/config"
+)
+
+// Version is the schema version atun writes to routers (config.App.Version)
+const Version = "2"
+
+// Versions are schema versions atun reads. Routers with other versions are not discovered
+var Versions = []string{"1", "2"}
+
+// HostTagPrefix starts tag keys of endpoints: atun.io/host/<hostname>
+const HostTagPrefix = "atun.io/host/"
+
+// MaxTagValueLength is the longest tag value AWS accepts
+const MaxTagValueLength = 256
+
+// Health check types of endpoints
+const (
+	HealthCheckTCP  = "tcp"
+	HealthCheckHTTP = "http"
+	HealthCheckNone = "none"
+)
+
+// HostV1 is the value of a host tag in schema v1
+type HostV1 struct {
+	Proto  string `json:"proto"`
+	Remote int    `json:"remote"`
+	Local  Port   `json:"local"`
+}
+
+// HostV2 is the value of a host tag in schema v2
+type HostV2 struct {
+	Ports []PortMapping `json:"ports"`
+	// Transport is how the endpoint is forwarded (proto in v1)
+	Transport   string            `json:"transport,omitempty"`
+	Alias       string            `json:"alias,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	HealthCheck string            `json:"health_check,omitempty"`
+}
+
+// PortMapping is a remote port of a host and the local port it's forwarded to (0 to allocate one)
+type PortMapping struct {
+	Remote int `json:"remote"`
+	Local  int `json:"local"`
+}
+
+// Port is a port number. In v1 tags it's also accepted as a string ("local":"15432"), as older docs had it
+type Port int
+
+func (p *Port) UnmarshalJSON(data []byte) error {
+	var port int
+	if err := json.Unmarshal(data, &port); err == nil {
+		*p = Port(port)
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("port must be a number: %s", data)
+	}
+
+	port, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("port must be a number: %s", data)
+	}
+	*p = Port(port)
+
+	return nil
+}
+
+// IsSupportedVersion reports whether atun reads routers tagged with the schema version
+func IsSupportedVersion(version string) bool {
+	for _, v := range Versions {
+		if v == version {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ParseHost parses the value of the atun.io/host/<hostname> tag of a router with the schema version into endpoints, one per port
+func ParseHost(version string, key string, value string) ([]config.Endpoint, error) {
+	name := strings.TrimPrefix(key, HostTagPrefix)
+
+	switch version {
+	case "", "1":
+		var host HostV1
+		if err := json.Unmarshal([]byte(value), &host); err != nil {
+			return nil, err
+		}
+
+		if host.Remote < 1 || host.Remote > 65535 {
+			return nil, fmt.Errorf("remote port %d is not valid", host.Remote)
+		}
+
+		return []config.Endpoint{{Name: name, Proto: host.Proto, Remote: host.Remote, Local: int(host.Local)}}, nil
+	case "2":
+		var host HostV2
+		if err := json.Unmarshal([]byte(value), &host); err != nil {
+			return nil, err
+		}
+
+		if len(host.Ports) == 0 {
+			return nil, fmt.Errorf("no ports")
+		}
+
+		var endpoints []config.Endpoint
+		for _, port := range host.Ports {
+			if port.Remote < 1 || port.Remote > 65535 {
+				return nil, fmt.Errorf("remote port %d is not valid", port.Remote)
+			}
+
+			endpoints = append(endpoints, config.Endpoint{
+				Name:        name,
+				Proto:       host.Transport,
+				Remote:      port.Remote,
+				Local:       port.Local,
+				Alias:       host.Alias,
+				Description: host.Description,
+				Labels:      host.Labels,
+				HealthCheck: host.HealthCheck,
+			})
+		}
+
+		return endpoints, nil
+	default:
+		return nil, fmt.Errorf("unsupported schema version %s (supported: %s)", version, strings.Join(Versions, ", "))
+	}
+}
+
+// HostTags returns v2 host tags of the endpoints. Endpoints of the same host go to one tag; metadata is taken from the first of them
+func HostTags(endpoints []config.Endpoint) (map[string]string, error) {
+	hosts := map[string]*HostV2{}
+
+	for _, endpoint := range endpoints {
+		host, ok := hosts[endpoint.Name]
+		if !ok {
+			host = &HostV2{
+				Transport:   endpoint.Proto,
+				Alias:       endpoint.Alias,
+				Description: endpoint.Description,
+				Labels:      endpoint.Labels,
+				HealthCheck: endpoint.HealthCheck,
+			}
+			hosts[endpoint.Name] = host
+		}
+
+		host.Ports = append(host.Ports, PortMapping{Remote: endpoint.Remote, Local: endpoint.Local})
+	}
+
+	tags := map[string]string{}
+	for name, host := range hosts {
+		value, err := encode(host)
+		if err != nil {
+			return nil, fmt.Errorf("host %s: %w", name, err)
+		}
+		tags[HostTagPrefix+name] = value
+	}
+
+	return tags, nil
+}
+
+// Migrate returns tags of a v1 router rewritten to v2: the version tag and every host tag. Other tags are left out
+func Migrate(tags map[string]string) (map[string]string, error) {
+	if version := tags["atun.io/version"]; version != "1" {
+		return nil, fmt.Errorf("can't migrate schema version %q, only 1 is migrated", version)
+	}
+
+	var endpoints []config.Endpoint
+	for _, key := range sortedHostKeys(tags) {
+		hostEndpoints, err := ParseHost("1", key, tags[key])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		endpoints = append(endpoints, hostEndpoints...)
+	}
+
+	migrated, err := HostTags(endpoints)
+	if err != nil {
+		return nil, err
+	}
+	migrated["atun.io/version"] = Version
+
+	return migrated, nil
+}
+
+// sortedHostKeys returns keys of host tags in a stable order
+func sortedHostKeys(tags map[string]string) []string {
+	var keys []string
+	for key := range tags {
+		if strings.HasPrefix(key, HostTagPrefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// encode marshals a tag value, it has to fit into an AWS tag
+func encode(v interface{}) (string, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return "", err
+	}
+
+	value := strings.TrimSpace(buf.String())
+	if len(value) > MaxTagValueLength {
+		return "", fmt.Errorf("tag value is %d characters long, AWS allows %d (shorten description or labels)", len(value), MaxTagValueLength)
+	}
+
+	return value, nil
+}
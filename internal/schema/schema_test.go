@@ -0,0 +1,291 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package schema
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/DimmKirr/atun/internal/config"
+)
+
+func TestParseHost(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+		value   string
+		want    []config.Endpoint
+		wantErr bool
+	}{
+		{
+			name:    "v1",
+			version: "1",
+			value:   `{"proto":"ssm","remote":5432,"local":15432}`,
+			want:    []config.Endpoint{{Name: "db.internal", Proto: "ssm", Remote: 5432, Local: 15432}},
+		},
+		{
+			name:    "v1 with a string local port",
+			version: "1",
+			value:   `{"proto":"ssm","remote":5432,"local":"15432"}`,
+			want:    []config.Endpoint{{Name: "db.internal", Proto: "ssm", Remote: 5432, Local: 15432}},
+		},
+		{
+			name:    "v1 without a local port",
+			version: "1",
+			value:   `{"proto":"ssm","remote":5432}`,
+			want:    []config.Endpoint{{Name: "db.internal", Proto: "ssm", Remote: 5432}},
+		},
+		{
+			name:    "no version is v1",
+			version: "",
+			value:   `{"proto":"ssm","remote":5432,"local":15432}`,
+			want:    []config.Endpoint{{Name: "db.internal", Proto: "ssm", Remote: 5432, Local: 15432}},
+		},
+		{
+			name:    "v1 with a local port that's not a number",
+			version: "1",
+			value:   `{"proto":"ssm","remote":5432,"local":"postgres"}`,
+			wantErr: true,
+		},
+		{
+			name:    "v1 with an invalid remote port",
+			version: "1",
+			value:   `{"proto":"ssm","remote":70000,"local":15432}`,
+			wantErr: true,
+		},
+		{
+			name:    "v1 value read as v2",
+			version: "2",
+			value:   `{"proto":"ssm","remote":5432,"local":15432}`,
+			wantErr: true,
+		},
+		{
+			name:    "v2 with several ports and metadata",
+			version: "2",
+			value:   `{"ports":[{"remote":5432,"local":15432},{"remote":5433,"local":0}],"transport":"ssm","alias":"db","description":"Primary","labels":{"team":"data"},"health_check":"tcp"}`,
+			want: []config.Endpoint{
+				{Name: "db.internal", Proto: "ssm", Remote: 5432, Local: 15432, Alias: "db", Description: "Primary", Labels: map[string]string{"team": "data"}, HealthCheck: "tcp"},
+				{Name: "db.internal", Proto: "ssm", Remote: 5433, Local: 0, Alias: "db", Description: "Primary", Labels: map[string]string{"team": "data"}, HealthCheck: "tcp"},
+			},
+		},
+		{
+			name:    "v2 with ports only",
+			version: "2",
+			value:   `{"ports":[{"remote":6379,"local":16379}]}`,
+			want:    []config.Endpoint{{Name: "db.internal", Remote: 6379, Local: 16379}},
+		},
+		{
+			name:    "v2 without ports",
+			version: "2",
+			value:   `{"ports":[],"transport":"ssm"}`,
+			wantErr: true,
+		},
+		{
+			name:    "v2 with an invalid remote port",
+			version: "2",
+			value:   `{"ports":[{"remote":5432,"local":15432},{"remote":0,"local":15433}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid JSON",
+			version: "2",
+			value:   `{"ports":`,
+			wantErr: true,
+		},
+		{
+			name:    "unsupported version",
+			version: "3",
+			value:   `{"ports":[{"remote":5432,"local":15432}]}`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseHost(tt.version, HostTagPrefix+"db.internal", tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseHost() = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseHost() returned an error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseHost() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHostTags(t *testing.T) {
+	tests := []struct {
+		name      string
+		endpoints []config.Endpoint
+		want      map[string]string
+		wantErr   bool
+	}{
+		{
+			name: "ports of a host go to one tag",
+			endpoints: []config.Endpoint{
+				{Name: "db.internal", Proto: "ssm", Remote: 5432, Local: 15432, Alias: "db"},
+				// Metadata of later endpoints of the host is ignored
+				{Name: "db.internal", Proto: "eice", Remote: 5433, Local: 15433, Alias: "replica"},
+				{Name: "cache.internal", Proto: "ssm", Remote: 6379, Local: 16379},
+			},
+			want: map[string]string{
+				"atun.io/host/db.internal":    `{"ports":[{"remote":5432,"local":15432},{"remote":5433,"local":15433}],"transport":"ssm","alias":"db"}`,
+				"atun.io/host/cache.internal": `{"ports":[{"remote":6379,"local":16379}],"transport":"ssm"}`,
+			},
+		},
+		{
+			name: "metadata",
+			endpoints: []config.Endpoint{
+				{Name: "api.internal", Remote: 443, Description: "API <internal>", Labels: map[string]string{"team": "web"}, HealthCheck: HealthCheckHTTP},
+			},
+			want: map[string]string{
+				"atun.io/host/api.internal": `{"ports":[{"remote":443,"local":0}],"description":"API <internal>","labels":{"team":"web"},"health_check":"http"}`,
+			},
+		},
+		{
+			name:      "no endpoints",
+			endpoints: nil,
+			want:      map[string]string{},
+		},
+		{
+			name: "value longer than AWS allows",
+			endpoints: []config.Endpoint{
+				{Name: "db.internal", Proto: "ssm", Remote: 5432, Description: strings.Repeat("a", MaxTagValueLength)},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := HostTags(tt.endpoints)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("HostTags() = %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("HostTags() returned an error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("HostTags() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHostTagsMaxLength(t *testing.T) {
+	// The longest value that fits is accepted
+	overhead := len(`{"ports":[{"remote":5432,"local":0}],"description":""}`)
+	endpoints := []config.Endpoint{{Name: "db.internal", Remote: 5432, Description: strings.Repeat("a", MaxTagValueLength-overhead)}}
+
+	tags, err := HostTags(endpoints)
+	if err != nil {
+		t.Fatalf("HostTags() returned an error: %v", err)
+	}
+	if got := len(tags["atun.io/host/db.internal"]); got != MaxTagValueLength {
+		t.Errorf("tag value is %d characters long, want %d", got, MaxTagValueLength)
+	}
+
+	endpoints[0].Description += "a"
+	if _, err := HostTags(endpoints); err == nil {
+		t.Errorf("HostTags() of a %d characters long value should return an error", MaxTagValueLength+1)
+	}
+}
+
+func TestHostTagsRoundTrip(t *testing.T) {
+	endpoints := []config.Endpoint{
+		{Name: "db.internal", Proto: "ssm", Remote: 5432, Local: 15432, Alias: "db", Labels: map[string]string{"team": "data"}, HealthCheck: HealthCheckTCP},
+		{Name: "db.internal", Proto: "ssm", Remote: 5433, Local: 15433, Alias: "db", Labels: map[string]string{"team": "data"}, HealthCheck: HealthCheckTCP},
+	}
+
+	tags, err := HostTags(endpoints)
+	if err != nil {
+		t.Fatalf("HostTags() returned an error: %v", err)
+	}
+
+	got, err := ParseHost(Version, "atun.io/host/db.internal", tags["atun.io/host/db.internal"])
+	if err != nil {
+		t.Fatalf("ParseHost() returned an error: %v", err)
+	}
+	if !reflect.DeepEqual(got, endpoints) {
+		t.Errorf("ParseHost(HostTags()) = %+v, want %+v", got, endpoints)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	tests := []struct {
+		name    string
+		tags    map[string]string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name: "v1 router",
+			tags: map[string]string{
+				"atun.io/version":             "1",
+				"atun.io/env":                 "dev",
+				"Name":                        "atun-router",
+				"atun.io/host/db.internal":    `{"proto":"ssm","remote":5432,"local":15432}`,
+				"atun.io/host/cache.internal": `{"proto":"ssm","remote":6379,"local":"16379"}`,
+			},
+			want: map[string]string{
+				"atun.io/version":             "2",
+				"atun.io/host/db.internal":    `{"ports":[{"remote":5432,"local":15432}],"transport":"ssm"}`,
+				"atun.io/host/cache.internal": `{"ports":[{"remote":6379,"local":16379}],"transport":"ssm"}`,
+			},
+		},
+		{
+			name: "v1 router without hosts",
+			tags: map[string]string{"atun.io/version": "1", "atun.io/env": "dev"},
+			want: map[string]string{"atun.io/version": "2"},
+		},
+		{
+			name:    "v2 router",
+			tags:    map[string]string{"atun.io/version": "2", "atun.io/host/db.internal": `{"ports":[{"remote":5432,"local":15432}]}`},
+			wantErr: true,
+		},
+		{
+			name:    "no version",
+			tags:    map[string]string{"atun.io/host/db.internal": `{"proto":"ssm","remote":5432,"local":15432}`},
+			wantErr: true,
+		},
+		{
+			name: "invalid host tag",
+			tags: map[string]string{
+				"atun.io/version":          "1",
+				"atun.io/host/db.internal": `{"proto":"ssm","remote":"postgres"}`,
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Migrate(tt.tags)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Migrate() = %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Migrate() returned an error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Migrate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,382 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Violation is a tag of a router that doesn't match schemas/schema.json
+type Violation struct {
+	// Key is the tag key, e.g. atun.io/host/db.internal
+	Key string
+	// Problem is what's wrong with the tag
+	Problem string
+	// Fix is how to fix it
+	Fix string
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%s: %s (%s)", v.Key, v.Problem, v.Fix)
+}
+
+var (
+	priorityPattern = regexp.MustCompile(`^-?[0-9]+$`)
+	userPattern     = regexp.MustCompile(`^[a-z_][a-z0-9_.-]*$`)
+	aliasPattern    = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+)
+
+// The lists below mirror schemas/schema.json, which is the source of truth (see the package doc)
+
+// routerTags are atun.io/* tags other than host tags, mapped to the values they accept (nil for any)
+var routerTags = map[string][]string{
+	"atun.io/version":   Versions,
+	"atun.io/env":       nil,
+	"atun.io/priority":  nil,
+	"atun.io/transport": {"ssm", "eice"},
+	"atun.io/user":      nil,
+	"atun.io/ad-hoc":    {"true", "false"},
+}
+
+var (
+	transports   = []string{"ssm", "ssh"}
+	healthChecks = []string{HealthCheckTCP, HealthCheckHTTP, HealthCheckNone}
+	hostV1Fields = []string{"local", "proto", "remote"}
+	hostV2Fields = []string{"ports", "transport", "alias", "description", "labels", "health_check"}
+	portFields   = []string{"remote", "local"}
+)
+
+// Validate checks atun.io/* tags (or annotations) of a router against the tag schema. Other tags are ignored
+func Validate(tags map[string]string) []Violation {
+	var violations []Violation
+
+	version, hasVersion := tags["atun.io/version"]
+	if !hasVersion {
+		violations = append(violations, Violation{
+			Key:     "atun.io/version",
+			Problem: "tag is missing",
+			Fix:     fmt.Sprintf("set it to %s", Version),
+		})
+	}
+	if _, ok := tags["atun.io/env"]; !ok {
+		violations = append(violations, Violation{
+			Key:     "atun.io/env",
+			Problem: "tag is missing",
+			Fix:     "set it to the env of the router, e.g. dev",
+		})
+	}
+	if len(sortedHostKeys(tags)) == 0 {
+		violations = append(violations, Violation{
+			Key:     HostTagPrefix + "<hostname>",
+			Problem: "router has no endpoints",
+			Fix:     fmt.Sprintf(`add a tag like %s<hostname> = {"ports":[{"remote":5432,"local":15432}],"transport":"ssm"}`, HostTagPrefix),
+		})
+	}
+
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		if strings.HasPrefix(key, "atun.io/") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := tags[key]
+
+		if strings.HasPrefix(key, HostTagPrefix) {
+			// Hosts are read as v1 without a version (as atun reads them) and with the latest schema if the version is unknown
+			hostVersion := version
+			if hostVersion == "" {
+				hostVersion = "1"
+			} else if !IsSupportedVersion(hostVersion) {
+				hostVersion = Version
+			}
+			violations = append(violations, ValidateHost(hostVersion, key, value)...)
+			continue
+		}
+
+		if violation, ok := validateRouterTag(key, value); !ok {
+			violations = append(violations, violation)
+		}
+	}
+
+	return violations
+}
+
+// validateRouterTag checks a tag that is not a host tag
+func validateRouterTag(key string, value string) (Violation, bool) {
+	allowed, known := routerTags[key]
+	if !known {
+		fix := "remove it, atun doesn't read it"
+		if suggestion := closest(key, knownTagKeys()); suggestion != "" {
+			fix = fmt.Sprintf("rename it to %s", suggestion)
+		}
+		// A misspelled host prefix, e.g. atun.io/hosts/<hostname>
+		if prefix, hostname, ok := strings.Cut(strings.TrimPrefix(key, "atun.io/"), "/"); ok && hostname != "" && levenshtein(prefix, "host") <= 2 {
+			fix = fmt.Sprintf("rename it to %s%s", HostTagPrefix, hostname)
+		}
+		return Violation{Key: key, Problem: "unknown tag", Fix: fix}, false
+	}
+
+	if allowed != nil && !slices.Contains(allowed, value) {
+		return Violation{
+			Key:     key,
+			Problem: fmt.Sprintf("value %q is not supported", value),
+			Fix:     fmt.Sprintf("set it to one of: %s", strings.Join(allowed, ", ")),
+		}, false
+	}
+
+	switch key {
+	case "atun.io/env":
+		if strings.TrimSpace(value) == "" {
+			return Violation{Key: key, Problem: "value is empty", Fix: "set it to the env of the router, e.g. dev"}, false
+		}
+	case "atun.io/priority":
+		if !priorityPattern.MatchString(value) {
+			return Violation{Key: key, Problem: fmt.Sprintf("value %q is not a number", value), Fix: "set it to an integer, higher is preferred"}, false
+		}
+	case "atun.io/user":
+		if !userPattern.MatchString(value) {
+			return Violation{Key: key, Problem: fmt.Sprintf("value %q is not a valid user name", value), Fix: "set it to the SSH user of the router, e.g. ec2-user"}, false
+		}
+	}
+
+	return Violation{}, true
+}
+
+// ValidateHost checks the value of an atun.io/host/<hostname> tag of a router with the schema version
+func ValidateHost(version string, key string, value string) []Violation {
+	if strings.TrimPrefix(key, HostTagPrefix) == "" {
+		return []Violation{{Key: key, Problem: "hostname is empty", Fix: fmt.Sprintf("name the tag %s<hostname>", HostTagPrefix)}}
+	}
+
+	var violations []Violation
+	add := func(problem string, fix string) {
+		violations = append(violations, Violation{Key: key, Problem: problem, Fix: fix})
+	}
+
+	if len(value) > MaxTagValueLength {
+		add(fmt.Sprintf("value is %d characters long, AWS allows %d", len(value), MaxTagValueLength), "shorten description or labels, or split ports into tags of separate hostnames")
+	}
+
+	fields, err := decodeObject(value)
+	if err != nil {
+		example := `{"ports":[{"remote":5432,"local":15432}],"transport":"ssm"}`
+		if version == "1" {
+			example = `{"local":15432,"proto":"ssm","remote":5432}`
+		}
+		add(fmt.Sprintf("value is not a JSON object: %s", err), fmt.Sprintf("set it to an object like %s", example))
+		return violations
+	}
+
+	if version == "1" {
+		checkFields(fields, hostV1Fields, hostV1Fields, "", add)
+		checkPort(fields, "remote", "", 1, add)
+		checkLocalPortV1(fields, add)
+		checkEnum(fields, "proto", "", transports, add)
+
+		return violations
+	}
+
+	checkFields(fields, hostV2Fields, []string{"ports"}, "", add)
+	checkEnum(fields, "transport", "", transports, add)
+	checkEnum(fields, "health_check", "", healthChecks, add)
+
+	if raw, ok := fields["ports"]; ok {
+		var ports []json.RawMessage
+		if err := json.Unmarshal(raw, &ports); err != nil {
+			add("ports is not a list", `set it to a list like [{"remote":5432,"local":15432}]`)
+		} else if len(ports) == 0 {
+			add("ports is empty", `add a port like {"remote":5432,"local":15432}`)
+		}
+
+		for i, port := range ports {
+			path := fmt.Sprintf("ports[%d].", i)
+			portFieldsValues, err := decodeObject(string(port))
+			if err != nil {
+				add(fmt.Sprintf("%s is not an object", strings.TrimSuffix(path, ".")), `set it to an object like {"remote":5432,"local":15432}`)
+				continue
+			}
+			checkFields(portFieldsValues, portFields, portFields, path, add)
+			checkPort(portFieldsValues, "remote", path, 1, add)
+			checkPort(portFieldsValues, "local", path, 0, add)
+		}
+	}
+
+	if raw, ok := fields["alias"]; ok {
+		var alias string
+		if err := json.Unmarshal(raw, &alias); err != nil || !aliasPattern.MatchString(alias) {
+			add(fmt.Sprintf("alias %s is not valid", raw), "use letters, digits, dots, dashes and underscores only")
+		}
+	}
+	if raw, ok := fields["description"]; ok {
+		var description string
+		if err := json.Unmarshal(raw, &description); err != nil {
+			add(fmt.Sprintf("description %s is not a string", raw), "set it to a string")
+		}
+	}
+	if raw, ok := fields["labels"]; ok {
+		var labels map[string]string
+		if err := json.Unmarshal(raw, &labels); err != nil {
+			add(fmt.Sprintf("labels %s are not strings", raw), `set them to an object of strings like {"team":"data"}`)
+		}
+	}
+
+	return violations
+}
+
+// decodeObject decodes a JSON object into its raw fields
+func decodeObject(value string) (map[string]json.RawMessage, error) {
+	decoder := json.NewDecoder(bytes.NewReader([]byte(value)))
+	decoder.UseNumber()
+
+	var fields map[string]json.RawMessage
+	if err := decoder.Decode(&fields); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return nil, fmt.Errorf("%s at character %d", syntaxErr, syntaxErr.Offset)
+		}
+		return nil, err
+	}
+	if fields == nil {
+		return nil, fmt.Errorf("value is null")
+	}
+	if decoder.More() {
+		return nil, fmt.Errorf("unexpected data after the object")
+	}
+
+	return fields, nil
+}
+
+// checkFields reports unknown fields (with the known field they're likely a typo of) and missing required fields
+func checkFields(fields map[string]json.RawMessage, known []string, required []string, path string, add func(string, string)) {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if slices.Contains(known, name) {
+			continue
+		}
+
+		fix := fmt.Sprintf("remove it, known fields are: %s", strings.Join(known, ", "))
+		if suggestion := closest(name, known); suggestion != "" {
+			fix = fmt.Sprintf("rename it to %s", suggestion)
+		}
+		add(fmt.Sprintf("unknown field %s%s", path, name), fix)
+	}
+
+	for _, name := range required {
+		if _, ok := fields[name]; !ok {
+			add(fmt.Sprintf("field %s%s is missing", path, name), fmt.Sprintf("add %s", name))
+		}
+	}
+}
+
+// checkPort reports a port that is not an integer in [minimum, 65535]
+func checkPort(fields map[string]json.RawMessage, name string, path string, minimum int, add func(string, string)) {
+	raw, ok := fields[name]
+	if !ok {
+		return
+	}
+
+	port, err := strconv.Atoi(string(raw))
+	if err != nil || port < minimum || port > 65535 {
+		fix := fmt.Sprintf("set it to a number between %d and 65535", minimum)
+		if minimum == 0 {
+			fix += " (0 allocates a free port)"
+		}
+		add(fmt.Sprintf("%s%s %s is not a valid port", path, name, raw), fix)
+	}
+}
+
+// checkLocalPortV1 reports a v1 local port that is not valid. It's also read from a string, but that's deprecated
+func checkLocalPortV1(fields map[string]json.RawMessage, add func(string, string)) {
+	raw, ok := fields["local"]
+	if !ok {
+		return
+	}
+
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		if port, err := strconv.Atoi(s); err == nil && port >= 0 && port <= 65535 {
+			add(fmt.Sprintf("local %s is a string", raw), fmt.Sprintf("set it to the number %d or run atun router migrate", port))
+			return
+		}
+	}
+
+	checkPort(fields, "local", "", 0, add)
+}
+
+// checkEnum reports a string field with a value other than allowed
+func checkEnum(fields map[string]json.RawMessage, name string, path string, allowed []string, add func(string, string)) {
+	raw, ok := fields[name]
+	if !ok {
+		return
+	}
+
+	var value string
+	if err := json.Unmarshal(raw, &value); err != nil || !slices.Contains(allowed, value) {
+		add(fmt.Sprintf("%s%s %s is not supported", path, name, raw), fmt.Sprintf("set it to one of: %s", strings.Join(allowed, ", ")))
+	}
+}
+
+// knownTagKeys returns keys of router tags in a stable order, the host tag prefix included
+func knownTagKeys() []string {
+	keys := []string{HostTagPrefix}
+	for key := range routerTags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// closest returns the candidate that s is likely a typo of, or "" if none is close enough
+func closest(s string, candidates []string) string {
+	best, bestDistance := "", 3
+	for _, candidate := range candidates {
+		if distance := levenshtein(s, candidate); distance < bestDistance {
+			best, bestDistance = candidate, distance
+		}
+	}
+
+	return best
+}
+
+// levenshtein returns the edit distance of two strings
+func levenshtein(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+
+	return previous[len(b)]
+}
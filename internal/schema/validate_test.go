@@ -0,0 +1,409 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package schema
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// problems returns violations as "<key>: <problem>"
+func problems(violations []Violation) []string {
+	var got []string
+	for _, v := range violations {
+		got = append(got, v.Key+": "+v.Problem)
+	}
+
+	return got
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		tags map[string]string
+		want []string
+		// wantFix is the fix of the first violation
+		wantFix string
+	}{
+		{
+			name: "valid v2 router",
+			tags: map[string]string{
+				"Name":                     "atun-router",
+				"atun.io/version":          "2",
+				"atun.io/env":              "dev",
+				"atun.io/priority":         "-10",
+				"atun.io/transport":        "eice",
+				"atun.io/user":             "ec2-user",
+				"atun.io/ad-hoc":           "true",
+				"atun.io/host/db.internal": `{"ports":[{"remote":5432,"local":15432},{"remote":5433,"local":0}],"transport":"ssm","alias":"db","description":"Primary","labels":{"team":"data"},"health_check":"tcp"}`,
+			},
+		},
+		{
+			name: "valid v1 router",
+			tags: map[string]string{
+				"atun.io/version":          "1",
+				"atun.io/env":              "dev",
+				"atun.io/host/db.internal": `{"proto":"ssm","remote":5432,"local":15432}`,
+			},
+		},
+		{
+			name: "required tags are missing",
+			tags: map[string]string{"Name": "atun-router"},
+			want: []string{
+				"atun.io/version: tag is missing",
+				"atun.io/env: tag is missing",
+				"atun.io/host/<hostname>: router has no endpoints",
+			},
+			wantFix: "set it to 2",
+		},
+		{
+			name: "v1 hosts without a version",
+			tags: map[string]string{
+				"atun.io/env":              "dev",
+				"atun.io/host/db.internal": `{"ports":[{"remote":5432,"local":15432}]}`,
+			},
+			want: []string{
+				"atun.io/version: tag is missing",
+				"atun.io/host/db.internal: unknown field ports",
+				"atun.io/host/db.internal: field local is missing",
+				"atun.io/host/db.internal: field proto is missing",
+				"atun.io/host/db.internal: field remote is missing",
+			},
+		},
+		{
+			name: "unsupported version is checked as the latest one",
+			tags: map[string]string{
+				"atun.io/version":          "3",
+				"atun.io/env":              "dev",
+				"atun.io/host/db.internal": `{"ports":[{"remote":5432,"local":15432}]}`,
+			},
+			want:    []string{`atun.io/version: value "3" is not supported`},
+			wantFix: "set it to one of: 1, 2",
+		},
+		{
+			name: "misspelled tag",
+			tags: map[string]string{
+				"atun.io/version":          "2",
+				"atun.io/env":              "dev",
+				"atun.io/prority":          "10",
+				"atun.io/host/db.internal": `{"ports":[{"remote":5432,"local":15432}]}`,
+			},
+			want:    []string{"atun.io/prority: unknown tag"},
+			wantFix: "rename it to atun.io/priority",
+		},
+		{
+			name: "misspelled host prefix",
+			tags: map[string]string{
+				"atun.io/version":           "2",
+				"atun.io/env":               "dev",
+				"atun.io/hosts/db.internal": `{"ports":[{"remote":5432,"local":15432}]}`,
+			},
+			want: []string{
+				"atun.io/host/<hostname>: router has no endpoints",
+				"atun.io/hosts/db.internal: unknown tag",
+			},
+		},
+		{
+			name: "unknown tag",
+			tags: map[string]string{
+				"atun.io/version":          "2",
+				"atun.io/env":              "dev",
+				"atun.io/owner":            "platform",
+				"atun.io/host/db.internal": `{"ports":[{"remote":5432,"local":15432}]}`,
+			},
+			want:    []string{"atun.io/owner: unknown tag"},
+			wantFix: "remove it, atun doesn't read it",
+		},
+		{
+			name: "invalid router tag values",
+			tags: map[string]string{
+				"atun.io/version":          "2",
+				"atun.io/env":              " ",
+				"atun.io/priority":         "high",
+				"atun.io/transport":        "ssh",
+				"atun.io/user":             "Admin",
+				"atun.io/ad-hoc":           "yes",
+				"atun.io/host/db.internal": `{"ports":[{"remote":5432,"local":15432}]}`,
+			},
+			want: []string{
+				`atun.io/ad-hoc: value "yes" is not supported`,
+				"atun.io/env: value is empty",
+				`atun.io/priority: value "high" is not a number`,
+				`atun.io/transport: value "ssh" is not supported`,
+				`atun.io/user: value "Admin" is not a valid user name`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violations := Validate(tt.tags)
+			if got := problems(violations); !slices.Equal(got, tt.want) {
+				t.Fatalf("Validate() = %q, want %q", got, tt.want)
+			}
+			if tt.wantFix != "" && violations[0].Fix != tt.wantFix {
+				t.Errorf("Validate() fix = %q, want %q", violations[0].Fix, tt.wantFix)
+			}
+		})
+	}
+}
+
+func TestValidateHost(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+		key     string
+		value   string
+		want    []string
+	}{
+		{
+			name:    "valid v1",
+			version: "1",
+			value:   `{"local":15432,"proto":"ssm","remote":5432}`,
+		},
+		{
+			name:    "v1 local port as a string",
+			version: "1",
+			value:   `{"local":"15432","proto":"ssm","remote":5432}`,
+			want:    []string{`local "15432" is a string`},
+		},
+		{
+			name:    "v1 with invalid values",
+			version: "1",
+			value:   `{"local":"postgres","proto":"tcp","remote":0,"alias":"db"}`,
+			want: []string{
+				"unknown field alias",
+				"remote 0 is not a valid port",
+				`local "postgres" is not a valid port`,
+				`proto "tcp" is not supported`,
+			},
+		},
+		{
+			name:    "valid v2",
+			version: "2",
+			value:   `{"ports":[{"remote":443,"local":0}],"transport":"ssh","health_check":"http"}`,
+		},
+		{
+			name:    "v2 with typos",
+			version: "2",
+			value:   `{"ports":[{"remote":5432,"locl":15432}],"transprt":"ssm","healthcheck":"tcp"}`,
+			want: []string{
+				"unknown field healthcheck",
+				"unknown field transprt",
+				"unknown field ports[0].locl",
+				"field ports[0].local is missing",
+			},
+		},
+		{
+			name:    "v2 with invalid values",
+			version: "2",
+			value:   `{"ports":[{"remote":70000,"local":-1},"5432"],"transport":"eice","health_check":"grpc","alias":"primary db","description":1,"labels":{"tier":1}}`,
+			want: []string{
+				`transport "eice" is not supported`,
+				`health_check "grpc" is not supported`,
+				"ports[0].remote 70000 is not a valid port",
+				"ports[0].local -1 is not a valid port",
+				"ports[1] is not an object",
+				`alias "primary db" is not valid`,
+				"description 1 is not a string",
+				`labels {"tier":1} are not strings`,
+			},
+		},
+		{
+			name:    "v2 without ports",
+			version: "2",
+			value:   `{"transport":"ssm"}`,
+			want:    []string{"field ports is missing"},
+		},
+		{
+			name:    "v2 with empty ports",
+			version: "2",
+			value:   `{"ports":[]}`,
+			want:    []string{"ports is empty"},
+		},
+		{
+			name:    "v2 with ports that are not a list",
+			version: "2",
+			value:   `{"ports":{"remote":5432}}`,
+			want:    []string{"ports is not a list"},
+		},
+		{
+			name:    "not a JSON object",
+			version: "2",
+			value:   `{"ports":[{"remote":5432,"local":15432}]`,
+			want:    []string{"value is not a JSON object: unexpected EOF"},
+		},
+		{
+			name:    "data after the object",
+			version: "2",
+			value:   `{"ports":[{"remote":5432,"local":15432}]} {}`,
+			want:    []string{"value is not a JSON object: unexpected data after the object"},
+		},
+		{
+			name:    "null",
+			version: "2",
+			value:   `null`,
+			want:    []string{"value is not a JSON object: value is null"},
+		},
+		{
+			name:    "empty hostname",
+			version: "2",
+			key:     HostTagPrefix,
+			value:   `{"ports":[{"remote":5432,"local":15432}]}`,
+			want:    []string{"hostname is empty"},
+		},
+		{
+			name:    "value longer than AWS allows",
+			version: "2",
+			value:   `{"ports":[{"remote":5432,"local":15432}],"description":"` + strings.Repeat("a", MaxTagValueLength) + `"}`,
+			want:    []string{"value is 314 characters long, AWS allows 256"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := tt.key
+			if key == "" {
+				key = HostTagPrefix + "db.internal"
+			}
+
+			violations := ValidateHost(tt.version, key, tt.value)
+			for _, v := range violations {
+				if v.Key != key {
+					t.Errorf("violation key = %q, want %q", v.Key, key)
+				}
+				if v.Fix == "" {
+					t.Errorf("violation %q has no fix", v.Problem)
+				}
+			}
+
+			var got []string
+			for _, v := range violations {
+				got = append(got, v.Problem)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("ValidateHost() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// jsonSchema is the part of schemas/schema.json the validator mirrors
+type jsonSchema struct {
+	Type       string                `json:"type"`
+	Enum       []string              `json:"enum"`
+	Pattern    string                `json:"pattern"`
+	Required   []string              `json:"required"`
+	Properties map[string]jsonSchema `json:"properties"`
+	Items      *jsonSchema           `json:"items"`
+	Ref        string                `json:"$ref"`
+}
+
+func TestValidatorMatchesSchema(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "..", "schemas", "schema.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var document struct {
+		jsonSchema
+		Definitions map[string]jsonSchema `json:"definitions"`
+	}
+	if err := json.Unmarshal(data, &document); err != nil {
+		t.Fatalf("can't parse schema.json: %v", err)
+	}
+
+	sorted := func(values []string) []string {
+		values = slices.Clone(values)
+		sort.Strings(values)
+		return values
+	}
+	keys := func(properties map[string]jsonSchema) []string {
+		var names []string
+		for name := range properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	// Router tags
+	var schemaTags []string
+	for key, property := range document.Properties {
+		if key == "atun.io/host" {
+			continue
+		}
+		schemaTags = append(schemaTags, key)
+
+		allowed, ok := routerTags[key]
+		if !ok {
+			t.Errorf("tag %s of schema.json is unknown to the validator", key)
+			continue
+		}
+		if !slices.Equal(sorted(allowed), sorted(property.Enum)) {
+			t.Errorf("values of %s = %q, schema.json has %q", key, allowed, property.Enum)
+		}
+	}
+	var validatorTags []string
+	for key := range routerTags {
+		validatorTags = append(validatorTags, key)
+	}
+	if !slices.Equal(sorted(validatorTags), sorted(schemaTags)) {
+		t.Errorf("router tags = %q, schema.json has %q", sorted(validatorTags), sorted(schemaTags))
+	}
+
+	for key, pattern := range map[string]string{
+		"atun.io/priority": priorityPattern.String(),
+		"atun.io/user":     userPattern.String(),
+	} {
+		if got := document.Properties[key].Pattern; got != pattern {
+			t.Errorf("pattern of %s = %q, schema.json has %q", key, pattern, got)
+		}
+	}
+
+	// Enums of host tags
+	if got := document.Definitions["transport"].Enum; !slices.Equal(sorted(transports), sorted(got)) {
+		t.Errorf("transports = %q, schema.json has %q", transports, got)
+	}
+
+	hostV1 := document.Definitions["hostV1"]
+	if got := keys(hostV1.Properties); !slices.Equal(sorted(hostV1Fields), got) {
+		t.Errorf("v1 host fields = %q, schema.json has %q", hostV1Fields, got)
+	}
+	if !slices.Equal(sorted(hostV1Fields), sorted(hostV1.Required)) {
+		t.Errorf("required v1 host fields = %q, schema.json has %q", hostV1Fields, hostV1.Required)
+	}
+
+	hostV2 := document.Definitions["hostV2"]
+	if got := keys(hostV2.Properties); !slices.Equal(sorted(hostV2Fields), got) {
+		t.Errorf("v2 host fields = %q, schema.json has %q", hostV2Fields, got)
+	}
+	if got := hostV2.Properties["health_check"].Enum; !slices.Equal(sorted(healthChecks), sorted(got)) {
+		t.Errorf("health checks = %q, schema.json has %q", healthChecks, got)
+	}
+	if got := hostV2.Properties["transport"].Ref; got != "#/definitions/transport" {
+		t.Errorf("v2 transport refers to %q, the validator checks it against transports", got)
+	}
+	if got := hostV2.Properties["alias"].Pattern; got != aliasPattern.String() {
+		t.Errorf("alias pattern = %q, schema.json has %q", aliasPattern.String(), got)
+	}
+
+	ports := hostV2.Properties["ports"].Items
+	if ports == nil {
+		t.Fatal("schema.json has no items of ports")
+	}
+	if got := keys(ports.Properties); !slices.Equal(sorted(portFields), got) {
+		t.Errorf("port fields = %q, schema.json has %q", portFields, got)
+	}
+	if !slices.Equal(sorted(portFields), sorted(ports.Required)) {
+		t.Errorf("required port fields = %q, schema.json has %q", portFields, ports.Required)
+	}
+}
@@ -0,0 +1,281 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package ssh
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/DimmKirr/atun/internal/config"
+	"github.com/DimmKirr/atun/internal/constraints"
+	"github.com/DimmKirr/atun/internal/logger"
+	"github.com/pterm/pterm"
+	ssh2 "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/term"
+)
+
+// agentKeyFile keeps the public key of the agent identity tunnels authenticate with. ssh signs with the agent when -i points to a public key
+const agentKeyFile = "agent-id.pub"
+
+// passphraseAttempts is how many times the passphrase of an encrypted key is asked for, like ssh does
+const passphraseAttempts = 3
+
+var (
+	// unlockedKeys keeps private keys decrypted with a passphrase for the run of atun (e.g. reconnects in watch mode), by key path
+	unlockedKeys   = map[string]interface{}{}
+	unlockedKeysMu sync.Mutex
+)
+
+// UsesAgent reports whether tunnels authenticate with a key of the ssh-agent (ssh_agent)
+func UsesAgent(app *config.Atun) bool {
+	return app.Config.SSHAgent
+}
+
+// AgentIdentities lists keys of the ssh-agent at SSH_AUTH_SOCK
+func AgentIdentities() ([]*agent.Key, error) {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return nil, fmt.Errorf("SSH_AUTH_SOCK is not set, is ssh-agent running?")
+	}
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("can't connect to ssh-agent: %w", err)
+	}
+	defer conn.Close()
+
+	keys, err := agent.NewClient(conn).List()
+	if err != nil {
+		return nil, fmt.Errorf("can't list ssh-agent keys: %w", err)
+	}
+	logger.Debug("ssh-agent keys", "count", len(keys))
+
+	return keys, nil
+}
+
+// UnlockKey prepares the key tunnels authenticate with before connecting, as it may need input:
+// with ssh_agent it picks the agent key, for an encrypted ssh_key_path that's not in the agent it asks for the passphrase
+func UnlockKey(app *config.Atun) error {
+	if UsesAgent(app) {
+		return useAgentKey(app)
+	}
+
+	if UsesSessionKey(app) {
+		return nil
+	}
+
+	_, err := unlockPrivateKey(app.Config.SSHKeyPath)
+	return err
+}
+
+// useAgentKey points app.Config.SSHKeyPath to the public key of the agent key picked with ssh_agent_key
+func useAgentKey(app *config.Atun) error {
+	keys, err := AgentIdentities()
+	if err != nil {
+		return err
+	}
+
+	if len(keys) == 0 {
+		return fmt.Errorf("ssh-agent has no keys, add one with ssh-add")
+	}
+
+	key, err := selectAgentKey(keys, app.Config.SSHAgentKey)
+	if err != nil {
+		return err
+	}
+	logger.Debug("Using ssh-agent key", "fingerprint", ssh2.FingerprintSHA256(key), "comment", key.Comment)
+
+	if err := os.MkdirAll(app.Config.TunnelDir, 0700); err != nil {
+		return fmt.Errorf("can't create tunnel directory: %w", err)
+	}
+
+	keyPath := filepath.Join(app.Config.TunnelDir, agentKeyFile)
+	if err := os.WriteFile(keyPath, []byte(key.String()+"\n"), 0644); err != nil {
+		return fmt.Errorf("can't write ssh-agent public key: %w", err)
+	}
+	app.Config.SSHKeyPath = keyPath
+
+	return nil
+}
+
+// selectAgentKey returns the agent key matching ssh_agent_key (a fingerprint or a part of the comment), asks which one to use if there are several or returns the only one
+func selectAgentKey(keys []*agent.Key, configured string) (*agent.Key, error) {
+	if configured != "" {
+		for _, key := range keys {
+			if ssh2.FingerprintSHA256(key) == configured || strings.Contains(key.Comment, configured) {
+				return key, nil
+			}
+		}
+
+		return nil, fmt.Errorf("ssh-agent has no key matching %s", configured)
+	}
+
+	if len(keys) == 1 || !constraints.IsInteractiveTerminal() {
+		if len(keys) > 1 {
+			logger.Warn("Several ssh-agent keys found. Using the first one (set ssh_agent_key to choose)", "key", keys[0].Comment)
+		}
+		return keys[0], nil
+	}
+
+	var options []string
+	for _, key := range keys {
+		options = append(options, fmt.Sprintf("%s %s", ssh2.FingerprintSHA256(key), key.Comment))
+	}
+
+	selected, err := pterm.DefaultInteractiveSelect.
+		WithDefaultText(fmt.Sprintf(" %s  Select ssh-agent key", pterm.LightBlue("?"))).
+		WithOptions(options).
+		Show()
+	if err != nil {
+		return nil, fmt.Errorf("error selecting ssh-agent key: %w", err)
+	}
+
+	for i, option := range options {
+		if option == selected {
+			return keys[i], nil
+		}
+	}
+
+	return keys[0], nil
+}
+
+// unlockPrivateKey returns the private key at path decrypted with a passphrase asked for in the terminal.
+// It returns nil if the key isn't encrypted or the agent has it already, ssh uses the agent then
+func unlockPrivateKey(path string) (interface{}, error) {
+	unlockedKeysMu.Lock()
+	defer unlockedKeysMu.Unlock()
+
+	if key, ok := unlockedKeys[path]; ok {
+		return key, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keys that aren't encrypted (or aren't private keys at all) are up to ssh
+	_, err = ssh2.ParseRawPrivateKey(data)
+	var passphraseErr *ssh2.PassphraseMissingError
+	if !errors.As(err, &passphraseErr) {
+		return nil, nil
+	}
+
+	if publicKey, err := encryptedKeyPublicKey(path, passphraseErr); err == nil && agentHasKey(publicKey) {
+		logger.Debug("Encrypted key is in ssh-agent", "path", path)
+		return nil, nil
+	}
+
+	if !constraints.IsInteractiveTerminal() {
+		return nil, fmt.Errorf("%s is encrypted and there is no terminal to ask for the passphrase. Add the key to ssh-agent with ssh-add", path)
+	}
+
+	for attempt := 1; ; attempt++ {
+		fmt.Fprintf(os.Stderr, " %s  Enter passphrase for %s: ", pterm.LightBlue("?"), path)
+		passphrase, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return nil, fmt.Errorf("can't read passphrase: %w", err)
+		}
+
+		key, err := ssh2.ParseRawPrivateKeyWithPassphrase(data, passphrase)
+		if err == nil {
+			unlockedKeys[path] = key
+			return key, nil
+		}
+
+		if !errors.Is(err, x509.IncorrectPasswordError) || attempt == passphraseAttempts {
+			return nil, fmt.Errorf("can't decrypt %s: %w", path, err)
+		}
+		pterm.Warning.Println("Wrong passphrase, try again")
+	}
+}
+
+// encryptedKeyPublicKey returns the public key of an encrypted private key: from the key itself (OpenSSH format) or from the .pub file next to it
+func encryptedKeyPublicKey(path string, passphraseErr *ssh2.PassphraseMissingError) (ssh2.PublicKey, error) {
+	if passphraseErr.PublicKey != nil {
+		return passphraseErr.PublicKey, nil
+	}
+
+	data, err := os.ReadFile(path + ".pub")
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, _, _, _, err := ssh2.ParseAuthorizedKey(data)
+	return publicKey, err
+}
+
+// agentHasKey reports whether the ssh-agent at SSH_AUTH_SOCK has the key
+func agentHasKey(publicKey ssh2.PublicKey) bool {
+	keys, err := AgentIdentities()
+	if err != nil {
+		return false
+	}
+
+	for _, key := range keys {
+		if bytes.Equal(key.Marshal(), publicKey.Marshal()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// serveUnlockedKey serves the decrypted ssh_key_path to ssh with an agent of atun while the tunnel authenticates, so ssh doesn't ask for the passphrase again.
+// It returns the SSH_AUTH_SOCK variable for ssh (nil if the key doesn't need it) and a function stopping the agent
+func serveUnlockedKey(app *config.Atun) ([]string, func(), error) {
+	if UsesAgent(app) || UsesSessionKey(app) {
+		return nil, func() {}, nil
+	}
+
+	key, err := unlockPrivateKey(app.Config.SSHKeyPath)
+	if err != nil || key == nil {
+		return nil, func() {}, err
+	}
+
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: key, Comment: app.Config.SSHKeyPath}); err != nil {
+		return nil, func() {}, fmt.Errorf("can't add key to agent: %w", err)
+	}
+
+	socket := filepath.Join(app.Config.TunnelDir, fmt.Sprintf("%s-agent.sock", app.Config.RouterHostID))
+	_ = os.Remove(socket)
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, func() {}, fmt.Errorf("can't start agent: %w", err)
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+				_ = agent.ServeAgent(keyring, conn)
+			}()
+		}
+	}()
+
+	stop := func() {
+		_ = listener.Close()
+		_ = os.Remove(socket)
+	}
+
+	return []string{"SSH_AUTH_SOCK=" + socket}, stop, nil
+}
@@ -0,0 +1,334 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/DimmKirr/atun/internal/config"
+	ssh2 "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// testKey is a generated ed25519 key written to a temp dir
+type testKey struct {
+	path       string
+	privateKey ed25519.PrivateKey
+	publicKey  ssh2.PublicKey
+}
+
+// writeTestKey generates an ed25519 key and writes it in OpenSSH format (encrypted with a non-empty passphrase) with its .pub file
+func writeTestKey(t *testing.T, dir string, name string, passphrase string) testKey {
+	t.Helper()
+
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var block *pem.Block
+	if passphrase == "" {
+		block, err = ssh2.MarshalPrivateKey(privateKey, name)
+	} else {
+		block, err = ssh2.MarshalPrivateKeyWithPassphrase(privateKey, name, []byte(passphrase))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publicKey, err := ssh2.NewPublicKey(privateKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path+".pub", ssh2.MarshalAuthorizedKey(publicKey), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return testKey{path: path, privateKey: privateKey, publicKey: publicKey}
+}
+
+// startTestAgent serves an ssh-agent with the keys and points SSH_AUTH_SOCK to it
+func startTestAgent(t *testing.T, keys ...testKey) {
+	t.Helper()
+
+	keyring := agent.NewKeyring()
+	for _, key := range keys {
+		if err := keyring.Add(agent.AddedKey{PrivateKey: key.privateKey, Comment: filepath.Base(key.path)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Unix socket paths are limited to ~100 characters, t.TempDir() can be longer
+	socketDir, err := os.MkdirTemp("", "atun-agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(socketDir) })
+
+	listener, err := net.Listen("unix", filepath.Join(socketDir, "agent.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_ = agent.ServeAgent(keyring, conn)
+			}()
+		}
+	}()
+
+	t.Setenv("SSH_AUTH_SOCK", listener.Addr().String())
+}
+
+func TestUnlockPrivateKey(t *testing.T) {
+	dir := t.TempDir()
+	plain := writeTestKey(t, dir, "id_plain", "")
+	inAgent := writeTestKey(t, dir, "id_in_agent", "secret")
+	encrypted := writeTestKey(t, dir, "id_encrypted", "secret")
+	startTestAgent(t, inAgent)
+
+	// Tests don't run in an interactive terminal, so the passphrase is never asked for
+	tests := []struct {
+		name    string
+		path    string
+		wantErr string
+	}{
+		{name: "plain key is up to ssh", path: plain.path},
+		{name: "encrypted key in ssh-agent is up to ssh", path: inAgent.path},
+		{name: "encrypted key without a terminal", path: encrypted.path, wantErr: "Add the key to ssh-agent with ssh-add"},
+		{name: "missing key", path: filepath.Join(dir, "id_missing"), wantErr: "no such file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := unlockPrivateKey(tt.path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("unlockPrivateKey() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || key != nil {
+				t.Errorf("unlockPrivateKey() = %v, %v, want nil, nil", key, err)
+			}
+		})
+	}
+
+	// A key unlocked once is reused, e.g. on reconnects in watch mode
+	unlockedKeysMu.Lock()
+	unlockedKeys[encrypted.path] = encrypted.privateKey
+	unlockedKeysMu.Unlock()
+	t.Cleanup(func() {
+		unlockedKeysMu.Lock()
+		delete(unlockedKeys, encrypted.path)
+		unlockedKeysMu.Unlock()
+	})
+
+	key, err := unlockPrivateKey(encrypted.path)
+	if err != nil || key == nil {
+		t.Errorf("unlockPrivateKey() of an unlocked key = %v, %v", key, err)
+	}
+}
+
+func TestEncryptedKeyPublicKey(t *testing.T) {
+	key := writeTestKey(t, t.TempDir(), "id_encrypted", "secret")
+
+	// OpenSSH keys carry the public key, other formats have it in the .pub file
+	for _, passphraseErr := range []*ssh2.PassphraseMissingError{{PublicKey: key.publicKey}, {}} {
+		publicKey, err := encryptedKeyPublicKey(key.path, passphraseErr)
+		if err != nil {
+			t.Fatalf("encryptedKeyPublicKey() returned an error: %v", err)
+		}
+		if ssh2.FingerprintSHA256(publicKey) != ssh2.FingerprintSHA256(key.publicKey) {
+			t.Errorf("encryptedKeyPublicKey() = %s, want %s", ssh2.FingerprintSHA256(publicKey), ssh2.FingerprintSHA256(key.publicKey))
+		}
+	}
+}
+
+func TestUnlockKey(t *testing.T) {
+	dir := t.TempDir()
+	work := writeTestKey(t, dir, "id_work", "")
+	personal := writeTestKey(t, dir, "id_personal", "")
+	encrypted := writeTestKey(t, dir, "id_encrypted", "secret")
+
+	tests := []struct {
+		name     string
+		agent    []testKey
+		noAgent  bool
+		sshAgent bool
+		agentKey string
+		keyPath  string
+		wantKey  ssh2.PublicKey
+		wantErr  bool
+	}{
+		{
+			name:     "agent key by comment",
+			agent:    []testKey{work, personal},
+			sshAgent: true,
+			agentKey: "personal",
+			wantKey:  personal.publicKey,
+		},
+		{
+			name:     "agent key by fingerprint",
+			agent:    []testKey{work, personal},
+			sshAgent: true,
+			agentKey: ssh2.FingerprintSHA256(personal.publicKey),
+			wantKey:  personal.publicKey,
+		},
+		{
+			name:     "first of several agent keys",
+			agent:    []testKey{work, personal},
+			sshAgent: true,
+			wantKey:  work.publicKey,
+		},
+		{
+			name:     "no matching agent key",
+			agent:    []testKey{work},
+			sshAgent: true,
+			agentKey: "personal",
+			wantErr:  true,
+		},
+		{
+			name:     "empty agent",
+			sshAgent: true,
+			wantErr:  true,
+		},
+		{
+			name:     "agent isn't running",
+			noAgent:  true,
+			sshAgent: true,
+			wantErr:  true,
+		},
+		{
+			name:    "plain key",
+			keyPath: work.path,
+		},
+		{
+			name:    "encrypted key in the agent",
+			agent:   []testKey{encrypted},
+			keyPath: encrypted.path,
+		},
+		{
+			name:    "encrypted key without the agent",
+			noAgent: true,
+			keyPath: encrypted.path,
+			wantErr: true,
+		},
+		{
+			name: "session key",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.noAgent {
+				t.Setenv("SSH_AUTH_SOCK", "")
+			} else {
+				startTestAgent(t, tt.agent...)
+			}
+
+			app := &config.Atun{Config: &config.Config{
+				TunnelDir:   t.TempDir(),
+				SSHAgent:    tt.sshAgent,
+				SSHAgentKey: tt.agentKey,
+				SSHKeyPath:  tt.keyPath,
+			}}
+
+			err := UnlockKey(app)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("UnlockKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantKey == nil {
+				if app.Config.SSHKeyPath != tt.keyPath {
+					t.Errorf("SSHKeyPath = %q, want it unchanged (%q)", app.Config.SSHKeyPath, tt.keyPath)
+				}
+				return
+			}
+
+			// ssh signs with the agent key its public key points to
+			if want := filepath.Join(app.Config.TunnelDir, agentKeyFile); app.Config.SSHKeyPath != want {
+				t.Fatalf("SSHKeyPath = %q, want %q", app.Config.SSHKeyPath, want)
+			}
+			data, err := os.ReadFile(app.Config.SSHKeyPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			publicKey, _, _, _, err := ssh2.ParseAuthorizedKey(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ssh2.FingerprintSHA256(publicKey) != ssh2.FingerprintSHA256(tt.wantKey) {
+				t.Errorf("agent key = %s, want %s", ssh2.FingerprintSHA256(publicKey), ssh2.FingerprintSHA256(tt.wantKey))
+			}
+		})
+	}
+}
+
+func TestServeUnlockedKey(t *testing.T) {
+	dir := t.TempDir()
+	plain := writeTestKey(t, dir, "id_plain", "")
+	encrypted := writeTestKey(t, dir, "id_encrypted", "secret")
+
+	// Plain keys are read by ssh itself, no agent is started
+	app := &config.Atun{Config: &config.Config{TunnelDir: dir, RouterHostID: "i-router", SSHKeyPath: plain.path}}
+	env, stop, err := serveUnlockedKey(app)
+	stop()
+	if err != nil || env != nil {
+		t.Errorf("serveUnlockedKey() of a plain key = %q, %v, want no agent", env, err)
+	}
+
+	// An unlocked key is served to ssh by an agent of atun
+	unlockedKeysMu.Lock()
+	unlockedKeys[encrypted.path] = encrypted.privateKey
+	unlockedKeysMu.Unlock()
+	t.Cleanup(func() {
+		unlockedKeysMu.Lock()
+		delete(unlockedKeys, encrypted.path)
+		unlockedKeysMu.Unlock()
+	})
+
+	socketDir, err := os.MkdirTemp("", "atun-tunnel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(socketDir) })
+
+	app = &config.Atun{Config: &config.Config{TunnelDir: socketDir, RouterHostID: "i-router", SSHKeyPath: encrypted.path}}
+	env, stop, err = serveUnlockedKey(app)
+	if err != nil {
+		t.Fatalf("serveUnlockedKey() returned an error: %v", err)
+	}
+	defer stop()
+
+	if len(env) != 1 || !strings.HasPrefix(env[0], "SSH_AUTH_SOCK=") {
+		t.Fatalf("serveUnlockedKey() = %q, want SSH_AUTH_SOCK", env)
+	}
+	t.Setenv("SSH_AUTH_SOCK", strings.TrimPrefix(env[0], "SSH_AUTH_SOCK="))
+
+	if !agentHasKey(encrypted.publicKey) {
+		t.Error("agent of atun doesn't serve the unlocked key")
+	}
+}
@@ -0,0 +1,92 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package ssh
+
+import (
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+
+	ssh2 "golang.org/x/crypto/ssh"
+)
+
+// AuthorizedKey is a key from authorized_keys of a router
+type AuthorizedKey struct {
+	Type        string
+	Fingerprint string
+	Comment     string
+	// Blob is the base64 encoded key. It identifies the key regardless of options and comment of the line
+	Blob string
+	// Line is the key as it's written in authorized_keys
+	Line string
+	// Session is true for session keys of atun (comment with SessionKeyMarker). Created and Owner are set for them only
+	Session bool
+	Created time.Time
+	// Owner is <env>@<hostname> of the machine the session ran on
+	Owner string
+}
+
+// Age returns how long ago a session key was added, 0 for other keys
+func (k AuthorizedKey) Age() time.Duration {
+	if k.Created.IsZero() {
+		return 0
+	}
+
+	return time.Since(k.Created)
+}
+
+// OwnedBy reports whether a session key belongs to owner: either the whole <env>@<hostname> or just the hostname, matched exactly
+func (k AuthorizedKey) OwnedBy(owner string) bool {
+	if !k.Session || owner == "" {
+		return false
+	}
+
+	if k.Owner == owner {
+		return true
+	}
+
+	_, hostname, found := strings.Cut(k.Owner, "@")
+	return found && !strings.Contains(owner, "@") && hostname == owner
+}
+
+// ParseAuthorizedKeys parses the contents of an authorized_keys file. Empty lines, comments and lines that aren't keys are skipped
+func ParseAuthorizedKeys(data string) []AuthorizedKey {
+	var keys []AuthorizedKey
+
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		publicKey, comment, _, _, err := ssh2.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			continue
+		}
+
+		key := AuthorizedKey{
+			Type:        publicKey.Type(),
+			Fingerprint: ssh2.FingerprintSHA256(publicKey),
+			Comment:     comment,
+			Blob:        base64.StdEncoding.EncodeToString(publicKey.Marshal()),
+			Line:        line,
+		}
+
+		// atun-session:<created unix time>:<env>@<hostname>
+		if parts := strings.SplitN(comment, ":", 3); len(parts) == 3 && parts[0] == SessionKeyMarker {
+			if created, err := strconv.ParseInt(parts[1], 10, 64); err == nil {
+				key.Session = true
+				key.Created = time.Unix(created, 0)
+				key.Owner = parts[2]
+			}
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys
+}
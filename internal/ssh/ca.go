@@ -0,0 +1,156 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package ssh
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/DimmKirr/atun/internal/config"
+	"github.com/DimmKirr/atun/internal/logger"
+	ssh2 "golang.org/x/crypto/ssh"
+)
+
+// SSH authentication modes (ssh_auth)
+const (
+	// AuthKey authorizes the session key in authorized_keys of the router
+	AuthKey = "key"
+	// AuthCA signs the session key with a user CA the router trusts (TrustedUserCAKeys), nothing is written to the router per user
+	AuthCA = "ca"
+)
+
+// sshConfigDir is the sshd configuration directory of routers
+const sshConfigDir = "/etc/ssh"
+
+// caKeyFileName is the file in sshConfigDir where routers keep the public key of the atun user CA
+const caKeyFileName = "atun_user_ca.pub"
+
+// caDropInFileName is the sshd_config.d drop-in that sets TrustedUserCAKeys
+const caDropInFileName = "atun.conf"
+
+// certClockSkew backdates certificates a bit, so a router with a clock behind doesn't reject them
+const certClockSkew = 5 * time.Minute
+
+// UsesCA reports whether routers are authenticated with certificates signed by the atun user CA
+func UsesCA(app *config.Atun) bool {
+	return app.Config.SSHAuth == AuthCA
+}
+
+// CertificatePath returns the path of the certificate of the session with the router
+func CertificatePath(app *config.Atun) string {
+	return filepath.Join(app.Config.TunnelDir, app.Config.RouterHostID+"-cert.pub")
+}
+
+// SignSessionCertificate signs the public key of the session (app.Config.SSHKeyPath) with the CA.
+// The certificate is valid for ssh_cert_ttl for the router user only and is written to CertificatePath
+func SignSessionCertificate(app *config.Atun, ca ssh2.Signer) (string, error) {
+	publicKey, err := GetSessionPublicKey(app)
+	if err != nil {
+		return "", err
+	}
+
+	key, _, _, _, err := ssh2.ParseAuthorizedKey([]byte(publicKey))
+	if err != nil {
+		return "", fmt.Errorf("can't parse session public key: %w", err)
+	}
+
+	var serial [8]byte
+	if _, err := rand.Read(serial[:]); err != nil {
+		return "", err
+	}
+
+	hostname, _ := os.Hostname()
+	now := time.Now()
+	certificate := &ssh2.Certificate{
+		Key:             key,
+		Serial:          binary.BigEndian.Uint64(serial[:]),
+		CertType:        ssh2.UserCert,
+		KeyId:           fmt.Sprintf("atun:%s@%s", app.Config.Env, hostname),
+		ValidPrincipals: []string{app.Config.RouterHostUser},
+		ValidAfter:      uint64(now.Add(-certClockSkew).Unix()),
+		ValidBefore:     uint64(now.Add(app.Config.SSHCertTTL).Unix()),
+		Permissions: ssh2.Permissions{
+			Extensions: map[string]string{
+				"permit-port-forwarding": "",
+				"permit-pty":             "",
+			},
+		},
+	}
+
+	if err := certificate.SignCert(rand.Reader, ca); err != nil {
+		return "", fmt.Errorf("can't sign session certificate: %w", err)
+	}
+
+	certificatePath := CertificatePath(app)
+	if err := os.WriteFile(certificatePath, ssh2.MarshalAuthorizedKey(certificate), 0644); err != nil {
+		return "", fmt.Errorf("can't write session certificate: %w", err)
+	}
+	logger.Debug("Signed session certificate", "path", certificatePath, "principal", app.Config.RouterHostUser, "validBefore", time.Unix(int64(certificate.ValidBefore), 0))
+
+	return certificatePath, nil
+}
+
+// ParseCAKey parses the private key of the user CA
+func ParseCAKey(data []byte) (ssh2.Signer, error) {
+	signer, err := ssh2.ParsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("can't parse SSH CA key: %w", err)
+	}
+
+	return signer, nil
+}
+
+// TrustCAScript returns a shell script that makes sshd trust user certificates signed by the CA.
+// A CA installed before is replaced, so the same script rotates the CA
+func TrustCAScript(caPublicKey string) string {
+	return trustCAScript(caPublicKey, sshConfigDir)
+}
+
+// trustCAScript sets TrustedUserCAKeys in a drop-in of sshd_config.d when sshd_config includes it before any Match block.
+// Otherwise it's appended to a copy of sshd_config under Match all, so it doesn't end up in a Match block of the config.
+// The config is checked with sshd -t before it's used, so a broken config never replaces a working one
+func trustCAScript(caPublicKey string, sshDir string) string {
+	return fmt.Sprintf(`set -e
+ssh_dir=%[1]s
+ca_key="$ssh_dir/%[2]s"
+dropin="$ssh_dir/sshd_config.d/%[3]s"
+printf '%%s\n' %[4]s > "$ca_key.atun"
+chmod 644 "$ca_key.atun"
+mv "$ca_key.atun" "$ca_key"
+if grep -qsE "^[[:space:]]*TrustedUserCAKeys[[:space:]]+$ca_key[[:space:]]*$" "$ssh_dir/sshd_config" "$ssh_dir"/sshd_config.d/*.conf; then
+  echo "sshd already trusts $ca_key"
+  sshd -t
+elif grep -qsiE "^[[:space:]]*TrustedUserCAKeys[[:space:]]" "$ssh_dir/sshd_config" "$ssh_dir"/sshd_config.d/*.conf; then
+  echo "sshd already trusts another CA (TrustedUserCAKeys in $ssh_dir/sshd_config). Add $ca_key to it manually" >&2
+  exit 1
+elif awk '/^[[:space:]]*[Mm]atch[[:space:]]/ { exit 1 } /^[[:space:]]*Include[[:space:]].*sshd_config\.d\/\*\.conf/ { found = 1; exit 0 } END { exit !found }' "$ssh_dir/sshd_config"; then
+  printf 'TrustedUserCAKeys %%s\n' "$ca_key" > "$dropin"
+  if ! sshd -t; then
+    rm -f "$dropin"
+    exit 1
+  fi
+else
+  cp -p "$ssh_dir/sshd_config" "$ssh_dir/sshd_config.atun"
+  printf '\n# Added by atun\nMatch all\nTrustedUserCAKeys %%s\n' "$ca_key" >> "$ssh_dir/sshd_config.atun"
+  if ! sshd -t -f "$ssh_dir/sshd_config.atun"; then
+    rm -f "$ssh_dir/sshd_config.atun"
+    exit 1
+  fi
+  mv "$ssh_dir/sshd_config.atun" "$ssh_dir/sshd_config"
+fi
+systemctl reload sshd 2>/dev/null || systemctl reload ssh 2>/dev/null || service ssh reload
+`,
+		ShellQuote(sshDir),
+		caKeyFileName,
+		caDropInFileName,
+		ShellQuote(strings.TrimSpace(caPublicKey)),
+	)
+}
@@ -0,0 +1,302 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DimmKirr/atun/internal/config"
+	ssh2 "golang.org/x/crypto/ssh"
+)
+
+// testCA returns a new ed25519 user CA
+func testCA(t *testing.T) ssh2.Signer {
+	t.Helper()
+
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := ssh2.NewSignerFromKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signer
+}
+
+func TestTrustCAScript(t *testing.T) {
+	caPublicKey := string(ssh2.MarshalAuthorizedKey(testCA(t).PublicKey()))
+
+	tests := []struct {
+		name       string
+		sshdConfig string
+		dropIns    map[string]string
+		// sshdFails makes sshd -t reject the config
+		sshdFails   bool
+		wantErr     bool
+		wantConfig  string
+		wantDropIn  string
+		wantReloads int
+	}{
+		{
+			name:        "drop-in when sshd_config.d is included first",
+			sshdConfig:  "Include /etc/ssh/sshd_config.d/*.conf\nPasswordAuthentication no\nMatch User sftp\n  ForceCommand internal-sftp\n",
+			wantConfig:  "Include /etc/ssh/sshd_config.d/*.conf\nPasswordAuthentication no\nMatch User sftp\n  ForceCommand internal-sftp\n",
+			wantDropIn:  "TrustedUserCAKeys {ca}\n",
+			wantReloads: 1,
+		},
+		{
+			name:        "Match all guard without sshd_config.d",
+			sshdConfig:  "PasswordAuthentication no\nMatch User sftp\n  ForceCommand internal-sftp\n",
+			wantConfig:  "PasswordAuthentication no\nMatch User sftp\n  ForceCommand internal-sftp\n\n# Added by atun\nMatch all\nTrustedUserCAKeys {ca}\n",
+			wantReloads: 1,
+		},
+		{
+			name:        "Match all guard when sshd_config.d is included in a Match block",
+			sshdConfig:  "Match User sftp\n  Include /etc/ssh/sshd_config.d/*.conf\n",
+			wantConfig:  "Match User sftp\n  Include /etc/ssh/sshd_config.d/*.conf\n\n# Added by atun\nMatch all\nTrustedUserCAKeys {ca}\n",
+			wantReloads: 1,
+		},
+		{
+			name:        "CA trusted already",
+			sshdConfig:  "PasswordAuthentication no\nTrustedUserCAKeys {ca}\n",
+			wantConfig:  "PasswordAuthentication no\nTrustedUserCAKeys {ca}\n",
+			wantReloads: 1,
+		},
+		{
+			name:        "CA trusted already in a drop-in",
+			sshdConfig:  "Include /etc/ssh/sshd_config.d/*.conf\n",
+			dropIns:     map[string]string{"atun.conf": "TrustedUserCAKeys {ca}\n"},
+			wantConfig:  "Include /etc/ssh/sshd_config.d/*.conf\n",
+			wantDropIn:  "TrustedUserCAKeys {ca}\n",
+			wantReloads: 1,
+		},
+		{
+			name:       "another CA",
+			sshdConfig: "Include /etc/ssh/sshd_config.d/*.conf\n",
+			dropIns:    map[string]string{"50-vault.conf": "TrustedUserCAKeys /etc/ssh/vault_ca.pub\n"},
+			wantErr:    true,
+			wantConfig: "Include /etc/ssh/sshd_config.d/*.conf\n",
+		},
+		{
+			name:       "broken config isn't used",
+			sshdConfig: "PasswordAuthentication no\n",
+			sshdFails:  true,
+			wantErr:    true,
+			wantConfig: "PasswordAuthentication no\n",
+		},
+		{
+			name:       "broken drop-in is removed",
+			sshdConfig: "Include /etc/ssh/sshd_config.d/*.conf\n",
+			sshdFails:  true,
+			wantErr:    true,
+			wantConfig: "Include /etc/ssh/sshd_config.d/*.conf\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sshDir := t.TempDir()
+			caKey := filepath.Join(sshDir, caKeyFileName)
+			expand := func(s string) string {
+				return strings.ReplaceAll(strings.ReplaceAll(s, "{ca}", caKey), "/etc/ssh", sshDir)
+			}
+
+			if err := os.MkdirAll(filepath.Join(sshDir, "sshd_config.d"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(sshDir, "sshd_config"), []byte(expand(tt.sshdConfig)), 0600); err != nil {
+				t.Fatal(err)
+			}
+			for name, content := range tt.dropIns {
+				if err := os.WriteFile(filepath.Join(sshDir, "sshd_config.d", name), []byte(expand(content)), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// sshd and systemctl of the router record their calls
+			binDir := t.TempDir()
+			log := filepath.Join(binDir, "log")
+			sshd := "#!/bin/sh\necho \"sshd $*\" >> " + log + "\n"
+			if tt.sshdFails {
+				sshd += "exit 255\n"
+			}
+			for name, script := range map[string]string{"sshd": sshd, "systemctl": "#!/bin/sh\necho \"systemctl $*\" >> " + log + "\n"} {
+				if err := os.WriteFile(filepath.Join(binDir, name), []byte(script), 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			cmd := exec.Command("sh", "-c", trustCAScript(caPublicKey, sshDir))
+			cmd.Env = append(os.Environ(), "PATH="+binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+			output, err := cmd.CombinedOutput()
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("script error = %v, wantErr %v: %s", err, tt.wantErr, output)
+			}
+
+			config, err := os.ReadFile(filepath.Join(sshDir, "sshd_config"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := string(config); got != expand(tt.wantConfig) {
+				t.Errorf("sshd_config =\n%s\nwant\n%s", got, expand(tt.wantConfig))
+			}
+
+			dropIn, _ := os.ReadFile(filepath.Join(sshDir, "sshd_config.d", caDropInFileName))
+			if got := string(dropIn); got != expand(tt.wantDropIn) {
+				t.Errorf("drop-in = %q, want %q", got, expand(tt.wantDropIn))
+			}
+
+			if _, err := os.Stat(filepath.Join(sshDir, "sshd_config.atun")); !os.IsNotExist(err) {
+				t.Errorf("copy of sshd_config is left behind: %v", err)
+			}
+
+			calls, _ := os.ReadFile(log)
+			if got := strings.Count(string(calls), "systemctl reload"); got != tt.wantReloads {
+				t.Errorf("sshd reloaded %d times, want %d: %s", got, tt.wantReloads, calls)
+			}
+			if tt.wantReloads > 0 && !strings.Contains(string(calls), "sshd -t") {
+				t.Errorf("config isn't checked with sshd -t before reload: %s", calls)
+			}
+
+			if tt.wantErr {
+				return
+			}
+			installed, err := os.ReadFile(caKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(installed) != caPublicKey {
+				t.Errorf("installed CA = %q, want %q", installed, caPublicKey)
+			}
+		})
+	}
+}
+
+func TestTrustCAScriptIsIdempotent(t *testing.T) {
+	sshDir := t.TempDir()
+	sshdConfig := filepath.Join(sshDir, "sshd_config")
+	if err := os.WriteFile(sshdConfig, []byte("PasswordAuthentication no\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	binDir := t.TempDir()
+	for _, name := range []string{"sshd", "systemctl"} {
+		if err := os.WriteFile(filepath.Join(binDir, name), []byte("#!/bin/sh\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Rotating the CA runs the script again with another key
+	for _, ca := range []ssh2.Signer{testCA(t), testCA(t)} {
+		cmd := exec.Command("sh", "-c", trustCAScript(string(ssh2.MarshalAuthorizedKey(ca.PublicKey())), sshDir))
+		cmd.Env = append(os.Environ(), "PATH="+binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("script failed: %v: %s", err, output)
+		}
+	}
+
+	config, err := os.ReadFile(sshdConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(string(config), "TrustedUserCAKeys"); got != 1 {
+		t.Errorf("sshd_config has %d TrustedUserCAKeys lines, want 1:\n%s", got, config)
+	}
+}
+
+func TestSignSessionCertificate(t *testing.T) {
+	app := &config.Atun{Config: &config.Config{
+		TunnelDir:      t.TempDir(),
+		RouterHostID:   "i-0123456789abcdef0",
+		RouterHostUser: "ec2-user",
+		Env:            "dev",
+		SSHCertTTL:     time.Hour,
+	}}
+	if _, err := EnsureSessionKey(app); err != nil {
+		t.Fatal(err)
+	}
+
+	ca := testCA(t)
+	before := time.Now()
+
+	certificatePath, err := SignSessionCertificate(app, ca)
+	if err != nil {
+		t.Fatalf("SignSessionCertificate() returned an error: %v", err)
+	}
+	if certificatePath != CertificatePath(app) {
+		t.Errorf("SignSessionCertificate() = %q, want %q", certificatePath, CertificatePath(app))
+	}
+
+	data, err := os.ReadFile(certificatePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _, _, _, err := ssh2.ParseAuthorizedKey(data)
+	if err != nil {
+		t.Fatalf("can't parse the certificate: %v", err)
+	}
+	certificate, ok := key.(*ssh2.Certificate)
+	if !ok {
+		t.Fatalf("%s is a %s, not a certificate", certificatePath, key.Type())
+	}
+
+	sessionKey, err := GetSessionPublicKey(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, _, _, _, err := ssh2.ParseAuthorizedKey([]byte(sessionKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(certificate.Key.Marshal()) != string(publicKey.Marshal()) {
+		t.Error("certificate isn't issued for the session key")
+	}
+
+	if certificate.CertType != ssh2.UserCert {
+		t.Errorf("CertType = %d, want a user certificate", certificate.CertType)
+	}
+	if len(certificate.ValidPrincipals) != 1 || certificate.ValidPrincipals[0] != "ec2-user" {
+		t.Errorf("ValidPrincipals = %v, want [ec2-user]", certificate.ValidPrincipals)
+	}
+
+	validAfter := time.Unix(int64(certificate.ValidAfter), 0)
+	validBefore := time.Unix(int64(certificate.ValidBefore), 0)
+	if want := before.Add(-certClockSkew); validAfter.After(want) || validAfter.Before(want.Add(-time.Minute)) {
+		t.Errorf("ValidAfter = %v, want about %v", validAfter, want)
+	}
+	if want := before.Add(time.Hour); validBefore.Before(want.Add(-time.Second)) || validBefore.After(want.Add(time.Minute)) {
+		t.Errorf("ValidBefore = %v, want about %v", validBefore, want)
+	}
+
+	// The router accepts it for its user only
+	checker := &ssh2.CertChecker{
+		IsUserAuthority: func(auth ssh2.PublicKey) bool {
+			return string(auth.Marshal()) == string(ca.PublicKey().Marshal())
+		},
+	}
+	if err := checker.CheckCert("ec2-user", certificate); err != nil {
+		t.Errorf("certificate is rejected for ec2-user: %v", err)
+	}
+	if err := checker.CheckCert("root", certificate); err == nil {
+		t.Error("certificate is accepted for root")
+	}
+	if !checker.IsUserAuthority(certificate.SignatureKey) {
+		t.Error("certificate isn't signed by the CA")
+	}
+	if _, ok := certificate.Permissions.Extensions["permit-port-forwarding"]; !ok {
+		t.Error("certificate doesn't permit port forwarding")
+	}
+}
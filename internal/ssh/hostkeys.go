@@ -0,0 +1,224 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package ssh
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/DimmKirr/atun/internal/config"
+	"github.com/DimmKirr/atun/internal/logger"
+	ssh2 "golang.org/x/crypto/ssh"
+)
+
+// HostKeysScript prints host keys of the router it runs on: from ssh-keyscan if it's there, from /etc/ssh otherwise
+const HostKeysScript = `keys=$(ssh-keyscan localhost 2>/dev/null | grep -v '^#')
+if [ -n "$keys" ]; then echo "$keys"; else cat /etc/ssh/ssh_host_*_key.pub; fi`
+
+// Host keys printed by cloud-init to the console output of EC2 instances are between these lines
+const (
+	consoleHostKeysBegin = "-----BEGIN SSH HOST KEY KEYS-----"
+	consoleHostKeysEnd   = "-----END SSH HOST KEY KEYS-----"
+)
+
+// HostKeyChange is a pinned host key of a router that the router doesn't have anymore
+type HostKeyChange struct {
+	Type    string
+	Pinned  string
+	Fetched string
+}
+
+// HostKeyError is returned when ssh rejects the host key of the router, i.e. it doesn't match the pinned one
+type HostKeyError struct {
+	Host       string
+	KnownHosts string
+	Output     string
+}
+
+func (e *HostKeyError) Error() string {
+	return fmt.Sprintf("host key of %s doesn't match the key pinned in %s: %s", e.Host, e.KnownHosts, e.Output)
+}
+
+// HostKeyChangedError is returned when host keys of a router differ from the pinned ones and re-pinning wasn't requested
+type HostKeyChangedError struct {
+	Host    string
+	Changes []HostKeyChange
+}
+
+func (e *HostKeyChangedError) Error() string {
+	var changes []string
+	for _, change := range e.Changes {
+		fetched := change.Fetched
+		if fetched == "" {
+			fetched = "none"
+		}
+		changes = append(changes, fmt.Sprintf("%s pinned %s, now %s", change.Type, change.Pinned, fetched))
+	}
+
+	return fmt.Sprintf("host keys of %s have changed (%s)", e.Host, strings.Join(changes, "; "))
+}
+
+// KnownHostsPath returns the path of known_hosts with pinned host keys of routers of the env
+func KnownHostsPath(app *config.Atun) string {
+	return filepath.Join(app.Config.TunnelDir, "known_hosts")
+}
+
+// ParseHostKeys parses host keys printed by ssh-keyscan (<host> <type> <key>) or read from ssh_host_*_key.pub (<type> <key> <comment>)
+func ParseHostKeys(output string) []ssh2.PublicKey {
+	var keys []ssh2.PublicKey
+
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, _, _, _, err := ssh2.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			// ssh-keyscan starts lines with the host name
+			if _, rest, ok := strings.Cut(line, " "); ok {
+				key, _, _, _, err = ssh2.ParseAuthorizedKey([]byte(rest))
+			}
+		}
+		if err != nil {
+			logger.Debug("Skipping line that's not a host key", "line", line)
+			continue
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+// ParseConsoleHostKeys returns host keys cloud-init printed to the console output of an instance
+func ParseConsoleHostKeys(consoleOutput string) []ssh2.PublicKey {
+	_, rest, ok := strings.Cut(consoleOutput, consoleHostKeysBegin)
+	if !ok {
+		return nil
+	}
+
+	block, _, _ := strings.Cut(rest, consoleHostKeysEnd)
+	return ParseHostKeys(block)
+}
+
+// PinnedHostKeys returns host keys of the host pinned in KnownHostsPath
+func PinnedHostKeys(app *config.Atun, host string) ([]ssh2.PublicKey, error) {
+	data, err := os.ReadFile(KnownHostsPath(app))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't read pinned host keys: %w", err)
+	}
+
+	var keys []ssh2.PublicKey
+	for len(data) > 0 {
+		var hosts []string
+		var key ssh2.PublicKey
+		_, hosts, key, _, data, err = ssh2.ParseKnownHosts(data)
+		if err != nil {
+			break
+		}
+
+		for _, h := range hosts {
+			if h == host {
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	return keys, nil
+}
+
+// PinHostKeys replaces host keys of the host in KnownHostsPath with the keys and returns pinned keys the host doesn't have anymore.
+// Changed keys are only replaced with repin, otherwise nothing is written and HostKeyChangedError is returned
+func PinHostKeys(app *config.Atun, host string, keys []ssh2.PublicKey, repin bool) ([]HostKeyChange, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no host keys of %s to pin", host)
+	}
+
+	pinned, err := PinnedHostKeys(app, host)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []HostKeyChange
+	for _, pinnedKey := range pinned {
+		if hasKey(keys, pinnedKey) {
+			continue
+		}
+
+		change := HostKeyChange{Type: pinnedKey.Type(), Pinned: ssh2.FingerprintSHA256(pinnedKey)}
+		for _, key := range keys {
+			if key.Type() == pinnedKey.Type() {
+				change.Fetched = ssh2.FingerprintSHA256(key)
+			}
+		}
+		changes = append(changes, change)
+	}
+
+	if len(changes) > 0 && !repin {
+		return changes, &HostKeyChangedError{Host: host, Changes: changes}
+	}
+
+	// Keep entries of other hosts as they are
+	var lines []string
+	if data, err := os.ReadFile(KnownHostsPath(app)); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if line == "" || strings.HasPrefix(line, host+" ") {
+				continue
+			}
+			lines = append(lines, line)
+		}
+	}
+
+	for _, key := range keys {
+		lines = append(lines, fmt.Sprintf("%s %s", host, bytes.TrimSpace(ssh2.MarshalAuthorizedKey(key))))
+	}
+
+	if err := os.MkdirAll(app.Config.TunnelDir, 0700); err != nil {
+		return nil, fmt.Errorf("can't create tunnel directory: %w", err)
+	}
+
+	if err := os.WriteFile(KnownHostsPath(app), []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		return nil, fmt.Errorf("can't write pinned host keys: %w", err)
+	}
+	logger.Debug("Pinned host keys", "host", host, "keys", len(keys), "path", KnownHostsPath(app))
+
+	return changes, nil
+}
+
+// hostKeyCheckingArgs returns ssh options checking the host key of the router against KnownHostsPath.
+// Routers with pinned keys are checked strictly, keys of other routers are pinned on the first connection
+func hostKeyCheckingArgs(app *config.Atun) []string {
+	if !app.Config.SSHStrictHostKeyChecking {
+		return []string{"-o", "StrictHostKeyChecking=no"}
+	}
+
+	checking := "accept-new"
+	if pinned, _ := PinnedHostKeys(app, app.Config.RouterHostID); len(pinned) > 0 {
+		checking = "yes"
+	}
+
+	return []string{
+		"-o", fmt.Sprintf("StrictHostKeyChecking=%s", checking),
+		"-o", fmt.Sprintf("UserKnownHostsFile=%s", KnownHostsPath(app)),
+	}
+}
+
+func hasKey(keys []ssh2.PublicKey, key ssh2.PublicKey) bool {
+	for _, k := range keys {
+		if bytes.Equal(k.Marshal(), key.Marshal()) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,238 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package ssh
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/DimmKirr/atun/internal/config"
+	ssh2 "golang.org/x/crypto/ssh"
+)
+
+// testHostKey returns a new ed25519 host key
+func testHostKey(t *testing.T) ssh2.PublicKey {
+	t.Helper()
+
+	key, _, _, _, err := ssh2.ParseAuthorizedKey([]byte(testPublicKey(t, "root@router")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+func authorizedKey(key ssh2.PublicKey) string {
+	return string(bytes.TrimSpace(ssh2.MarshalAuthorizedKey(key)))
+}
+
+func fingerprints(keys []ssh2.PublicKey) []string {
+	var list []string
+	for _, key := range keys {
+		list = append(list, ssh2.FingerprintSHA256(key))
+	}
+
+	return list
+}
+
+func TestParseHostKeys(t *testing.T) {
+	first, second := testHostKey(t), testHostKey(t)
+
+	tests := []struct {
+		name   string
+		output string
+		want   []ssh2.PublicKey
+	}{
+		{
+			name:   "ssh-keyscan",
+			output: "# localhost:22 SSH-2.0-OpenSSH_8.7\nlocalhost " + authorizedKey(first) + "\nlocalhost " + authorizedKey(second) + "\n",
+			want:   []ssh2.PublicKey{first, second},
+		},
+		{
+			name:   "ssh_host_*_key.pub",
+			output: authorizedKey(first) + " root@router\n\n" + authorizedKey(second) + "\n",
+			want:   []ssh2.PublicKey{first, second},
+		},
+		{
+			name:   "lines that aren't keys are skipped",
+			output: "cat: /etc/ssh/ssh_host_dsa_key.pub: No such file or directory\n" + authorizedKey(first) + "\nlocalhost not-a-key\n",
+			want:   []ssh2.PublicKey{first},
+		},
+		{
+			name:   "empty",
+			output: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fingerprints(ParseHostKeys(tt.output)); !slices.Equal(got, fingerprints(tt.want)) {
+				t.Errorf("ParseHostKeys() = %v, want %v", got, fingerprints(tt.want))
+			}
+		})
+	}
+}
+
+func TestParseConsoleHostKeys(t *testing.T) {
+	key := testHostKey(t)
+
+	consoleOutput := "Cloud-init v. 22.2.2 running\n" +
+		consoleHostKeysBegin + "\n" + authorizedKey(key) + "\n" + consoleHostKeysEnd + "\n" +
+		"ec2: " + authorizedKey(testHostKey(t)) + "\n"
+
+	if got := fingerprints(ParseConsoleHostKeys(consoleOutput)); !slices.Equal(got, fingerprints([]ssh2.PublicKey{key})) {
+		t.Errorf("ParseConsoleHostKeys() = %v, want the key between the markers only", got)
+	}
+
+	if got := ParseConsoleHostKeys("Cloud-init v. 22.2.2 running\n"); got != nil {
+		t.Errorf("ParseConsoleHostKeys() without markers = %v", got)
+	}
+}
+
+func TestPinHostKeys(t *testing.T) {
+	oldKey, newKey, otherKey := testHostKey(t), testHostKey(t), testHostKey(t)
+
+	tests := []struct {
+		name        string
+		pinned      []ssh2.PublicKey
+		keys        []ssh2.PublicKey
+		repin       bool
+		wantPinned  []ssh2.PublicKey
+		wantChanges []HostKeyChange
+		wantChanged bool
+		wantErr     bool
+	}{
+		{
+			name:       "first pin",
+			keys:       []ssh2.PublicKey{oldKey},
+			wantPinned: []ssh2.PublicKey{oldKey},
+		},
+		{
+			name:       "same keys",
+			pinned:     []ssh2.PublicKey{oldKey},
+			keys:       []ssh2.PublicKey{oldKey},
+			wantPinned: []ssh2.PublicKey{oldKey},
+		},
+		{
+			name:        "changed keys aren't re-pinned",
+			pinned:      []ssh2.PublicKey{oldKey},
+			keys:        []ssh2.PublicKey{newKey},
+			wantPinned:  []ssh2.PublicKey{oldKey},
+			wantChanges: []HostKeyChange{{Type: ssh2.KeyAlgoED25519, Pinned: ssh2.FingerprintSHA256(oldKey), Fetched: ssh2.FingerprintSHA256(newKey)}},
+			wantChanged: true,
+			wantErr:     true,
+		},
+		{
+			name:        "changed keys are re-pinned when asked to",
+			pinned:      []ssh2.PublicKey{oldKey},
+			keys:        []ssh2.PublicKey{newKey},
+			repin:       true,
+			wantPinned:  []ssh2.PublicKey{newKey},
+			wantChanges: []HostKeyChange{{Type: ssh2.KeyAlgoED25519, Pinned: ssh2.FingerprintSHA256(oldKey), Fetched: ssh2.FingerprintSHA256(newKey)}},
+		},
+		{
+			name:       "new key type is added",
+			pinned:     []ssh2.PublicKey{oldKey},
+			keys:       []ssh2.PublicKey{oldKey, newKey},
+			wantPinned: []ssh2.PublicKey{oldKey, newKey},
+		},
+		{
+			name:    "no keys",
+			pinned:  []ssh2.PublicKey{oldKey},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &config.Atun{Config: &config.Config{TunnelDir: t.TempDir()}}
+
+			// Keys of other routers are kept as they are
+			existing := "i-other " + authorizedKey(otherKey) + "\n"
+			for _, key := range tt.pinned {
+				existing += "i-router " + authorizedKey(key) + "\n"
+			}
+			if err := os.WriteFile(KnownHostsPath(app), []byte(existing), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			changes, err := PinHostKeys(app, "i-router", tt.keys, tt.repin)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PinHostKeys() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			var changedErr *HostKeyChangedError
+			if errors.As(err, &changedErr) != tt.wantChanged {
+				t.Errorf("PinHostKeys() error = %v, want HostKeyChangedError: %v", err, tt.wantChanged)
+			}
+			if tt.wantChanged {
+				for _, change := range tt.wantChanges {
+					if !strings.Contains(err.Error(), change.Pinned) || !strings.Contains(err.Error(), change.Fetched) {
+						t.Errorf("error %q doesn't name the old and new fingerprints", err)
+					}
+				}
+			}
+
+			if !slices.Equal(changes, tt.wantChanges) {
+				t.Errorf("PinHostKeys() changes = %+v, want %+v", changes, tt.wantChanges)
+			}
+
+			pinned, err := PinnedHostKeys(app, "i-router")
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := tt.wantPinned
+			if want == nil {
+				want = tt.pinned
+			}
+			if got := fingerprints(pinned); !slices.Equal(got, fingerprints(want)) {
+				t.Errorf("pinned keys = %v, want %v", got, fingerprints(want))
+			}
+
+			if other, _ := PinnedHostKeys(app, "i-other"); !slices.Equal(fingerprints(other), fingerprints([]ssh2.PublicKey{otherKey})) {
+				t.Errorf("keys of another router = %v, want them kept", fingerprints(other))
+			}
+		})
+	}
+}
+
+func TestHostKeyCheckingArgs(t *testing.T) {
+	tests := []struct {
+		name   string
+		strict bool
+		pinned bool
+		want   string
+	}{
+		{name: "not strict", want: "StrictHostKeyChecking=no"},
+		{name: "first connection", strict: true, want: "StrictHostKeyChecking=accept-new"},
+		{name: "pinned keys", strict: true, pinned: true, want: "StrictHostKeyChecking=yes"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &config.Atun{Config: &config.Config{TunnelDir: t.TempDir(), RouterHostID: "i-router", SSHStrictHostKeyChecking: tt.strict}}
+			if tt.pinned {
+				if _, err := PinHostKeys(app, "i-router", []ssh2.PublicKey{testHostKey(t)}, false); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			args := hostKeyCheckingArgs(app)
+			if !slices.Contains(args, tt.want) {
+				t.Errorf("hostKeyCheckingArgs() = %q, want %q", args, tt.want)
+			}
+
+			wantKnownHosts := "UserKnownHostsFile=" + KnownHostsPath(app)
+			if slices.Contains(args, wantKnownHosts) != tt.strict {
+				t.Errorf("hostKeyCheckingArgs() = %q, known_hosts of the env is used only with strict checking", args)
+			}
+		})
+	}
+}
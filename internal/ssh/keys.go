@@ -0,0 +1,222 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/DimmKirr/atun/internal/config"
+	"github.com/DimmKirr/atun/internal/logger"
+	ssh2 "golang.org/x/crypto/ssh"
+)
+
+// SessionKeyMarker starts the comment of session keys in authorized_keys: atun-session:<created unix time>:<env>@<hostname>.
+// It tells keys of atun sessions apart from keys users added themselves, so only session keys are cleaned up
+const SessionKeyMarker = "atun-session"
+
+// sessionKeySuffix is the file name suffix of session private keys in TunnelDir (<router ID>-id_ed25519)
+const sessionKeySuffix = "-id_ed25519"
+
+// SessionKeyPath returns the path of the private key of the session with the router
+func SessionKeyPath(app *config.Atun) string {
+	return filepath.Join(app.Config.TunnelDir, app.Config.RouterHostID+sessionKeySuffix)
+}
+
+// IsSessionKey reports whether the key path is a session key generated by atun (and not a key set with ssh_key_path)
+func IsSessionKey(app *config.Atun, keyPath string) bool {
+	return filepath.Dir(keyPath) == filepath.Clean(app.Config.TunnelDir) && strings.HasSuffix(keyPath, sessionKeySuffix)
+}
+
+// UsesSessionKey reports whether the tunnel authenticates with a session key, i.e. ssh_key_path isn't set
+func UsesSessionKey(app *config.Atun) bool {
+	return app.Config.SSHKeyPath == "" || IsSessionKey(app, app.Config.SSHKeyPath)
+}
+
+// EnsureSessionKey points app.Config.SSHKeyPath to the key of the session with app.Config.RouterHostID, generating an ed25519 keypair if there is none.
+// A key set with ssh_key_path is used as is. Keys of sessions that are gone (e.g. atun crashed) are removed along the way
+func EnsureSessionKey(app *config.Atun) (string, error) {
+	if !UsesSessionKey(app) {
+		return app.Config.SSHKeyPath, nil
+	}
+
+	removeStaleSessionKeys(app)
+
+	keyPath := SessionKeyPath(app)
+	app.Config.SSHKeyPath = keyPath
+
+	if _, err := os.Stat(keyPath); err == nil {
+		logger.Debug("Using existing session key", "path", keyPath)
+		return keyPath, nil
+	}
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", fmt.Errorf("can't generate session key: %w", err)
+	}
+
+	comment := sessionKeyComment(app)
+	privateKeyPEM, err := ssh2.MarshalPrivateKey(privateKey, comment)
+	if err != nil {
+		return "", fmt.Errorf("can't encode session key: %w", err)
+	}
+
+	sshPublicKey, err := ssh2.NewPublicKey(publicKey)
+	if err != nil {
+		return "", fmt.Errorf("can't encode session public key: %w", err)
+	}
+
+	if err := os.MkdirAll(app.Config.TunnelDir, 0700); err != nil {
+		return "", fmt.Errorf("can't create tunnel directory: %w", err)
+	}
+
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(privateKeyPEM), 0600); err != nil {
+		return "", fmt.Errorf("can't write session key: %w", err)
+	}
+
+	authorizedKey := strings.TrimSpace(string(ssh2.MarshalAuthorizedKey(sshPublicKey))) + " " + comment + "\n"
+	if err := os.WriteFile(keyPath+".pub", []byte(authorizedKey), 0644); err != nil {
+		return "", fmt.Errorf("can't write session public key: %w", err)
+	}
+	logger.Debug("Generated session key", "path", keyPath, "comment", comment)
+
+	return keyPath, nil
+}
+
+// GetSessionPublicKey returns the public key of the session with the marker comment, as it goes to authorized_keys
+func GetSessionPublicKey(app *config.Atun) (string, error) {
+	if !IsSessionKey(app, app.Config.SSHKeyPath) {
+		return GetPublicKey(app.Config.SSHKeyPath)
+	}
+
+	publicKey, err := os.ReadFile(app.Config.SSHKeyPath + ".pub")
+	if err != nil {
+		return "", fmt.Errorf("can't read session public key: %w", err)
+	}
+
+	return string(publicKey), nil
+}
+
+// RemoveSessionKey deletes the session key files of the session with app.Config.RouterHostID
+func RemoveSessionKey(app *config.Atun) error {
+	keyPath := SessionKeyPath(app)
+
+	for _, path := range []string{keyPath, keyPath + ".pub", CertificatePath(app)} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("can't remove session key: %w", err)
+		}
+	}
+	logger.Debug("Removed session key", "path", keyPath)
+
+	return nil
+}
+
+// removeStaleSessionKeys deletes session keys of routers other than the current one that have no running tunnel
+func removeStaleSessionKeys(app *config.Atun) {
+	files, err := os.ReadDir(app.Config.TunnelDir)
+	if err != nil {
+		return
+	}
+
+	for _, file := range files {
+		routerID, ok := strings.CutSuffix(file.Name(), sessionKeySuffix)
+		if !ok || routerID == app.Config.RouterHostID {
+			continue
+		}
+
+		if _, err := os.Stat(filepath.Join(app.Config.TunnelDir, fmt.Sprintf("%s-tunnel.sock", routerID))); err == nil {
+			continue
+		}
+
+		keyPath := filepath.Join(app.Config.TunnelDir, file.Name())
+		_ = os.Remove(keyPath)
+		_ = os.Remove(keyPath + ".pub")
+		_ = os.Remove(filepath.Join(app.Config.TunnelDir, routerID+"-cert.pub"))
+		logger.Debug("Removed session key of a session that's gone", "path", keyPath)
+	}
+}
+
+func sessionKeyComment(app *config.Atun) string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "unknown"
+	}
+
+	return fmt.Sprintf("%s:%d:%s@%s", SessionKeyMarker, time.Now().Unix(), app.Config.Env, strings.Fields(hostname)[0])
+}
+
+// keyBlob returns the base64 encoded key of an authorized_keys line, which identifies the key regardless of its options and comment.
+// It's empty if the line is not a key
+func keyBlob(publicKey string) string {
+	key, _, _, _, err := ssh2.ParseAuthorizedKey([]byte(publicKey))
+	if err != nil {
+		return ""
+	}
+
+	return base64.StdEncoding.EncodeToString(key.Marshal())
+}
+
+// AuthorizeKeyScript returns a shell script that adds the public key to authorized_keys in homeDir if it's not there yet.
+// Session keys older than staleAfter (left by sessions that crashed or were never brought down) are removed first
+func AuthorizeKeyScript(homeDir string, publicKey string, staleAfter time.Duration) string {
+	blob := keyBlob(publicKey)
+	if blob == "" {
+		blob = strings.TrimSpace(publicKey)
+	}
+
+	authorizedKeys := fmt.Sprintf("%s/.ssh/authorized_keys", homeDir)
+	marker := " " + SessionKeyMarker + ":"
+
+	return fmt.Sprintf(`set -e
+mkdir -p %[1]s/.ssh
+touch %[2]s
+awk -v now="$(date +%%s)" -v ttl=%[3]d '{ if (match($0, /%[4]s[0-9]+:/)) { created = substr($0, RSTART + %[5]d, RLENGTH - %[6]d); if (now - created > ttl) next } print }' %[2]s > %[2]s.atun
+cat %[2]s.atun > %[2]s
+rm -f %[2]s.atun
+grep -qF "%[7]s" %[2]s || echo "%[8]s" >> %[2]s
+`,
+		homeDir,
+		authorizedKeys,
+		int64(staleAfter.Seconds()),
+		marker,
+		len(marker),
+		len(marker)+1,
+		blob,
+		strings.TrimSpace(publicKey),
+	)
+}
+
+// RevokeKeyCommand returns a one-line shell command that removes the public keys from the authorized_keys file.
+// Keys are matched by their blob, so lines with options or another comment are removed too. Lines that are not keys are skipped,
+// so a malformed key can't turn into a pattern that removes every line. It has no single quotes, so it can be wrapped in sh -c '...'
+func RevokeKeyCommand(authorizedKeys string, publicKeys ...string) string {
+	var patterns []string
+	for _, publicKey := range publicKeys {
+		blob := keyBlob(publicKey)
+		if blob == "" {
+			logger.Warn("Skipping a key that can't be parsed", "key", strings.TrimSpace(publicKey))
+			continue
+		}
+		patterns = append(patterns, fmt.Sprintf(`-e "%s"`, blob))
+	}
+
+	if len(patterns) == 0 {
+		return "true"
+	}
+
+	return fmt.Sprintf(
+		`if [ -f %[1]s ]; then grep -vF %[2]s %[1]s > %[1]s.atun; cat %[1]s.atun > %[1]s; rm -f %[1]s.atun; fi`,
+		authorizedKeys,
+		strings.Join(patterns, " "),
+	)
+}
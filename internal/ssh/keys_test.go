@@ -0,0 +1,275 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DimmKirr/atun/internal/config"
+	"github.com/DimmKirr/atun/internal/logger"
+	ssh2 "golang.org/x/crypto/ssh"
+)
+
+func TestMain(m *testing.M) {
+	logger.Initialize("error", true)
+	config.App = &config.Atun{Config: &config.Config{}}
+
+	os.Exit(m.Run())
+}
+
+// testPublicKey returns a new ed25519 public key in authorized_keys format with the comment
+func testPublicKey(t *testing.T, comment string) string {
+	t.Helper()
+
+	publicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sshPublicKey, err := ssh2.NewPublicKey(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return strings.TrimSpace(string(ssh2.MarshalAuthorizedKey(sshPublicKey))) + " " + comment
+}
+
+// runScript runs the shell script and returns the contents of authorized_keys in homeDir afterward
+func runScript(t *testing.T, homeDir string, script string) string {
+	t.Helper()
+
+	if output, err := exec.Command("sh", "-c", script).CombinedOutput(); err != nil {
+		t.Fatalf("script failed: %v: %s", err, output)
+	}
+
+	authorizedKeys, err := os.ReadFile(filepath.Join(homeDir, ".ssh", "authorized_keys"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(authorizedKeys)
+}
+
+func TestAuthorizeKeyScript(t *testing.T) {
+	now := time.Now().Unix()
+
+	userKey := testPublicKey(t, "jane@laptop")
+	expiredKey := testPublicKey(t, fmt.Sprintf("%s:%d:dev@laptop", SessionKeyMarker, now-7200))
+	activeKey := testPublicKey(t, fmt.Sprintf("%s:%d:dev@desktop", SessionKeyMarker, now-60))
+	// The marker only counts in the comment of the key
+	lookalikeKey := testPublicKey(t, SessionKeyMarker+"-backup")
+	sessionKey := testPublicKey(t, fmt.Sprintf("%s:%d:dev@laptop", SessionKeyMarker, now))
+
+	homeDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(homeDir, ".ssh"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	existing := strings.Join([]string{"# keys of the team", userKey, expiredKey, activeKey, lookalikeKey}, "\n") + "\n"
+	if err := os.WriteFile(filepath.Join(homeDir, ".ssh", "authorized_keys"), []byte(existing), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	script := AuthorizeKeyScript(homeDir, sessionKey+"\n", time.Hour)
+
+	// Running it again (e.g. on a retry) doesn't add the key twice
+	runScript(t, homeDir, script)
+	got := runScript(t, homeDir, script)
+
+	want := strings.Join([]string{"# keys of the team", userKey, activeKey, lookalikeKey, sessionKey}, "\n") + "\n"
+	if got != want {
+		t.Errorf("authorized_keys =\n%s\nwant\n%s", got, want)
+	}
+
+	if _, err := os.Stat(filepath.Join(homeDir, ".ssh", "authorized_keys.atun")); !os.IsNotExist(err) {
+		t.Errorf("temporary file is left behind: %v", err)
+	}
+}
+
+func TestAuthorizeKeyScriptWithoutAuthorizedKeys(t *testing.T) {
+	homeDir := t.TempDir()
+	sessionKey := testPublicKey(t, fmt.Sprintf("%s:%d:dev@laptop", SessionKeyMarker, time.Now().Unix()))
+
+	got := runScript(t, homeDir, AuthorizeKeyScript(homeDir, sessionKey, time.Hour))
+	if got != sessionKey+"\n" {
+		t.Errorf("authorized_keys = %q, want %q", got, sessionKey+"\n")
+	}
+}
+
+func TestRemoveStaleSessionKeys(t *testing.T) {
+	tunnelDir := t.TempDir()
+	app := &config.Atun{Config: &config.Config{TunnelDir: tunnelDir, RouterHostID: "i-current"}}
+
+	files := []string{
+		// Session with a running tunnel
+		"i-running-id_ed25519", "i-running-id_ed25519.pub", "i-running-cert.pub", "i-running-tunnel.sock",
+		// Session that's gone
+		"i-gone-id_ed25519", "i-gone-id_ed25519.pub", "i-gone-cert.pub",
+		// Current session, its tunnel isn't up yet
+		"i-current-id_ed25519", "i-current-id_ed25519.pub",
+		// Files that aren't session keys
+		"i-other-ssh.config",
+	}
+	for _, file := range files {
+		if err := os.WriteFile(filepath.Join(tunnelDir, file), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removeStaleSessionKeys(app)
+
+	for _, file := range files {
+		_, err := os.Stat(filepath.Join(tunnelDir, file))
+		removed := os.IsNotExist(err)
+		if wantRemoved := strings.HasPrefix(file, "i-gone-"); removed != wantRemoved {
+			t.Errorf("%s removed = %v, want %v", file, removed, wantRemoved)
+		}
+	}
+}
+
+func TestRevokeKeyCommand(t *testing.T) {
+	revoked := testPublicKey(t, fmt.Sprintf("%s:%d:dev@laptop", SessionKeyMarker, time.Now().Unix()))
+	kept := testPublicKey(t, "jane@laptop")
+	fields := strings.Fields(revoked)
+
+	homeDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(homeDir, ".ssh"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	existing := strings.Join([]string{
+		"# keys of the team",
+		kept,
+		revoked,
+		// The same key with options and another comment
+		"no-pty " + fields[0] + " " + fields[1] + " ci",
+		`from="10.0.0.0/8,192.168.1.1",no-agent-forwarding ` + fields[0] + " " + fields[1],
+		`no-port-forwarding,command="echo 'Please login as the user \"ec2-user\" rather than the user \"root\".';echo;sleep 10;exit 142" ` + fields[0] + " " + fields[1] + " root@router",
+		"restrict " + kept,
+	}, "\n") + "\n"
+	authorizedKeys := filepath.Join(homeDir, ".ssh", "authorized_keys")
+	if err := os.WriteFile(authorizedKeys, []byte(existing), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Keys to revoke come as they are listed in authorized_keys, with options
+	// Lines that aren't keys are skipped
+	command := RevokeKeyCommand(authorizedKeys, `no-port-forwarding,command="echo 'Please login as the user \"ec2-user\"';exit 142" `+fields[0]+" "+fields[1], "ssh-ed25519", "")
+	if strings.Contains(command, "'") {
+		t.Errorf("RevokeKeyCommand() = %q, it can't be wrapped in sh -c '...'", command)
+	}
+
+	got := runScript(t, homeDir, command)
+	want := strings.Join([]string{"# keys of the team", kept, "restrict " + kept}, "\n") + "\n"
+	if got != want {
+		t.Errorf("authorized_keys =\n%s\nwant\n%s", got, want)
+	}
+
+	// Without keys to revoke nothing is removed
+	for _, publicKeys := range [][]string{nil, {"not a key"}, {"ssh-ed25519"}} {
+		if got := runScript(t, homeDir, RevokeKeyCommand(authorizedKeys, publicKeys...)); got != want {
+			t.Errorf("RevokeKeyCommand(%q) changed authorized_keys to\n%s", publicKeys, got)
+		}
+	}
+}
+
+func TestParseAuthorizedKeys(t *testing.T) {
+	sessionKey := testPublicKey(t, SessionKeyMarker+":1735689600:dev@laptop")
+	userKey := testPublicKey(t, "jane@laptop")
+	noComment := testPublicKey(t, "")
+	blob := strings.Fields(sessionKey)[1]
+
+	data := strings.Join([]string{
+		"# keys of the team",
+		"",
+		sessionKey,
+		`no-port-forwarding,command="echo 'Please login as the user \"ec2-user\" rather than the user \"root\".';echo;sleep 10;exit 142" ` + userKey,
+		`from="10.0.0.0/8",no-pty ` + sessionKey,
+		"  " + noComment,
+		"not a key",
+		// The marker needs a valid creation time
+		testPublicKey(t, SessionKeyMarker+":yesterday:dev@laptop"),
+	}, "\n")
+
+	keys := ParseAuthorizedKeys(data)
+
+	want := []struct {
+		comment string
+		blob    string
+		session bool
+		created int64
+		owner   string
+	}{
+		{comment: SessionKeyMarker + ":1735689600:dev@laptop", blob: blob, session: true, created: 1735689600, owner: "dev@laptop"},
+		{comment: "jane@laptop", blob: strings.Fields(userKey)[1]},
+		{comment: SessionKeyMarker + ":1735689600:dev@laptop", blob: blob, session: true, created: 1735689600, owner: "dev@laptop"},
+		{comment: "", blob: strings.Fields(noComment)[1]},
+		{comment: SessionKeyMarker + ":yesterday:dev@laptop"},
+	}
+	if len(keys) != len(want) {
+		t.Fatalf("ParseAuthorizedKeys() returned %d keys, want %d: %+v", len(keys), len(want), keys)
+	}
+
+	for i, w := range want {
+		key := keys[i]
+		if key.Type != ssh2.KeyAlgoED25519 || !strings.HasPrefix(key.Fingerprint, "SHA256:") {
+			t.Errorf("key %d: Type = %q, Fingerprint = %q", i, key.Type, key.Fingerprint)
+		}
+		if key.Comment != w.comment {
+			t.Errorf("key %d: Comment = %q, want %q", i, key.Comment, w.comment)
+		}
+		if w.blob != "" && key.Blob != w.blob {
+			t.Errorf("key %d: Blob = %q, want %q", i, key.Blob, w.blob)
+		}
+		if key.Session != w.session || key.Owner != w.owner {
+			t.Errorf("key %d: Session = %v, Owner = %q, want %v, %q", i, key.Session, key.Owner, w.session, w.owner)
+		}
+		if w.session && key.Created.Unix() != w.created {
+			t.Errorf("key %d: Created = %v, want %v", i, key.Created, time.Unix(w.created, 0))
+		}
+	}
+
+	// Blob identifies the key regardless of the line
+	if keys[0].Blob != keys[2].Blob || keys[0].Fingerprint != keys[2].Fingerprint {
+		t.Error("the same key with options has another Blob or Fingerprint")
+	}
+}
+
+func TestAuthorizedKeyOwnedBy(t *testing.T) {
+	session := AuthorizedKey{Session: true, Owner: "dev@alice-laptop"}
+
+	tests := []struct {
+		name  string
+		key   AuthorizedKey
+		owner string
+		want  bool
+	}{
+		{name: "env and hostname", key: session, owner: "dev@alice-laptop", want: true},
+		{name: "hostname", key: session, owner: "alice-laptop", want: true},
+		{name: "another env", key: session, owner: "prod@alice-laptop"},
+		{name: "part of the hostname", key: session, owner: "alice"},
+		{name: "longer hostname", key: session, owner: "alice-laptop2"},
+		{name: "env", key: session, owner: "dev"},
+		{name: "env with @", key: session, owner: "dev@"},
+		{name: "empty owner", key: session, owner: ""},
+		{name: "not a session key", key: AuthorizedKey{Owner: "dev@alice-laptop"}, owner: "dev@alice-laptop"},
+		{name: "empty env", key: session, owner: "@alice-laptop"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.key.OwnedBy(tt.owner); got != tt.want {
+				t.Errorf("OwnedBy(%q) of %q = %v, want %v", tt.owner, tt.key.Owner, got, tt.want)
+			}
+		})
+	}
+}
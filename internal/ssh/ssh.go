@@ -6,6 +6,7 @@
 package ssh
 
 import (
+	"errors"
 	"fmt"
 	"github.com/DimmKirr/atun/internal/config"
 	"github.com/DimmKirr/atun/internal/logger"
@@ -32,7 +33,8 @@ type Endpoint struct {
 	Status     bool
 }
 
-// GetPublicKey gets the public key from the private key
+// GetPublicKey gets the public key from the private key.
+// The path can also be a public key (e.g. of an ssh-agent key). Public keys of encrypted keys are read without the passphrase if possible
 func GetPublicKey(path string) (string, error) {
 	if !filepath.IsAbs(path) {
 		var err error
@@ -51,15 +53,40 @@ func GetPublicKey(path string) (string, error) {
 		return "", err
 	}
 
+	if publicKey, _, _, _, err := ssh2.ParseAuthorizedKey(f); err == nil {
+		return string(ssh2.MarshalAuthorizedKey(publicKey)), nil
+	}
+
 	// Parse the private key
+	var publicKey ssh2.PublicKey
 	privateKey, err := ssh2.ParsePrivateKey(f)
-	if err != nil {
+	var passphraseErr *ssh2.PassphraseMissingError
+	switch {
+	case err == nil:
+		publicKey = privateKey.PublicKey()
+	case errors.As(err, &passphraseErr):
+		if publicKey, err = encryptedKeyPublicKey(path, passphraseErr); err == nil {
+			break
+		}
+
+		// Neither the key nor a .pub file has the public key, it takes the passphrase
+		key, err := unlockPrivateKey(path)
+		if err != nil {
+			return "", err
+		}
+		if key == nil {
+			return "", fmt.Errorf("can't get the public key of %s, put it next to the key as %s.pub", path, path)
+		}
+
+		signer, err := ssh2.NewSignerFromKey(key)
+		if err != nil {
+			return "", err
+		}
+		publicKey = signer.PublicKey()
+	default:
 		return "", err
 	}
 
-	// Extract the public key from the private key
-	publicKey := privateKey.PublicKey()
-
 	// Marshal the public key to the OpenSSH format
 	pubKeyBytes := ssh2.MarshalAuthorizedKey(publicKey)
 
@@ -67,13 +94,37 @@ func GetPublicKey(path string) (string, error) {
 	return string(pubKeyBytes), nil
 }
 
+// Transports of EC2 routers. SSM is used if the transport is not set with a flag or an atun.io/transport tag
+const (
+	TransportSSM  = "ssm"
+	TransportEICE = "eice"
+)
+
 func GenerateSSHConfigFile(app *config.Atun) (string, error) {
-	sshConfigContent := `# SSH over AWS Session Manager (generated by atun.io)
+	var sshConfigContent string
+
+	switch app.Config.RouterTransport {
+	case "", TransportSSM:
+		sshConfigContent = `# SSH over AWS Session Manager (generated by atun.io)
 host i-* mi-*
 ServerAliveInterval 180
 ProxyCommand sh -c "aws ssm start-session --target %h --document-name AWS-StartSSHSession --parameters 'portNumber=%p'"
 `
+	case TransportEICE:
+		sshConfigContent = `# SSH over EC2 Instance Connect Endpoint (generated by atun.io)
+host i-*
+ServerAliveInterval 180
+ProxyCommand aws ec2-instance-connect open-tunnel --instance-id %h --remote-port %p
+`
+	default:
+		return "", fmt.Errorf("unknown transport %s (supported: %s, %s)", app.Config.RouterTransport, TransportSSM, TransportEICE)
+	}
+
+	return WriteSSHConfigFile(app, sshConfigContent)
+}
 
+// WriteSSHConfigFile writes the tunnel SSH config file with a router-type specific host block followed by LocalForwards for all endpoints
+func WriteSSHConfigFile(app *config.Atun, sshConfigContent string) (string, error) {
 	for _, host := range app.Config.Hosts {
 		logger.Debug("Endpoint", "name", host.Name, "proto", host.Proto, "remote", host.Remote, "local", host.Local)
 		sshConfigContent += fmt.Sprintf("LocalForward %d %s:%d\n", host.Local, host.Name, host.Remote)
@@ -236,10 +287,7 @@ func StartSSHTunnel(app *config.Atun) error {
 		logger.Debug("Tunnel socket not found. Creating a new one", "path", routerSockFilePath)
 		args = []string{"-M", "-t", "-S", routerSockFilePath, "-fN"}
 
-		// Disable strict host key checking
-		if !app.Config.SSHStrictHostKeyChecking {
-			args = append(args, "-o", "StrictHostKeyChecking=no")
-		}
+		args = append(args, hostKeyCheckingArgs(app)...)
 
 		// TODO: Add ability to support other instance types, not just AWS Linux
 		args = append(args, fmt.Sprintf("%s@%s", app.Config.RouterHostUser, app.Config.RouterHostID))
@@ -248,6 +296,10 @@ func StartSSHTunnel(app *config.Atun) error {
 		if _, err := os.Stat(app.Config.SSHKeyPath); !os.IsNotExist(err) {
 			args = append(args, "-i", app.Config.SSHKeyPath)
 		}
+
+		if UsesCA(app) {
+			args = append(args, "-o", fmt.Sprintf("CertificateFile=%s", CertificatePath(app)))
+		}
 	}
 
 	if app.Config.LogLevel == "debug" {
@@ -266,6 +318,24 @@ func StartSSHTunnel(app *config.Atun) error {
 	// Platform-specific implementation is in sysproc_*.go files
 	setupSysProcAttr(c)
 
+	// Stderr goes to a file rather than a pipe: ssh keeps it open after going to the background, so reading a pipe would never end
+	// An encrypted key unlocked with a passphrase is served to ssh with an agent of atun
+	agentEnv, stopAgent, err := serveUnlockedKey(app)
+	if err != nil {
+		return err
+	}
+	defer stopAgent()
+	if agentEnv != nil {
+		c.Env = append(os.Environ(), agentEnv...)
+	}
+
+	stderrFile, err := os.CreateTemp("", "atun-ssh-stderr")
+	if err == nil {
+		c.Stderr = stderrFile
+		defer os.Remove(stderrFile.Name())
+		defer stderrFile.Close()
+	}
+
 	//// Stream stdout and stderr
 	//if app.Config.LogLevel == "debug" {
 	//	// Stream output to os.Stdout and os.Stderr in real-time
@@ -302,6 +372,13 @@ func StartSSHTunnel(app *config.Atun) error {
 	// Run the command
 	if err := c.Run(); err != nil {
 		logger.Debug("SSH command error", "error", err)
+
+		if stderrFile != nil {
+			if output, _ := os.ReadFile(stderrFile.Name()); strings.Contains(string(output), "Host key verification failed") {
+				return &HostKeyError{Host: app.Config.RouterHostID, KnownHosts: KnownHostsPath(app), Output: strings.TrimSpace(string(output))}
+			}
+		}
+
 		return fmt.Errorf("failed to run SSH process: %w", err)
 
 		// Print stdot and stderr from the command
@@ -365,6 +442,36 @@ func StopSSHTunnel(app *config.Atun) (bool, error) {
 	return true, nil // return true, meaning tunnel status is "on"
 }
 
+// AddLocalForward adds a local port forward to the running tunnel via its control socket
+func AddLocalForward(app *config.Atun, host config.Endpoint) error {
+	return controlLocalForward(app, "forward", host)
+}
+
+// CancelLocalForward removes a local port forward from the running tunnel via its control socket
+func CancelLocalForward(app *config.Atun, host config.Endpoint) error {
+	return controlLocalForward(app, "cancel", host)
+}
+
+func controlLocalForward(app *config.Atun, operation string, host config.Endpoint) error {
+	args := []string{
+		"-S", GetRouterSockFilePath(app),
+		"-O", operation,
+		"-L", fmt.Sprintf("%d:%s:%d", host.Local, host.Name, host.Remote),
+		fmt.Sprintf("%s@%s", app.Config.RouterHostUser, app.Config.RouterHostID),
+	}
+
+	cmd := exec.Command("ssh", args...)
+	cmd.Dir = app.Config.AppDir
+	logger.Debug("Running SSH command", "command", cmd.String())
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ssh -O %s failed: %w: %s", operation, err, strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
+
 func GetRouterSockFilePath(app *config.Atun) string {
 	logger.Debug("Getting router socket file path", "tunnelDir", app.Config.TunnelDir, "env", app.Config.Env, "routerHostID", app.Config.RouterHostID)
 
@@ -388,7 +495,7 @@ func GetActiveSSHTunnels() ([]config.Config, error) {
 	// Define the regex routerInstancePattern
 
 	// This regex is based on the cmd and args in ssh package
-	sshProcessPattern := regexp.MustCompile(`.*-S\s+(?P<sshSocketFile>\S+).*\s+(?P<userName>[a-zA-Z_][a-zA-Z0-9._-]{0,31})@(?P<instanceId>i-[0-9a-f]{17}).*-F\s+(?P<sshConfigFile>\S+).*-i\s+(?P<sshKeyPath>\S+).*`)
+	sshProcessPattern := regexp.MustCompile(`.*-S\s+(?P<sshSocketFile>\S+).*\s+(?P<userName>[a-zA-Z_][a-zA-Z0-9._-]{0,31})@(?P<instanceId>[a-zA-Z0-9][a-zA-Z0-9._-]*).*-F\s+(?P<sshConfigFile>\S+)(?:.*-i\s+(?P<sshKeyPath>\S+))?.*`)
 
 	// Run the ps command to list all processes
 	targetBinary := "ssh" // The binary name to match exactly
@@ -0,0 +1,129 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package tunnel
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/DimmKirr/atun/internal/aws"
+	"github.com/DimmKirr/atun/internal/logger"
+	awssdk "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// routerCandidate is a router host with the properties it's ranked by
+type routerCandidate struct {
+	ID           string
+	PingStatus   string
+	AgentVersion string
+	Priority     int
+}
+
+// RankRouterHosts orders router hosts from the best to the worst candidate:
+// SSM agent online first, then higher atun.io/priority tag, then newer SSM agent. Instance ID breaks ties, so the order is deterministic.
+func RankRouterHosts(instances []*ec2.Instance) []string {
+	var instanceIDs []string
+	for _, instance := range instances {
+		instanceIDs = append(instanceIDs, awssdk.StringValue(instance.InstanceId))
+	}
+
+	// Ranking without SSM information still honors priority tags
+	ssmInformation, err := aws.GetSSMInstanceInformation(instanceIDs)
+	if err != nil {
+		logger.Warn("Can't get SSM status of routers. Ranking them by priority only", "error", err)
+		ssmInformation = map[string]*ssm.InstanceInformation{}
+	}
+
+	return rankInstances(instances, ssmInformation)
+}
+
+// rankInstances orders router hosts by their SSM information (by instance ID) and tags, see RankRouterHosts
+func rankInstances(instances []*ec2.Instance, ssmInformation map[string]*ssm.InstanceInformation) []string {
+	var candidates []routerCandidate
+	for _, instance := range instances {
+		candidate := routerCandidate{
+			ID:         awssdk.StringValue(instance.InstanceId),
+			PingStatus: "Unknown",
+		}
+
+		if info, ok := ssmInformation[candidate.ID]; ok {
+			candidate.PingStatus = awssdk.StringValue(info.PingStatus)
+			candidate.AgentVersion = awssdk.StringValue(info.AgentVersion)
+		}
+
+		for _, tag := range instance.Tags {
+			if awssdk.StringValue(tag.Key) != "atun.io/priority" {
+				continue
+			}
+
+			priority, err := strconv.Atoi(awssdk.StringValue(tag.Value))
+			if err != nil {
+				logger.Warn("Ignoring invalid atun.io/priority tag (must be an integer)", "router", candidate.ID, "value", awssdk.StringValue(tag.Value))
+				continue
+			}
+			candidate.Priority = priority
+		}
+
+		candidates = append(candidates, candidate)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return betterCandidate(candidates[i], candidates[j])
+	})
+
+	var routerHostIDs []string
+	for _, candidate := range candidates {
+		logger.Debug("Router candidate", "router", candidate.ID, "pingStatus", candidate.PingStatus, "priority", candidate.Priority, "agentVersion", candidate.AgentVersion)
+		routerHostIDs = append(routerHostIDs, candidate.ID)
+	}
+
+	return routerHostIDs
+}
+
+// betterCandidate reports whether router a should be preferred over router b
+func betterCandidate(a routerCandidate, b routerCandidate) bool {
+	aOnline, bOnline := a.PingStatus == ssm.PingStatusOnline, b.PingStatus == ssm.PingStatusOnline
+	if aOnline != bOnline {
+		return aOnline
+	}
+
+	if a.Priority != b.Priority {
+		return a.Priority > b.Priority
+	}
+
+	if c := compareVersions(a.AgentVersion, b.AgentVersion); c != 0 {
+		return c > 0
+	}
+
+	return a.ID < b.ID
+}
+
+// compareVersions compares dot-separated numeric versions (e.g. SSM agent 3.3.131.0). Missing or non-numeric parts count as 0
+func compareVersions(a string, b string) int {
+	aParts, bParts := strings.Split(a, "."), strings.Split(b, ".")
+
+	for i := 0; i < max(len(aParts), len(bParts)); i++ {
+		var aPart, bPart int
+		if i < len(aParts) {
+			aPart, _ = strconv.Atoi(aParts[i])
+		}
+		if i < len(bParts) {
+			bPart, _ = strconv.Atoi(bParts[i])
+		}
+
+		if aPart != bPart {
+			if aPart > bPart {
+				return 1
+			}
+			return -1
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,195 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package tunnel
+
+import (
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/DimmKirr/atun/internal/logger"
+	awssdk "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+func TestMain(m *testing.M) {
+	logger.Initialize("error", true)
+
+	os.Exit(m.Run())
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{a: "3.3.131.0", b: "3.3.131.0", want: 0},
+		{a: "3.3.131.0", b: "3.2.582.0", want: 1},
+		{a: "3.2.582.0", b: "3.3.131.0", want: -1},
+		// Parts are compared as numbers, not strings
+		{a: "3.10.0.0", b: "3.9.0.0", want: 1},
+		{a: "3.3.1000.0", b: "3.3.999.0", want: 1},
+		// Missing parts count as 0
+		{a: "3.3", b: "3.3.0.0", want: 0},
+		{a: "3.3.1", b: "3.3", want: 1},
+		{a: "", b: "3.3.131.0", want: -1},
+		{a: "", b: "", want: 0},
+		// Non-numeric parts count as 0
+		{a: "3.x.1", b: "3.0.1", want: 0},
+	}
+
+	for _, tt := range tests {
+		if got := compareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestBetterCandidate(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b routerCandidate
+		want bool
+	}{
+		{
+			name: "online beats higher priority",
+			a:    routerCandidate{ID: "i-b", PingStatus: ssm.PingStatusOnline, Priority: 0},
+			b:    routerCandidate{ID: "i-a", PingStatus: ssm.PingStatusConnectionLost, Priority: 100},
+			want: true,
+		},
+		{
+			name: "online beats unknown",
+			a:    routerCandidate{ID: "i-b", PingStatus: ssm.PingStatusOnline},
+			b:    routerCandidate{ID: "i-a", PingStatus: "Unknown"},
+			want: true,
+		},
+		{
+			name: "higher priority wins among online",
+			a:    routerCandidate{ID: "i-b", PingStatus: ssm.PingStatusOnline, Priority: 10, AgentVersion: "3.0.0.0"},
+			b:    routerCandidate{ID: "i-a", PingStatus: ssm.PingStatusOnline, Priority: 5, AgentVersion: "3.3.131.0"},
+			want: true,
+		},
+		{
+			name: "negative priority loses to the default",
+			a:    routerCandidate{ID: "i-a", PingStatus: ssm.PingStatusOnline, Priority: -1},
+			b:    routerCandidate{ID: "i-b", PingStatus: ssm.PingStatusOnline},
+			want: false,
+		},
+		{
+			name: "higher priority wins among offline",
+			a:    routerCandidate{ID: "i-b", PingStatus: ssm.PingStatusInactive, Priority: 1},
+			b:    routerCandidate{ID: "i-a", PingStatus: "Unknown"},
+			want: true,
+		},
+		{
+			name: "newer agent wins on equal priority",
+			a:    routerCandidate{ID: "i-b", PingStatus: ssm.PingStatusOnline, AgentVersion: "3.3.131.0"},
+			b:    routerCandidate{ID: "i-a", PingStatus: ssm.PingStatusOnline, AgentVersion: "3.2.582.0"},
+			want: true,
+		},
+		{
+			name: "instance ID breaks ties",
+			a:    routerCandidate{ID: "i-a", PingStatus: ssm.PingStatusOnline, AgentVersion: "3.3.131.0"},
+			b:    routerCandidate{ID: "i-b", PingStatus: ssm.PingStatusOnline, AgentVersion: "3.3.131.0"},
+			want: true,
+		},
+		{
+			name: "equal candidates",
+			a:    routerCandidate{ID: "i-a"},
+			b:    routerCandidate{ID: "i-a"},
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := betterCandidate(tt.a, tt.b); got != tt.want {
+				t.Errorf("betterCandidate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func instance(id string, priority string) *ec2.Instance {
+	instance := &ec2.Instance{InstanceId: awssdk.String(id)}
+	if priority != "" {
+		instance.Tags = []*ec2.Tag{{Key: awssdk.String("atun.io/priority"), Value: awssdk.String(priority)}}
+	}
+
+	return instance
+}
+
+func ssmInfo(pingStatus string, agentVersion string) *ssm.InstanceInformation {
+	return &ssm.InstanceInformation{PingStatus: awssdk.String(pingStatus), AgentVersion: awssdk.String(agentVersion)}
+}
+
+func TestRankInstances(t *testing.T) {
+	tests := []struct {
+		name           string
+		instances      []*ec2.Instance
+		ssmInformation map[string]*ssm.InstanceInformation
+		want           []string
+	}{
+		{
+			name: "online, then priority, then agent version",
+			instances: []*ec2.Instance{
+				instance("i-offline-preferred", "100"),
+				instance("i-old-agent", "5"),
+				instance("i-new-agent", "5"),
+				instance("i-preferred", "10"),
+				instance("i-default", ""),
+			},
+			ssmInformation: map[string]*ssm.InstanceInformation{
+				"i-offline-preferred": ssmInfo(ssm.PingStatusConnectionLost, "3.3.131.0"),
+				"i-old-agent":         ssmInfo(ssm.PingStatusOnline, "3.2.582.0"),
+				"i-new-agent":         ssmInfo(ssm.PingStatusOnline, "3.3.131.0"),
+				"i-preferred":         ssmInfo(ssm.PingStatusOnline, "3.0.0.0"),
+				"i-default":           ssmInfo(ssm.PingStatusOnline, "3.3.131.0"),
+			},
+			want: []string{"i-preferred", "i-new-agent", "i-old-agent", "i-default", "i-offline-preferred"},
+		},
+		{
+			name: "without SSM information only priority counts",
+			instances: []*ec2.Instance{
+				instance("i-b", ""),
+				instance("i-c", "1"),
+				instance("i-a", ""),
+			},
+			ssmInformation: map[string]*ssm.InstanceInformation{},
+			want:           []string{"i-c", "i-a", "i-b"},
+		},
+		{
+			name: "router not registered in SSM goes after online ones",
+			instances: []*ec2.Instance{
+				instance("i-unregistered", "10"),
+				instance("i-online", ""),
+			},
+			ssmInformation: map[string]*ssm.InstanceInformation{
+				"i-online": ssmInfo(ssm.PingStatusOnline, "3.3.131.0"),
+			},
+			want: []string{"i-online", "i-unregistered"},
+		},
+		{
+			name: "invalid priority counts as 0",
+			instances: []*ec2.Instance{
+				instance("i-a", "high"),
+				instance("i-b", "1"),
+				instance("i-c", "-1"),
+			},
+			ssmInformation: map[string]*ssm.InstanceInformation{},
+			want:           []string{"i-b", "i-a", "i-c"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rankInstances(tt.instances, tt.ssmInformation); !slices.Equal(got, tt.want) {
+				t.Errorf("rankInstances() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -6,16 +6,19 @@
 package tunnel
 
 import (
-	"encoding/json"
 	"fmt"
 	"github.com/DimmKirr/atun/internal/aws"
 	"github.com/DimmKirr/atun/internal/config"
 	"github.com/DimmKirr/atun/internal/logger"
+	"github.com/DimmKirr/atun/internal/schema"
 	"github.com/DimmKirr/atun/internal/ssh"
+	awssdk "github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
 	"log"
 	"net"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -23,11 +26,22 @@ import (
 // GetRouterHostIDFromTags retrieves the Router Endpoint ID from AWS tags.
 // It takes a session, tag name, and tag value as parameters and returns the instance ID of the Router Endpoint.
 func GetRouterHostIDFromTags() (string, error) {
+	routerHostIDs, err := GetRouterHostIDsFromTags()
+	if err != nil {
+		return "", err
+	}
+
+	// Use the best ranked running instance
+	return routerHostIDs[0], nil
+}
+
+// GetRouterHostIDsFromTags retrieves IDs of all running Router Endpoints matching atun.io version and env tags, ranked by RankRouterHosts
+func GetRouterHostIDsFromTags() ([]string, error) {
 	// First try to find router host id from the running processes
 	activeSSHTunnels, err := ssh.GetActiveSSHTunnels()
 	if err != nil {
 		logger.Debug("Error getting running tunnels", "error", err)
-		return "", err
+		return nil, err
 	}
 
 	logger.Debug("Running tunnels", "tunnels", activeSSHTunnels)
@@ -44,42 +58,81 @@ func GetRouterHostIDFromTags() (string, error) {
 		}
 
 		if len(activeOwnedTunnels) < 1 {
-			logger.Debug(fmt.Sprintf("No active tunnels found with the current RouterHostID", "routerHostID", config.App.Config.RouterHostID))
+			logger.Debug("No active tunnels found with the current RouterHostID", "routerHostID", config.App.Config.RouterHostID)
 		}
 	}
 
 	logger.Debug("Getting router host ID. Looking for atun routers.")
 
 	// Build a map of tags to filter instances
-	tags := map[string]string{
-		"atun.io/version": config.App.Version,
-		"atun.io/env":     config.App.Config.Env,
+	tags := map[string][]string{
+		"atun.io/version": schema.Versions,
+		"atun.io/env":     {config.App.Config.Env},
 	}
 
 	instances, err := aws.ListInstancesWithTags(tags)
 	if err != nil {
 		logger.Debug("Error listing instances with tags", "tags", tags)
-		return "", err
-	}
-
-	if len(instances) == 0 {
-		err = fmt.Errorf("no instances found with required tags and in state RUNNING")
-		logger.Debug("Error finding instances", "error", err, "tags", tags)
-		return "", err
+		return nil, err
 	}
 
 	logger.Debug("Found instances", "instances", len(instances))
 
+	var runningInstances []*ec2.Instance
 	for _, instance := range instances {
 		logger.Debug("Found instance", "instance_id", *instance.InstanceId, "state", *instance.State.Name)
 
-		// Use the first running instance found
 		if *instance.InstanceId != "" && *instance.State.Name == "running" {
-			return *instance.InstanceId, err
+			runningInstances = append(runningInstances, instance)
 		}
 	}
 
-	return "", err
+	if len(runningInstances) == 0 {
+		err = fmt.Errorf("no instances found with required tags and in state RUNNING")
+		logger.Debug("Error finding instances", "error", err, "tags", tags)
+		return nil, err
+	}
+
+	// Best router first (e.g. not the one being patched with its SSM agent offline)
+	return RankRouterHosts(runningInstances), nil
+}
+
+// ListRouterHostIDsFromTags returns IDs of routers of the env in any state but terminated, e.g. to list stopped ones too.
+// Running routers go first in the order they are connected to
+func ListRouterHostIDsFromTags() ([]string, error) {
+	tags := map[string][]string{
+		"atun.io/version": schema.Versions,
+		"atun.io/env":     {config.App.Config.Env},
+	}
+
+	instances, err := aws.ListInstancesWithTagsInStates(tags, []string{
+		ec2.InstanceStateNamePending,
+		ec2.InstanceStateNameRunning,
+		ec2.InstanceStateNameStopping,
+		ec2.InstanceStateNameStopped,
+		ec2.InstanceStateNameShuttingDown,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var running []*ec2.Instance
+	var others []string
+	for _, instance := range instances {
+		if awssdk.StringValue(instance.State.Name) == ec2.InstanceStateNameRunning {
+			running = append(running, instance)
+		} else {
+			others = append(others, awssdk.StringValue(instance.InstanceId))
+		}
+	}
+	sort.Strings(others)
+
+	var routerHostIDs []string
+	if len(running) > 0 {
+		routerHostIDs = RankRouterHosts(running)
+	}
+
+	return append(routerHostIDs, others...), nil
 }
 
 // GetRouterHostConfig Gets router host tags and unmarshalls it into a struct
@@ -98,18 +151,28 @@ func GetRouterHostConfig(routerHostID string) (config.Atun, error) {
 
 	logger.Debug("Instance tags", "tags", tags)
 
-	atun := config.Atun{
-		Config: &config.Config{}, // Ensure nested structs are initialized
-	}
-
 	sshUser, err := aws.GetInstanceUsername(routerHostID)
 	if err != nil {
 		logger.Error("Error getting instance username", "instance_id", routerHostID, "error", err)
 		return config.Atun{}, err
 	}
 
+	atun, err := GetRouterConfigFromTags(tags)
+	if err != nil {
+		return config.Atun{}, err
+	}
+
 	atun.Config.RouterHostUser = sshUser
 
+	return atun, nil
+}
+
+// GetRouterConfigFromTags unmarshalls atun.io/* tags (or annotations) of any router type into a struct
+func GetRouterConfigFromTags(tags map[string]string) (config.Atun, error) {
+	atun := config.Atun{
+		Config: &config.Config{}, // Ensure nested structs are initialized
+	}
+
 	for k, v := range tags {
 		// Iterate over the tags and use only atun.io tags
 		if strings.HasPrefix(k, "atun.io") {
@@ -120,40 +183,84 @@ func GetRouterHostConfig(routerHostID string) (config.Atun, error) {
 				atun.Version = v
 			case k == "atun.io/env":
 				atun.Config.Env = v
+			case k == "atun.io/transport":
+				atun.Config.RouterTransport = v
 			case strings.HasPrefix(k, "atun.io/host/"):
-
-				var endpoint config.Endpoint
-
-				err := json.Unmarshal([]byte(v), &endpoint)
+				endpoints, err := schema.ParseHost(tags["atun.io/version"], k, v)
 				if err != nil {
-					logger.Error("Error unmarshalling host tags", "v", v, "host", endpoint.Name, "error", err)
+					// Callers report skipped hosts, so a typo in a tag doesn't drop an endpoint silently
+					logger.Debug("Skipping host tag that can't be read", "key", k, "value", v, "error", err)
+					atun.Config.SkippedHosts = append(atun.Config.SkippedHosts, config.SkippedHost{Key: k, Value: v, Error: err})
 					continue
 				}
 
-				endpoint.Name = strings.TrimPrefix(k, "atun.io/host/")
-
-				// Allocate free local port dynamically if set to 0
-				if endpoint.Local == 0 {
-					if config.App.Config.AutoAllocatePort {
-						port, err := getFreePort()
-						if err != nil {
-							return config.Atun{}, err
-						}
-						endpoint.Local = port
-					} else {
-						err = fmt.Errorf("can't allocate port %d", endpoint.Local)
+				for _, endpoint := range endpoints {
+					// Allocate free local port dynamically if set to 0
+					endpoint, err = allocateLocalPort(endpoint)
+					if err != nil {
 						return config.Atun{}, err
 					}
-				}
 
-				// Append the host to the Hosts config
-				atun.Config.Hosts = append(atun.Config.Hosts, endpoint)
+					// Append the host to the Hosts config
+					atun.Config.Hosts = append(atun.Config.Hosts, endpoint)
+				}
 			}
 		}
 	}
 
 	return atun, nil
+}
+
+// GetRouterConfigFromHosts builds the router config from locally configured endpoints (atun.toml) for routers without atun.io tags
+func GetRouterConfigFromHosts(hosts []config.Endpoint) (config.Atun, error) {
+	atun := config.Atun{
+		Version: config.App.Version,
+		Config: &config.Config{
+			Env: config.App.Config.Env,
+		},
+	}
+
+	for _, host := range hosts {
+		endpoint, err := allocateLocalPort(host)
+		if err != nil {
+			return config.Atun{}, err
+		}
 
+		atun.Config.Hosts = append(atun.Config.Hosts, endpoint)
+	}
+
+	return atun, nil
+}
+
+// CountHostTags returns the number of endpoints (atun.io/host/* tags or annotations) defined on a router
+func CountHostTags(tags map[string]string) int {
+	count := 0
+	for k := range tags {
+		if strings.HasPrefix(k, "atun.io/host/") {
+			count++
+		}
+	}
+
+	return count
+}
+
+// allocateLocalPort assigns a free local port to the endpoint if its local port is 0 and auto-allocation is enabled
+func allocateLocalPort(endpoint config.Endpoint) (config.Endpoint, error) {
+	if endpoint.Local != 0 {
+		return endpoint, nil
+	}
+
+	if !config.App.Config.AutoAllocatePort {
+		return endpoint, fmt.Errorf("can't allocate port %d", endpoint.Local)
+	}
+
+	port, err := getFreePort()
+	if err != nil {
+		return endpoint, err
+	}
+	endpoint.Local = port
+
+	return endpoint, nil
 }
 
 // SetAWSCredentials sets AWS credentials as environment variables
@@ -183,8 +290,11 @@ func SetAWSCredentials(sess *session.Session) error {
 func ActivateTunnel(app *config.Atun) (bool, []ssh.Endpoint, error) {
 	logger.Debug("Starting tunnel", "router", app.Config.RouterHostID, "SSHKeyPath", app.Config.SSHKeyPath, "SSHConfigFile", app.Config.SSHConfigFile, "env", app.Config.Env)
 
-	if err := SetAWSCredentials(app.Session); err != nil {
-		return false, nil, fmt.Errorf("can't start tunnel: %w", err)
+	// Routers that are not reached via AWS (k8s) don't have a session
+	if app.Session != nil {
+		if err := SetAWSCredentials(app.Session); err != nil {
+			return false, nil, fmt.Errorf("can't start tunnel: %w", err)
+		}
 	}
 
 	// Check if tunnel already exists
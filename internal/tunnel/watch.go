@@ -0,0 +1,191 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/DimmKirr/atun/internal/aws"
+	"github.com/DimmKirr/atun/internal/config"
+	"github.com/DimmKirr/atun/internal/logger"
+	"github.com/DimmKirr/atun/internal/schema"
+	"github.com/DimmKirr/atun/internal/ssh"
+)
+
+// WatchRouterHosts polls the router host tags every interval and keeps forwards of the running tunnel in sync with them.
+// If the tunnel is down it calls reconnect instead (which may fail over to another router). It blocks until the context is cancelled.
+func WatchRouterHosts(ctx context.Context, app *config.Atun, interval time.Duration, reconnect func() error) error {
+	logger.Info("Watching router tags for endpoint changes", "router", app.Config.RouterHostID, "interval", interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info("Stopped watching router tags", "router", app.Config.RouterHostID)
+			return nil
+		case <-ticker.C:
+			tunnelActive, _, err := ssh.GetSSHTunnelStatus(app)
+			if err != nil {
+				logger.Warn("Failed to check tunnel status", "router", app.Config.RouterHostID, "error", err)
+				continue
+			}
+
+			if !tunnelActive {
+				logger.Warn("Tunnel is down. Reconnecting", "router", app.Config.RouterHostID)
+				if err := reconnect(); err != nil {
+					logger.Error("Failed to reconnect. Will retry", "error", err)
+					continue
+				}
+				logger.Info("Tunnel reconnected", "router", app.Config.RouterHostID)
+				continue
+			}
+
+			if err := SyncRouterHosts(app); err != nil {
+				// Don't stop watching on transient errors (throttling, network hiccups)
+				logger.Warn("Failed to sync router endpoints", "router", app.Config.RouterHostID, "error", err)
+			}
+		}
+	}
+}
+
+// SyncRouterHosts reads the router host tags once, diffs them against the forwarded endpoints and adds or removes forwards to match.
+// Host tags are read from the EC2 instance, so it works for ec2 routers only
+func SyncRouterHosts(app *config.Atun) error {
+	tags, err := aws.GetInstanceTags(app.Config.RouterHostID)
+	if err != nil {
+		return err
+	}
+
+	keep, remove, add := diffEndpoints(app.Config.Hosts, desiredEndpoints(tags))
+
+	hosts := keep
+	changed := false
+
+	// Endpoints whose forward couldn't be removed stay as they are, their replacement isn't added
+	replaced := map[string]bool{}
+	for _, endpoint := range add {
+		replaced[endpointKey(endpoint)] = true
+	}
+	blocked := map[string]bool{}
+
+	// Remove endpoints that are gone or changed
+	for _, current := range remove {
+		if err := ssh.CancelLocalForward(app, current); err != nil {
+			logger.Error("Failed to remove forward", "host", current.Name, "remote", current.Remote, "local", current.Local, "error", err)
+			hosts = append(hosts, current)
+			blocked[endpointKey(current)] = true
+			continue
+		}
+		changed = true
+
+		if replaced[endpointKey(current)] {
+			logger.Info("Endpoint changed on router, re-forwarding", "host", current.Name, "remote", current.Remote)
+		} else {
+			logger.Info("Endpoint removed from router", "host", current.Name, "remote", current.Remote, "local", current.Local)
+		}
+	}
+
+	// Add new (or changed) endpoints
+	for _, endpoint := range add {
+		if blocked[endpointKey(endpoint)] {
+			continue
+		}
+
+		endpoint, err := allocateLocalPort(endpoint)
+		if err != nil {
+			logger.Error("Can't allocate local port for endpoint", "host", endpoint.Name, "error", err)
+			continue
+		}
+
+		if err := ssh.AddLocalForward(app, endpoint); err != nil {
+			logger.Error("Failed to add forward", "host", endpoint.Name, "remote", endpoint.Remote, "local", endpoint.Local, "error", err)
+			continue
+		}
+		changed = true
+
+		logger.Info("Endpoint added on router", "host", endpoint.Name, "remote", endpoint.Remote, "local", endpoint.Local)
+		hosts = append(hosts, endpoint)
+	}
+
+	app.Config.Hosts = hosts
+
+	if !changed {
+		logger.Debug("Router endpoints are in sync", "router", app.Config.RouterHostID)
+		return nil
+	}
+
+	// Keep the tunnel SSH config in line with the forwards, so a restart picks up the same endpoints
+	app.Config.SSHConfigFile, err = ssh.GenerateSSHConfigFile(app)
+	return err
+}
+
+// desiredEndpoints returns endpoints of the atun.io/host/* tags by endpointKey. Tags that can't be read are skipped
+func desiredEndpoints(tags map[string]string) map[string]config.Endpoint {
+	desired := map[string]config.Endpoint{}
+	for k, v := range tags {
+		if !strings.HasPrefix(k, schema.HostTagPrefix) {
+			continue
+		}
+
+		endpoints, err := schema.ParseHost(tags["atun.io/version"], k, v)
+		if err != nil {
+			logger.Warn("Endpoint is skipped, its tag is invalid. Run atun router validate for details", "key", k, "value", v, "error", err)
+			continue
+		}
+
+		for _, endpoint := range endpoints {
+			desired[endpointKey(endpoint)] = endpoint
+		}
+	}
+
+	return desired
+}
+
+// diffEndpoints splits forwarded endpoints into the ones to keep and the ones to remove (gone or changed),
+// and returns endpoints to add (new or changed) sorted by endpointKey
+func diffEndpoints(current []config.Endpoint, desired map[string]config.Endpoint) ([]config.Endpoint, []config.Endpoint, []config.Endpoint) {
+	var keep, remove, add []config.Endpoint
+
+	pending := maps.Clone(desired)
+	for _, endpoint := range current {
+		target, ok := pending[endpointKey(endpoint)]
+		if ok && !endpointChanged(endpoint, target) {
+			keep = append(keep, endpoint)
+			delete(pending, endpointKey(endpoint))
+			continue
+		}
+
+		remove = append(remove, endpoint)
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(pending)) {
+		add = append(add, pending[key])
+	}
+
+	return keep, remove, add
+}
+
+// endpointKey identifies a forwarded port: a host can have several of them
+func endpointKey(endpoint config.Endpoint) string {
+	return fmt.Sprintf("%s:%d", endpoint.Name, endpoint.Remote)
+}
+
+// endpointChanged reports whether the tag definition no longer matches the forwarded endpoint.
+// A local port of 0 in tags means "auto-allocated", so it matches any local port.
+func endpointChanged(current config.Endpoint, target config.Endpoint) bool {
+	if current.Remote != target.Remote || current.Proto != target.Proto {
+		return true
+	}
+
+	return target.Local != 0 && target.Local != current.Local
+}
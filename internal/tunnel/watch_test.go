@@ -0,0 +1,164 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ * SPDX-FileCopyrightText: © 2025 Dmitry Kireev
+ */
+
+package tunnel
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/DimmKirr/atun/internal/config"
+)
+
+func TestEndpointChanged(t *testing.T) {
+	current := config.Endpoint{Name: "db.internal", Proto: "ssm", Remote: 5432, Local: 15432}
+
+	tests := []struct {
+		name   string
+		target config.Endpoint
+		want   bool
+	}{
+		{name: "same", target: config.Endpoint{Name: "db.internal", Proto: "ssm", Remote: 5432, Local: 15432}, want: false},
+		{name: "auto-allocated local port matches any", target: config.Endpoint{Name: "db.internal", Proto: "ssm", Remote: 5432}, want: false},
+		{name: "other local port", target: config.Endpoint{Name: "db.internal", Proto: "ssm", Remote: 5432, Local: 25432}, want: true},
+		{name: "other remote port", target: config.Endpoint{Name: "db.internal", Proto: "ssm", Remote: 5433, Local: 15432}, want: true},
+		{name: "other transport", target: config.Endpoint{Name: "db.internal", Proto: "ssh", Remote: 5432, Local: 15432}, want: true},
+		// Metadata isn't forwarded, so changing it doesn't re-forward the endpoint
+		{name: "other alias", target: config.Endpoint{Name: "db.internal", Proto: "ssm", Remote: 5432, Local: 15432, Alias: "db"}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := endpointChanged(current, tt.target); got != tt.want {
+				t.Errorf("endpointChanged() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDesiredEndpoints(t *testing.T) {
+	desired := desiredEndpoints(map[string]string{
+		"atun.io/version":             "2",
+		"atun.io/env":                 "dev",
+		"Name":                        "atun-router",
+		"atun.io/host/db.internal":    `{"ports":[{"remote":5432,"local":15432},{"remote":5433,"local":0}],"transport":"ssm"}`,
+		"atun.io/host/cache.internal": `{"ports":[{"remote":6379,"local":16379}]}`,
+		"atun.io/host/broken":         `{"ports":`,
+	})
+
+	var got []string
+	for key := range desired {
+		got = append(got, key)
+	}
+	slices.Sort(got)
+
+	want := []string{"cache.internal:6379", "db.internal:5432", "db.internal:5433"}
+	if !slices.Equal(got, want) {
+		t.Errorf("desiredEndpoints() = %v, want %v", got, want)
+	}
+}
+
+func TestDiffEndpoints(t *testing.T) {
+	db := config.Endpoint{Name: "db.internal", Proto: "ssm", Remote: 5432, Local: 15432}
+	cache := config.Endpoint{Name: "cache.internal", Proto: "ssm", Remote: 6379, Local: 16379}
+	api := config.Endpoint{Name: "api.internal", Proto: "ssm", Remote: 443, Local: 10443}
+
+	endpoints := func(list ...config.Endpoint) map[string]config.Endpoint {
+		desired := map[string]config.Endpoint{}
+		for _, endpoint := range list {
+			desired[endpointKey(endpoint)] = endpoint
+		}
+		return desired
+	}
+	names := func(list []config.Endpoint) []string {
+		var keys []string
+		for _, endpoint := range list {
+			keys = append(keys, endpointKey(endpoint))
+		}
+		return keys
+	}
+
+	dbMoved := db
+	dbMoved.Local = 25432
+	dbAuto := db
+	dbAuto.Local = 0
+
+	tests := []struct {
+		name       string
+		current    []config.Endpoint
+		desired    map[string]config.Endpoint
+		wantKeep   []string
+		wantRemove []string
+		wantAdd    []string
+	}{
+		{
+			name:     "in sync",
+			current:  []config.Endpoint{db, cache},
+			desired:  endpoints(db, cache),
+			wantKeep: []string{"db.internal:5432", "cache.internal:6379"},
+		},
+		{
+			name:     "auto-allocated local port is kept",
+			current:  []config.Endpoint{db},
+			desired:  endpoints(dbAuto),
+			wantKeep: []string{"db.internal:5432"},
+		},
+		{
+			name:     "endpoints added",
+			current:  []config.Endpoint{db},
+			desired:  endpoints(db, cache, api),
+			wantKeep: []string{"db.internal:5432"},
+			wantAdd:  []string{"api.internal:443", "cache.internal:6379"},
+		},
+		{
+			name:       "endpoint removed",
+			current:    []config.Endpoint{db, cache},
+			desired:    endpoints(cache),
+			wantKeep:   []string{"cache.internal:6379"},
+			wantRemove: []string{"db.internal:5432"},
+		},
+		{
+			name:       "changed endpoint is re-forwarded",
+			current:    []config.Endpoint{db, cache},
+			desired:    endpoints(dbMoved, cache),
+			wantKeep:   []string{"cache.internal:6379"},
+			wantRemove: []string{"db.internal:5432"},
+			wantAdd:    []string{"db.internal:5432"},
+		},
+		{
+			name:       "all endpoints removed",
+			current:    []config.Endpoint{db, cache},
+			desired:    endpoints(),
+			wantRemove: []string{"db.internal:5432", "cache.internal:6379"},
+		},
+		{
+			name:    "nothing forwarded yet",
+			desired: endpoints(db),
+			wantAdd: []string{"db.internal:5432"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keep, remove, add := diffEndpoints(tt.current, tt.desired)
+			if got := names(keep); !slices.Equal(got, tt.wantKeep) {
+				t.Errorf("keep = %v, want %v", got, tt.wantKeep)
+			}
+			if got := names(remove); !slices.Equal(got, tt.wantRemove) {
+				t.Errorf("remove = %v, want %v", got, tt.wantRemove)
+			}
+			if got := names(add); !slices.Equal(got, tt.wantAdd) {
+				t.Errorf("add = %v, want %v", got, tt.wantAdd)
+			}
+		})
+	}
+
+	// The desired endpoints are left as they are
+	desired := endpoints(db, cache)
+	diffEndpoints([]config.Endpoint{db}, desired)
+	if len(desired) != 2 {
+		t.Errorf("diffEndpoints() changed the desired endpoints: %v", desired)
+	}
+}
@@ -99,7 +99,6 @@ func RenderAsciiArt() {
 				}
 			}
 		}
-		close(stopChan)
 	}()
 
 	<-stopChan
@@ -10,6 +10,7 @@ import (
 	"github.com/DimmKirr/atun/internal/aws"
 	"github.com/DimmKirr/atun/internal/config"
 	"github.com/DimmKirr/atun/internal/logger"
+	"github.com/DimmKirr/atun/internal/schema"
 	"github.com/DimmKirr/atun/internal/ssh"
 	"github.com/pterm/pterm"
 	"io"
@@ -532,15 +533,36 @@ func RenderRouterTable(routers []config.RouterInfo) {
 
 	// Create the table data
 	tableData := [][]string{
-		{"ID", "TYPE", "STATE", "CREATED"},
+		{"ID", "NAME", "TYPE", "STATE", "ACCOUNT", "REGION", "AZ", "INSTANCE TYPE", "VPC / SUBNET", "LAUNCHED", "ENV", "ENDPOINTS", "SSM", "AGENT", "AD-HOC"},
 	}
 
 	for _, router := range routers {
+		network := ""
+		if router.VPCID != "" || router.SubnetID != "" {
+			network = fmt.Sprintf("%s / %s", router.VPCID, router.SubnetID)
+		}
+
+		launched := ""
+		if !router.CreatedAt.IsZero() {
+			launched = router.CreatedAt.Format(time.RFC3339)
+		}
+
 		tableData = append(tableData, []string{
 			router.ID,
+			router.Name,
 			router.Type,
 			router.State,
-			router.CreatedAt.Format(time.RFC3339),
+			router.Account,
+			router.Region,
+			router.Zone,
+			router.InstanceType,
+			network,
+			launched,
+			router.Env,
+			fmt.Sprintf("%d", router.Endpoints),
+			router.PingStatus,
+			router.AgentVersion,
+			map[bool]string{true: "yes", false: "no"}[router.AdHoc],
 		})
 	}
 
@@ -575,3 +597,60 @@ func RenderDetailedStatus() {
 	logger.Debug("Status command finished")
 
 }
+
+// RenderAuthorizedKeysTable renders keys from authorized_keys of routers, grouped by router ID
+func RenderAuthorizedKeysTable(keys map[string][]ssh.AuthorizedKey) {
+	routerIDs := make([]string, 0, len(keys))
+	for routerID := range keys {
+		routerIDs = append(routerIDs, routerID)
+	}
+	sort.Strings(routerIDs)
+
+	tableData := [][]string{
+		{"ROUTER", "FINGERPRINT", "TYPE", "COMMENT", "ADDED", "AGE"},
+	}
+
+	for _, routerID := range routerIDs {
+		for _, key := range keys[routerID] {
+			added, age := "", ""
+			if key.Session {
+				added = key.Created.Format(time.RFC3339)
+				age = key.Age().Round(time.Minute).String()
+			}
+
+			tableData = append(tableData, []string{routerID, key.Fingerprint, key.Type, key.Comment, added, age})
+		}
+	}
+
+	if len(tableData) == 1 {
+		logger.Info("No keys found")
+		return
+	}
+
+	pterm.DefaultTable.WithHasHeader().WithData(tableData).Render()
+}
+
+// RenderViolationsTable renders problems found in tags of routers, grouped by router ID
+func RenderViolationsTable(violations map[string][]schema.Violation) {
+	routerIDs := make([]string, 0, len(violations))
+	for routerID := range violations {
+		routerIDs = append(routerIDs, routerID)
+	}
+	sort.Strings(routerIDs)
+
+	tableData := [][]string{
+		{"ROUTER", "TAG", "PROBLEM", "FIX"},
+	}
+
+	for _, routerID := range routerIDs {
+		for _, violation := range violations[routerID] {
+			tableData = append(tableData, []string{routerID, violation.Key, violation.Problem, violation.Fix})
+		}
+	}
+
+	if len(tableData) == 1 {
+		return
+	}
+
+	pterm.DefaultTable.WithHasHeader().WithData(tableData).Render()
+}
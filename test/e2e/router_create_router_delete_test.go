@@ -192,7 +192,7 @@ func TestAtunRouterCreateRouterDelete(t *testing.T) {
 			runAtunCommand(t, setup.workDir, "router create", tt.interactive, tt.envVars)
 
 			// Verify EC2 Instance Exists
-			instanceID := verifyEC2Instance(t, setup.ec2Client, "atun.io/version", "1")
+			instanceID := verifyEC2Instance(t, setup.ec2Client, "atun.io/version", "2")
 			t.Logf("EC2 instance created successfully with ID: %s", instanceID)
 
 			// Run delete command
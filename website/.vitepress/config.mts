@@ -26,12 +26,16 @@ export default defineConfig({
         items: [
           { text: 'Introduction', link: '/guide/' },
           { text: 'Quick Start', link: '/guide/quickstart' },
+          { text: 'AWS Authentication', link: '/guide/aws-auth' },
         ]
       },
       {
         text: 'Features',
         items: [
           { text: 'EC2 Router', link: '/guide/ec2-router' },
+          { text: 'Kubernetes Router', link: '/guide/k8s-router' },
+          { text: 'ECS Router', link: '/guide/ecs-router' },
+          { text: 'SSH Bastion Router', link: '/guide/ssh-router' },
           { text: 'Tag Schema', link: '/guide/tag-schema' }
         ]
       },